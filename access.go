@@ -0,0 +1,106 @@
+package scopes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultAccessReportLimit is the number of Scopes an access report
+	// will include if no limit is specified.
+	DefaultAccessReportLimit = 100
+
+	// MaxAccessReportScan is the number of Scopes UsableScopes will check
+	// in a single call, when the Storer can't filter them itself. If that
+	// many Scopes are checked before the report is full, the report ends
+	// early, with Next set to the last Scope checked.
+	MaxAccessReportScan = 1000
+)
+
+var (
+	// ErrNoPrincipal is returned when an access report is requested
+	// without specifying a user or a client to report on.
+	ErrNoPrincipal = errors.New("a user ID or client ID is required")
+)
+
+// AccessQuery describes whose access an access report should describe. At
+// least one of UserID and ClientID must be set. If both are set, only the
+// Scopes both the user and the client can use are reported.
+type AccessQuery struct {
+	UserID   string
+	ClientID string
+}
+
+// AccessReport is a single page of the Scopes an AccessQuery's principals can
+// use. If Next is not empty, more Scopes may be usable, and can be retrieved
+// by passing Next as the cursor for the next page.
+type AccessReport struct {
+	Scopes []Scope
+	Next   string
+}
+
+// UsableScopes returns up to `limit` of the Scopes the principals described
+// by `query` can use, sorted lexicographically by their ID, starting after
+// the Scope whose ID is `cursor`. Every Scope is checked against its policies,
+// so DEFAULT_ALLOW and ALLOW_ALL Scopes are included unless they exclude the
+// principals. If `storer` is an AccessChecker, it filters the Scopes itself,
+// unless it returns ErrAccessCheckUnsupported; otherwise Scopes are read from `storer` a page at a time, so the report
+// never holds more than a page of Scopes in memory, and no more than
+// MaxAccessReportScan Scopes are read, so a report may have fewer than `limit`
+// Scopes even when Next is set.
+func UsableScopes(ctx context.Context, storer Storer, query AccessQuery, cursor string, limit int) (AccessReport, error) {
+	if query.UserID == "" && query.ClientID == "" {
+		return AccessReport{}, ErrNoPrincipal
+	}
+	if limit < 1 {
+		limit = DefaultAccessReportLimit
+	}
+	if checker, ok := storer.(AccessChecker); ok {
+		usable, err := checker.ListUsable(ctx, query, cursor, limit)
+		if err == nil {
+			report := AccessReport{Scopes: usable}
+			if len(usable) >= limit {
+				report.Next = usable[len(usable)-1].ID
+			}
+			return report, nil
+		}
+		// decorators are always AccessCheckers, even when the Storer
+		// they wrap isn't, so fall back to reading the Scopes
+		if !errors.Is(err, ErrAccessCheckUnsupported) {
+			return AccessReport{}, fmt.Errorf("error listing usable scopes: %w", err)
+		}
+	}
+	var report AccessReport
+	var scanned int
+	for {
+		page, err := storer.List(ctx, cursor, limit)
+		if err != nil {
+			return AccessReport{}, fmt.Errorf("error listing scopes: %w", err)
+		}
+		for _, scope := range page {
+			cursor = scope.ID
+			scanned++
+			// a report isn't an access decision, so the checks
+			// aren't recorded
+			if query.UserID != "" && !userCanUseScope(ctx, scope, query.UserID) {
+				continue
+			}
+			if query.ClientID != "" && !clientCanUseScope(ctx, scope, query.ClientID) {
+				continue
+			}
+			report.Scopes = append(report.Scopes, scope)
+			if len(report.Scopes) >= limit {
+				report.Next = cursor
+				return report, nil
+			}
+		}
+		if len(page) < limit {
+			return report, nil
+		}
+		if scanned >= MaxAccessReportScan {
+			report.Next = cursor
+			return report, nil
+		}
+	}
+}
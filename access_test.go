@@ -0,0 +1,52 @@
+package scopes_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+)
+
+func TestUsableScopesScanLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var contents []scopes.Scope
+	for i := 0; i < scopes.MaxAccessReportScan+10; i++ {
+		contents = append(contents, scopes.Scope{
+			ID:           fmt.Sprintf("https://scopes.impractical.co/denied/%04d", i),
+			UserPolicy:   scopes.PolicyDenyAll,
+			ClientPolicy: scopes.PolicyDenyAll,
+		})
+	}
+	usable := scopes.Scope{
+		ID:           "https://scopes.impractical.co/usable",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	contents = append(contents, usable)
+	storer := newMemoryStorer(t, ctx, contents...)
+
+	query := scopes.AccessQuery{UserID: "user"}
+	report, err := scopes.UsableScopes(ctx, storer, query, "", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error generating report: %s", err)
+	}
+	if len(report.Scopes) != 0 {
+		t.Errorf("Expected no usable scopes in the first %d, got %v", scopes.MaxAccessReportScan, report.Scopes)
+	}
+	if expected := contents[scopes.MaxAccessReportScan-1].ID; report.Next != expected {
+		t.Errorf("Expected the report to stop at %q, got %q", expected, report.Next)
+	}
+
+	report, err = scopes.UsableScopes(ctx, storer, query, report.Next, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error generating report: %s", err)
+	}
+	if diff := cmp.Diff(scopes.AccessReport{Scopes: []scopes.Scope{usable}}, report); diff != "" {
+		t.Errorf("Unexpected diff in report (-wanted, +got): %s", diff)
+	}
+}
@@ -3,6 +3,7 @@ package apiv1
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"io"
 	"io/ioutil"
 	"net/http"
 
@@ -11,6 +12,7 @@ import (
 
 	"lockbox.dev/hmac"
 	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/webhooks"
 )
 
 // APIv1 holds all the information that we want to
@@ -21,6 +23,34 @@ type APIv1 struct {
 	scopes.Dependencies
 	Log    *yall.Logger
 	Signer hmac.Signer
+
+	// Webhooks, if set, enables the endpoints for
+	// managing Webhooks.
+	Webhooks webhooks.Storer
+
+	// Middleware, if set, wraps the handler of every
+	// endpoint, with the Trout-Pattern header set to
+	// the endpoint's pattern. It's meant for recording
+	// metrics, like the Middleware method of
+	// metrics.Metrics.
+	Middleware func(http.Handler) http.Handler
+
+	// MaxImportSize is the largest body, in bytes, that
+	// POST /import accepts. Imports are signed as a whole,
+	// so the entire body has to be read before any of it
+	// can be trusted. If not set, DefaultMaxImportSize is
+	// used.
+	MaxImportSize int64
+}
+
+// DefaultMaxImportSize is the largest body, in bytes, that POST /import
+// accepts if APIv1.MaxImportSize isn't set.
+const DefaultMaxImportSize = 32 << 20
+
+// ContentHash returns the hash of `payload` that requests are expected to be
+// signed with.
+func ContentHash(payload string) string {
+	return base64.StdEncoding.EncodeToString(sha256.New().Sum([]byte(payload)))
 }
 
 // VerifyRequest calculates the HMAC signature of `r` and compares it to
@@ -34,27 +64,70 @@ func (a APIv1) VerifyRequest(r *http.Request) (string, *Response) {
 	// this is, in theory, vulnerable to replay attacks
 	// but if deployed over TLS, it shouldn't matter
 	var payload string
-	if r.Method == "POST" || r.Method == "PUT" {
-		defer func() {
-			if err := r.Body.Close(); err != nil {
-				a.Log.WithError(err).Error("error closing request body")
-			}
-		}()
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			a.Log.WithError(err).Error("error reading request")
-			return "", &Response{
-				Errors: api.ActOfGodError,
-				Status: http.StatusInternalServerError,
-			}
+	if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+		var resp *Response
+		payload, resp = a.readBody(r)
+		if resp != nil {
+			return "", resp
 		}
-		payload = string(body)
 	}
-	hash := base64.StdEncoding.EncodeToString(sha256.New().Sum([]byte(payload)))
-	err := a.Signer.AuthenticateRequest(r, hash)
+	return payload, a.VerifyPayload(r, payload)
+}
+
+// readBody reads and closes the body of `r`, returning it as a string, or a
+// Response indicating the error if it can't be read.
+func (a APIv1) readBody(r *http.Request) (string, *Response) {
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			a.Log.WithError(err).Error("error closing request body")
+		}
+	}()
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		a.Log.WithError(err).Debug("failed to authenticate request")
+		a.Log.WithError(err).Error("error reading request")
 		return "", &Response{
+			Errors: api.ActOfGodError,
+			Status: http.StatusInternalServerError,
+		}
+	}
+	return string(body), nil
+}
+
+// readLimitedBody is like readBody, but returns a Response indicating the
+// body is too large, without reading the rest of it, if it's longer than
+// `limit` bytes.
+func (a APIv1) readLimitedBody(r *http.Request, limit int64) (string, *Response) {
+	r.Body = limitedBody{Reader: io.LimitReader(r.Body, limit+1), Closer: r.Body}
+	body, resp := a.readBody(r)
+	if resp != nil {
+		return "", resp
+	}
+	if int64(len(body)) > limit {
+		return "", &Response{
+			Errors: []api.RequestError{{Slug: api.RequestErrOverflow}},
+			Status: http.StatusRequestEntityTooLarge,
+		}
+	}
+	return body, nil
+}
+
+// limitedBody is a request body that reads from a limited Reader, but still
+// closes the original body.
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// VerifyPayload calculates the HMAC signature of `r` and compares it to the
+// passed Authorization header, using `payload` as the signed content. It is
+// meant for requests without a body, where the content being signed is
+// derived from the request instead. If the returned Response is not nil, it
+// is meant to be returned, short-circuiting the request.
+func (a APIv1) VerifyPayload(r *http.Request, payload string) *Response {
+	err := a.Signer.AuthenticateRequest(r, ContentHash(payload))
+	if err != nil {
+		a.Log.WithError(err).Debug("failed to authenticate request")
+		return &Response{
 			Errors: []api.RequestError{{
 				Header: "Authorization",
 				Slug:   api.RequestErrAccessDenied,
@@ -62,13 +135,22 @@ func (a APIv1) VerifyRequest(r *http.Request) (string, *Response) {
 			Status: http.StatusUnauthorized,
 		}
 	}
-	return payload, nil
+	return nil
 }
 
+// RequestErrUnsupported is the slug of the error in 501 Not Implemented
+// responses, returned when the Storer can't do what was requested, like
+// applying a batch or watching for changes.
+const RequestErrUnsupported = "unsupported"
+
 // Response is used to encode JSON responses; it is
 // the global response format for all API responses.
 type Response struct {
-	Scopes []Scope            `json:"scopes,omitempty"`
-	Errors []api.RequestError `json:"errors,omitempty"`
-	Status int                `json:"-"`
+	Scopes     []Scope            `json:"scopes,omitempty"`
+	Webhooks   []Webhook          `json:"webhooks,omitempty"`
+	Deliveries []Delivery         `json:"deliveries,omitempty"`
+	Imported   *ImportResult      `json:"imported,omitempty"`
+	Next       string             `json:"next,omitempty"`
+	Errors     []api.RequestError `json:"errors,omitempty"`
+	Status     int                `json:"-"`
 }
@@ -0,0 +1,60 @@
+package apiv1_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"darlinggo.co/api"
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func TestBatchUnsupportedStorer(t *testing.T) {
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	// unwatchableStorer only has the Storer methods, so it's not a
+	// Transactor or a Batcher either
+	server, signer := newEventsServer(t, unwatchableStorer{Storer: backend})
+
+	allow := scopes.PolicyAllowAll
+	body, err := json.Marshal(apiv1.Batch{Operations: []apiv1.Operation{{
+		Type:         string(scopes.OperationCreate),
+		ID:           "https://scopes.impractical.co/batch",
+		UserPolicy:   &allow,
+		ClientPolicy: &allow,
+	}}})
+	if err != nil {
+		t.Fatalf("Error encoding batch: %s", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/batch", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash(string(body))))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, resp.StatusCode)
+	}
+	var got apiv1.Response
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Error decoding response: %s", err)
+	}
+	if diff := cmp.Diff(apiv1.Response{Errors: []api.RequestError{{Slug: apiv1.RequestErrUnsupported}}}, got); diff != "" {
+		t.Errorf("Unexpected diff in response (-wanted, +got): %s", diff)
+	}
+}
@@ -0,0 +1,70 @@
+package apiv1_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"darlinggo.co/api"
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func TestCreateReservedID(t *testing.T) {
+	ctx := context.Background()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, storer)
+
+	allow := scopes.PolicyAllowAll
+	body, err := json.Marshal(apiv1.Scope{ID: "export", UserPolicy: allow, ClientPolicy: allow})
+	if err != nil {
+		t.Fatalf("Error encoding scope: %s", err)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		path := "/"
+		if method == http.MethodPut {
+			path = "/export"
+		}
+		req, err := http.NewRequestWithContext(ctx, method, server.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error creating request: %s", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash(string(body))))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error making request: %s", err)
+		}
+		// validation errors are encoded on their own, not in a Response
+		var got []api.RequestError
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Error decoding %s response: %s", method, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d from %s, got %d", http.StatusBadRequest, method, resp.StatusCode)
+		}
+		if diff := cmp.Diff([]api.RequestError{{Field: "/id", Slug: api.RequestErrInvalidValue}}, got); diff != "" {
+			t.Errorf("Unexpected diff in %s response (-wanted, +got): %s", method, diff)
+		}
+	}
+
+	results, err := storer.GetMulti(ctx, []string{"export"})
+	if err != nil {
+		t.Fatalf("Error retrieving scope: %s", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no scope named export to be stored, got %+v", results)
+	}
+}
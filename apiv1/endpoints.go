@@ -2,6 +2,7 @@ package apiv1
 
 import (
 	"net/http"
+	"path"
 	"strings"
 
 	"darlinggo.co/api"
@@ -24,23 +25,85 @@ func logEndpoint(h http.Handler) http.Handler {
 	})
 }
 
+// endpoint returns the http.Handler every endpoint is served by, wrapping `h`
+// with logEndpoint and, if it's set, a.Middleware. Endpoints are wrapped
+// inside the router, so the Trout-Pattern header is available to both.
+func (a APIv1) endpoint(h http.HandlerFunc) http.Handler {
+	handler := logEndpoint(h)
+	if a.Middleware != nil {
+		handler = a.Middleware(handler)
+	}
+	return handler
+}
+
 // Server returns an http.Handler that will handle all
 // the requests for v1 of the API. The baseURL should be
 // set to whatever prefix the muxer matches to pass requests
 // to the Handler; consider it the root path of v1 of the API.
+//
+// GET /export streams every Scope as JSON Lines, and POST
+// /import imports them. GET /events streams changes to
+// Scopes as Server-Sent Events, if the Storer implements
+// scopes.Watcher, and responds with 501 Not Implemented
+// otherwise. If Webhooks is set, the /webhooks endpoints
+// will manage Webhooks. These endpoints share paths with
+// Scopes, so Scopes can't have their IDs; see
+// scopes.IsReservedID.
 func (a APIv1) Server(baseURL string) http.Handler {
 	var router trout.Router
 	router.SetPrefix(baseURL)
 	router.Endpoint("/").Methods("GET").
-		Handler(logEndpoint(http.HandlerFunc(a.handleListScopes)))
+		Handler(a.endpoint(a.handleListScopes))
 	router.Endpoint("/").Methods("POST").
-		Handler(logEndpoint(http.HandlerFunc(a.handleCreateScope)))
+		Handler(a.endpoint(a.handleCreateScope))
+	router.Endpoint("/batch").Methods("POST").
+		Handler(a.endpoint(a.handleBatch))
 	router.Endpoint("/{id}").Methods("GET").
-		Handler(logEndpoint(http.HandlerFunc(a.handleGetScope)))
+		Handler(a.endpoint(a.handleGetScope))
 	router.Endpoint("/{id}").Methods("DELETE").
-		Handler(logEndpoint(http.HandlerFunc(a.handleDeleteScope)))
+		Handler(a.endpoint(a.handleDeleteScope))
 	router.Endpoint("/{id}").Methods("PATCH").
-		Handler(logEndpoint(http.HandlerFunc(a.handleUpdateScope)))
+		Handler(a.endpoint(a.handleUpdateScope))
+	router.Endpoint("/{id}").Methods("PUT").
+		Handler(a.endpoint(a.handlePutScope))
+	router.Endpoint("/import").Methods("POST").
+		Handler(a.endpoint(a.handleImport))
+	router.Endpoint("/access").Methods("GET").
+		Handler(a.endpoint(a.handleAccessReport))
+	router.Endpoint("/exceptions/users/{id}").Methods("DELETE").
+		Handler(a.endpoint(a.handleRemoveUserException))
+	router.Endpoint("/exceptions/clients/{id}").Methods("DELETE").
+		Handler(a.endpoint(a.handleRemoveClientException))
+
+	if a.Webhooks != nil {
+		router.Endpoint("/webhooks").Methods("GET").
+			Handler(a.endpoint(a.handleListWebhooks))
+		router.Endpoint("/webhooks").Methods("POST").
+			Handler(a.endpoint(a.handleCreateWebhook))
+		router.Endpoint("/webhooks/dead-letters").Methods("GET").
+			Handler(a.endpoint(a.handleListDeadDeliveries))
+		router.Endpoint("/webhooks/{id}").Methods("DELETE").
+			Handler(a.endpoint(a.handleDeleteWebhook))
+	}
+
+	negotiated := api.NegotiateMiddleware(router)
 
-	return api.NegotiateMiddleware(router)
+	// exports and event streams aren't JSON, so they can't go
+	// through content negotiation and get their own router
+	var streams trout.Router
+	streams.SetPrefix(baseURL)
+	streamPaths := map[string]bool{}
+	streams.Endpoint("/export").Methods("GET").
+		Handler(a.endpoint(a.handleExport))
+	streamPaths[path.Join("/", baseURL, "export")] = true
+	streams.Endpoint("/events").Methods("GET").
+		Handler(a.endpoint(a.handleEvents))
+	streamPaths[path.Join("/", baseURL, "events")] = true
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && streamPaths[strings.TrimSuffix(r.URL.Path, "/")] {
+			streams.ServeHTTP(w, r)
+			return
+		}
+		negotiated.ServeHTTP(w, r)
+	})
 }
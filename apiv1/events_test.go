@@ -0,0 +1,271 @@
+package apiv1_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"darlinggo.co/api"
+	"github.com/google/go-cmp/cmp"
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+// unwatchableStorer is a Storer that doesn't implement scopes.Watcher.
+type unwatchableStorer struct {
+	scopes.Storer
+}
+
+func newEventsServer(t *testing.T, storer scopes.Storer) (*httptest.Server, hmac.Signer) {
+	t.Helper()
+	signer := hmac.Signer{Key: "events-key", Secret: []byte("events-secret"), MaxSkew: time.Minute}
+	v1 := apiv1.APIv1{
+		Dependencies: scopes.Dependencies{Storer: storer},
+		Log:          yall.New(colour.New(ioutil.Discard, yall.Error)),
+		Signer:       signer,
+	}
+	server := httptest.NewServer(v1.Server("/"))
+	t.Cleanup(server.Close)
+	return server, signer
+}
+
+// eventsRequest returns a GET /events request signed by `signer`, or
+// unsigned if `signer` is nil.
+func eventsRequest(ctx context.Context, t *testing.T, server *httptest.Server, signer *hmac.Signer, lastEventID string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if signer != nil {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash("EVENTS,")))
+	}
+	return req
+}
+
+// readEvent reads the next event from a Server-Sent Events stream, skipping
+// comments, and returns its ID and decoded data.
+func readEvent(t *testing.T, stream *bufio.Reader) (string, apiv1.Event) {
+	t.Helper()
+	var id string
+	var event apiv1.Event
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading event stream: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+			if err != nil {
+				t.Fatalf("Error decoding event data %q: %s", line, err)
+			}
+		}
+	}
+}
+
+func createWatchedScopes(ctx context.Context, t *testing.T, storer scopes.Storer, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		err := storer.Create(ctx, scopes.Scope{ID: id, UserPolicy: scopes.PolicyAllowAll, ClientPolicy: scopes.PolicyAllowAll})
+		if err != nil {
+			t.Fatalf("Error creating scope %q: %s", id, err)
+		}
+	}
+}
+
+func TestEventsRequiresAuthentication(t *testing.T) {
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, _ := newEventsServer(t, storer)
+	wrongSigner := hmac.Signer{Key: "events-key", Secret: []byte("wrong-secret"), MaxSkew: time.Minute}
+
+	for name, signer := range map[string]*hmac.Signer{"unsigned": nil, "wrong secret": &wrongSigner} {
+		resp, err := http.DefaultClient.Do(eventsRequest(context.Background(), t, server, signer, ""))
+		if err != nil {
+			t.Fatalf("%s: error making request: %s", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+}
+
+func TestEventsResumesFromLastEventID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, storer)
+	createWatchedScopes(ctx, t, storer, "https://scopes.impractical.co/first", "https://scopes.impractical.co/second")
+
+	resp, err := http.DefaultClient.Do(eventsRequest(ctx, t, server, &signer, "1"))
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %q", contentType)
+	}
+	stream := bufio.NewReader(resp.Body)
+	id, event := readEvent(t, stream)
+	if id != "2" || event.Scope.ID != "https://scopes.impractical.co/second" {
+		t.Errorf("Expected to resume with event 2 for the second scope, got event %s for %q", id, event.Scope.ID)
+	}
+
+	// new events keep arriving after the ones being caught up on
+	createWatchedScopes(ctx, t, storer, "https://scopes.impractical.co/third")
+	id, event = readEvent(t, stream)
+	if id != "3" || event.Scope.ID != "https://scopes.impractical.co/third" {
+		t.Errorf("Expected event 3 for the third scope, got event %s for %q", id, event.Scope.ID)
+	}
+}
+
+func TestEventsQueryAuthentication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, storer)
+	createWatchedScopes(ctx, t, storer, "https://scopes.impractical.co/browser")
+
+	// sign the request as usual, then move the headers into the query,
+	// the way a browser's EventSource would have to send them
+	signed := eventsRequest(ctx, t, server, &signer, "")
+	query := url.Values{}
+	query.Set("authorization", signed.Header.Get("Authorization"))
+	query.Set("date", signed.Header.Get("Date"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	id, _ := readEvent(t, bufio.NewReader(resp.Body))
+	if id != "1" {
+		t.Errorf("Expected event 1, got %s", id)
+	}
+}
+
+func TestEventsCompacted(t *testing.T) {
+	ctx := context.Background()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, storer)
+	createWatchedScopes(ctx, t, storer, "https://scopes.impractical.co/compacted")
+	// the memory Storer retains 1024 events
+	for i := 0; i < 1100; i++ {
+		isDefault := i%2 == 0
+		_, err = storer.Update(ctx, "https://scopes.impractical.co/compacted", scopes.Change{IsDefault: &isDefault})
+		if err != nil {
+			t.Fatalf("Error updating scope: %s", err)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(eventsRequest(ctx, t, server, &signer, "1"))
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("Expected status %d, got %d", http.StatusGone, resp.StatusCode)
+	}
+}
+
+func TestEventsUnwatchableStorer(t *testing.T) {
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, unwatchableStorer{Storer: backend})
+	resp, err := http.DefaultClient.Do(eventsRequest(context.Background(), t, server, &signer, ""))
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, resp.StatusCode)
+	}
+	var got apiv1.Response
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Error decoding response: %s", err)
+	}
+	if diff := cmp.Diff(apiv1.Response{Errors: []api.RequestError{{Slug: apiv1.RequestErrUnsupported}}}, got); diff != "" {
+		t.Errorf("Unexpected diff in response (-wanted, +got): %s", diff)
+	}
+}
+
+func TestEventsOnlyRoutesGet(t *testing.T) {
+	ctx := context.Background()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	server, signer := newEventsServer(t, storer)
+	// the ID is reserved now, but Scopes stored before it was can still
+	// be deleted
+	createWatchedScopes(ctx, t, storer, "events")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash("DELETE,events")))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d deleting the scope named events, got %d", http.StatusOK, resp.StatusCode)
+	}
+	results, err := storer.GetMulti(ctx, []string{"events"})
+	if err != nil {
+		t.Fatalf("Error retrieving scope: %s", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected the scope named events to be deleted, got %+v", results)
+	}
+}
@@ -1,31 +1,33 @@
 package apiv1
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"darlinggo.co/api"
 	"darlinggo.co/trout/v2"
+	uuid "github.com/hashicorp/go-uuid"
 	yall "yall.in"
 
 	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/webhooks"
 )
 
-func (a APIv1) handleCreateScope(w http.ResponseWriter, r *http.Request) {
-	input, resp := a.VerifyRequest(r)
-	if resp != nil {
-		api.Encode(w, r, resp.Status, resp)
-		return
-	}
-	var body Scope
-	err := json.Unmarshal([]byte(input), &body)
-	if err != nil {
-		yall.FromContext(r.Context()).WithError(err).Debug("Error decoding request body")
-		api.Encode(w, r, http.StatusBadRequest, Response{Errors: api.InvalidFormatError})
-		return
-	}
-	scope := coreScope(body)
+// validateScope returns the problems with `scope` that keep it from being
+// stored.
+func validateScope(scope scopes.Scope) []api.RequestError {
 	var reqErrs []api.RequestError
 
 	// ClientPolicy must be set and valid
@@ -38,13 +40,46 @@ func (a APIv1) handleCreateScope(w http.ResponseWriter, r *http.Request) {
 	// UserPolicy must be set and valid
 	if scope.UserPolicy == "" {
 		reqErrs = append(reqErrs, api.RequestError{Field: "/userPolicy", Slug: api.RequestErrMissing})
-	} else if scope.UserPolicy != "" && !scopes.IsValidPolicy(scope.UserPolicy) {
+	} else if !scopes.IsValidPolicy(scope.UserPolicy) {
 		reqErrs = append(reqErrs, api.RequestError{Field: "/userPolicy", Slug: api.RequestErrInvalidValue})
 	}
 
 	if scope.ID == "" {
 		reqErrs = append(reqErrs, api.RequestError{Field: "/id", Slug: api.RequestErrMissing})
+	} else if scopes.IsReservedID(scope.ID) {
+		reqErrs = append(reqErrs, api.RequestError{Field: "/id", Slug: api.RequestErrInvalidValue})
+	}
+	return append(reqErrs, validateExceptions(scopes.Change{UserExceptions: &scope.UserExceptions, ClientExceptions: &scope.ClientExceptions})...)
+}
+
+// validateExceptions returns the problems with the exceptions `change` sets,
+// which can't list empty IDs.
+func validateExceptions(change scopes.Change) []api.RequestError {
+	var reqErrs []api.RequestError
+	if scopes.ValidateChangeIDs(scopes.Change{UserExceptions: change.UserExceptions}) != nil {
+		reqErrs = append(reqErrs, api.RequestError{Field: "/userExceptions", Slug: api.RequestErrInvalidValue})
+	}
+	if scopes.ValidateChangeIDs(scopes.Change{ClientExceptions: change.ClientExceptions}) != nil {
+		reqErrs = append(reqErrs, api.RequestError{Field: "/clientExceptions", Slug: api.RequestErrInvalidValue})
+	}
+	return reqErrs
+}
+
+func (a APIv1) handleCreateScope(w http.ResponseWriter, r *http.Request) {
+	input, resp := a.VerifyRequest(r)
+	if resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	var body Scope
+	err := json.Unmarshal([]byte(input), &body)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Debug("Error decoding request body")
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: api.InvalidFormatError})
+		return
 	}
+	scope := coreScope(body)
+	reqErrs := validateScope(scope)
 	if len(reqErrs) > 0 {
 		api.Encode(w, r, http.StatusBadRequest, reqErrs)
 		return
@@ -63,6 +98,54 @@ func (a APIv1) handleCreateScope(w http.ResponseWriter, r *http.Request) {
 	api.Encode(w, r, http.StatusCreated, Response{Scopes: []Scope{apiScope(scope)}})
 }
 
+func (a APIv1) handlePutScope(w http.ResponseWriter, r *http.Request) {
+	vars := trout.RequestVars(r)
+	id := vars.Get("id")
+	if id == "" {
+		api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrMissing}}})
+		return
+	}
+
+	input, resp := a.VerifyRequest(r)
+	if resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	var body Scope
+	err := json.Unmarshal([]byte(input), &body)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Debug("Error decoding request body")
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: api.InvalidFormatError})
+		return
+	}
+	scope := coreScope(body)
+
+	// the ID comes from the URL, but can be repeated in the body
+	if scope.ID == "" {
+		scope.ID = id
+	}
+	reqErrs := validateScope(scope)
+	if scope.ID != id {
+		reqErrs = append(reqErrs, api.RequestError{Field: "/id", Slug: api.RequestErrInvalidValue})
+	}
+	if len(reqErrs) > 0 {
+		api.Encode(w, r, http.StatusBadRequest, reqErrs)
+		return
+	}
+	created, err := a.Storer.Put(r.Context(), scope)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error storing scope")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	yall.FromContext(r.Context()).WithField("scope_id", scope.ID).WithField("created", created).Debug("scope stored")
+	api.Encode(w, r, status, Response{Scopes: []Scope{apiScope(scope)}})
+}
+
 func (a APIv1) handleUpdateScope(w http.ResponseWriter, r *http.Request) {
 	vars := trout.RequestVars(r)
 	id := vars.Get("id")
@@ -96,30 +179,127 @@ func (a APIv1) handleUpdateScope(w http.ResponseWriter, r *http.Request) {
 	if change.ClientPolicy != nil && !scopes.IsValidPolicy(*change.ClientPolicy) {
 		reqErrs = append(reqErrs, api.RequestError{Field: "/clientPolicy", Slug: api.RequestErrInvalidValue})
 	}
+	reqErrs = append(reqErrs, validateExceptions(change)...)
 
 	if len(reqErrs) > 0 {
 		api.Encode(w, r, http.StatusBadRequest, reqErrs)
 		return
 	}
-	err = a.Storer.Update(r.Context(), id, change)
+	scope, err := a.Storer.Update(r.Context(), id, change)
 	if err != nil {
+		if errors.Is(err, scopes.ErrScopeNotFound) {
+			api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrNotFound}}})
+			return
+		}
 		yall.FromContext(r.Context()).WithError(err).Error("Error updating scope")
 		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
 		return
 	}
 	yall.FromContext(r.Context()).WithField("scope_id", id).Debug("scope updated")
-	scops, err := a.Storer.GetMulti(r.Context(), []string{id})
+	api.Encode(w, r, http.StatusOK, Response{Scopes: []Scope{apiScope(scope)}})
+}
+
+// maxBatchOperations is the most Operations a single batch can hold.
+const maxBatchOperations = 1000
+
+// validateOperation returns the problems with `op` that keep it from being
+// applied, with fields relative to `prefix`.
+func validateOperation(prefix string, op Operation) []api.RequestError {
+	var reqErrs []api.RequestError
+	switch scopes.OperationType(op.Type) {
+	case scopes.OperationCreate:
+		reqErrs = validateScope(coreOperation(op).Scope)
+	case scopes.OperationUpdate:
+		if op.UserPolicy != nil && !scopes.IsValidPolicy(*op.UserPolicy) {
+			reqErrs = append(reqErrs, api.RequestError{Field: "/userPolicy", Slug: api.RequestErrInvalidValue})
+		}
+		if op.ClientPolicy != nil && !scopes.IsValidPolicy(*op.ClientPolicy) {
+			reqErrs = append(reqErrs, api.RequestError{Field: "/clientPolicy", Slug: api.RequestErrInvalidValue})
+		}
+		if op.ID == "" {
+			reqErrs = append(reqErrs, api.RequestError{Field: "/id", Slug: api.RequestErrMissing})
+		}
+		reqErrs = append(reqErrs, validateExceptions(coreOperation(op).Change)...)
+	case scopes.OperationDelete:
+		if op.ID == "" {
+			reqErrs = append(reqErrs, api.RequestError{Field: "/id", Slug: api.RequestErrMissing})
+		}
+	case "":
+		reqErrs = append(reqErrs, api.RequestError{Field: "/type", Slug: api.RequestErrMissing})
+	default:
+		reqErrs = append(reqErrs, api.RequestError{Field: "/type", Slug: api.RequestErrInvalidValue})
+	}
+	for pos := range reqErrs {
+		reqErrs[pos].Field = prefix + reqErrs[pos].Field
+	}
+	return reqErrs
+}
+
+func (a APIv1) handleBatch(w http.ResponseWriter, r *http.Request) {
+	input, resp := a.VerifyRequest(r)
+	if resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	var body Batch
+	err := json.Unmarshal([]byte(input), &body)
 	if err != nil {
-		yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scope")
-		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		yall.FromContext(r.Context()).WithError(err).Debug("Error decoding request body")
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: api.InvalidFormatError})
 		return
 	}
-	scope, ok := scops[id]
-	if !ok {
-		api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrNotFound}}})
+	if len(body.Operations) < 1 {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: "/operations", Slug: api.RequestErrMissing}}})
 		return
 	}
-	api.Encode(w, r, http.StatusOK, Response{Scopes: []Scope{apiScope(scope)}})
+	if len(body.Operations) > maxBatchOperations {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: "/operations", Slug: api.RequestErrInvalidValue}}})
+		return
+	}
+
+	// every operation is validated before any are applied, so all the
+	// problems with the batch are reported at once
+	var reqErrs []api.RequestError
+	ops := make([]scopes.Operation, 0, len(body.Operations))
+	for pos, op := range body.Operations {
+		reqErrs = append(reqErrs, validateOperation("/operations/"+strconv.Itoa(pos), op)...)
+		ops = append(ops, coreOperation(op))
+	}
+	if len(reqErrs) > 0 {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: reqErrs})
+		return
+	}
+
+	results, err := scopes.ApplyBatch(r.Context(), a.Storer, ops)
+	if errors.Is(err, scopes.ErrBatchUnsupported) {
+		// the Storer can't apply batches atomically, like the file
+		// Storer, so the client needs to apply the operations one by one
+		api.Encode(w, r, http.StatusNotImplemented, Response{Errors: []api.RequestError{{Slug: RequestErrUnsupported}}})
+		return
+	}
+	var batchErr scopes.BatchError
+	if errors.As(err, &batchErr) {
+		field := "/operations/" + strconv.Itoa(batchErr.Index) + "/id"
+		switch {
+		case errors.Is(err, scopes.ErrScopeAlreadyExists):
+			api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: field, Slug: api.RequestErrConflict}}})
+			return
+		case errors.Is(err, scopes.ErrScopeNotFound):
+			api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: field, Slug: api.RequestErrNotFound}}})
+			return
+		case errors.Is(err, scopes.ErrScopeChanged):
+			field = "/operations/" + strconv.Itoa(batchErr.Index) + "/expected"
+			api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: field, Slug: api.RequestErrConflict}}})
+			return
+		}
+	}
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error applying batch")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).WithField("operations", len(ops)).Debug("batch applied")
+	api.Encode(w, r, http.StatusOK, Response{Scopes: apiScopes(results)})
 }
 
 func (a APIv1) handleGetScope(w http.ResponseWriter, r *http.Request) {
@@ -130,15 +310,10 @@ func (a APIv1) handleGetScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	input, resp := a.VerifyRequest(r)
-	if resp != nil {
+	if resp := a.VerifyPayload(r, "GET,"+id); resp != nil {
 		api.Encode(w, r, resp.Status, resp)
 		return
 	}
-	if input != "GET,"+id {
-		api.Encode(w, r, http.StatusUnauthorized, Response{Errors: []api.RequestError{{Header: "Authorization", Slug: api.RequestErrAccessDenied}}})
-		return
-	}
 
 	scops, err := a.Storer.GetMulti(r.Context(), []string{id})
 	if err != nil {
@@ -162,30 +337,17 @@ func (a APIv1) handleDeleteScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	input, resp := a.VerifyRequest(r)
-	if resp != nil {
+	if resp := a.VerifyPayload(r, "DELETE,"+id); resp != nil {
 		api.Encode(w, r, resp.Status, resp)
 		return
 	}
-	if input != "DELETE,"+id {
-		api.Encode(w, r, http.StatusUnauthorized, Response{Errors: []api.RequestError{{Header: "Authorization", Slug: api.RequestErrAccessDenied}}})
-		return
-	}
-
-	scops, err := a.Storer.GetMulti(r.Context(), []string{id})
-	if err != nil {
-		yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scope")
-		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
-		return
-	}
-	scope, ok := scops[id]
-	if !ok {
-		api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrNotFound}}})
-		return
-	}
 
-	err = a.Storer.Delete(r.Context(), id)
+	scope, err := a.Storer.Delete(r.Context(), id)
 	if err != nil {
+		if errors.Is(err, scopes.ErrScopeNotFound) {
+			api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrNotFound}}})
+			return
+		}
 		yall.FromContext(r.Context()).WithError(err).Error("Error deleting scope")
 		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
 		return
@@ -197,31 +359,69 @@ func (a APIv1) handleDeleteScope(w http.ResponseWriter, r *http.Request) {
 func (a APIv1) handleListScopes(w http.ResponseWriter, r *http.Request) {
 	filterDefault := r.URL.Query().Get("default")
 	filterIDs := r.URL.Query()["id"]
+	filterUserException := r.URL.Query().Get("userException")
+	filterClientException := r.URL.Query().Get("clientException")
+	filterAll := r.URL.Query().Get("all")
 
-	if len(filterIDs) > 0 && filterDefault != "" {
-		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "default,id", Slug: api.RequestErrConflict}}})
+	// exactly one filter must be set
+	var filters []string
+	if filterAll != "" {
+		filters = append(filters, "all")
+	}
+	if filterDefault != "" {
+		filters = append(filters, "default")
+	}
+	if len(filterIDs) > 0 {
+		filters = append(filters, "id")
+	}
+	if filterUserException != "" {
+		filters = append(filters, "userException")
+	}
+	if filterClientException != "" {
+		filters = append(filters, "clientException")
+	}
+	if len(filters) > 1 {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: strings.Join(filters, ","), Slug: api.RequestErrConflict}}})
 		return
-	} else if len(filterIDs) < 1 && filterDefault == "" {
+	} else if len(filters) < 1 {
 		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "default", Slug: api.RequestErrMissing}}})
 		return
 	} else if filterDefault != "" && filterDefault != "true" {
 		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "default", Slug: api.RequestErrInvalidValue}}})
 		return
+	} else if filterAll != "" && filterAll != "true" {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "all", Slug: api.RequestErrInvalidValue}}})
+		return
 	}
-
-	input, resp := a.VerifyRequest(r)
-	if resp != nil {
-		api.Encode(w, r, resp.Status, resp)
+	limit, reqErr := parseLimit(r)
+	if reqErr != nil {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{*reqErr}})
 		return
 	}
-	if input != "LIST,"+r.URL.RawQuery {
-		api.Encode(w, r, http.StatusUnauthorized, Response{Errors: []api.RequestError{{Header: "Authorization", Slug: api.RequestErrAccessDenied}}})
+
+	if resp := a.VerifyPayload(r, "LIST,"+r.URL.RawQuery); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
 		return
 	}
 
 	var scops []scopes.Scope
+	var next string
 
-	if len(filterIDs) > 0 {
+	switch {
+	case filterAll != "":
+		resp, err := a.Storer.List(r.Context(), r.URL.Query().Get("after"), limit)
+		if err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scopes")
+			api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+			return
+		}
+		scops = append(scops, resp...)
+		// a full page may not be the last one, so tell the client
+		// where the next one starts
+		if len(resp) > 0 && len(resp) >= limit {
+			next = resp[len(resp)-1].ID
+		}
+	case len(filterIDs) > 0:
 		resp, err := a.Storer.GetMulti(r.Context(), filterIDs)
 		if err != nil {
 			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scopes")
@@ -231,7 +431,7 @@ func (a APIv1) handleListScopes(w http.ResponseWriter, r *http.Request) {
 		for _, v := range resp {
 			scops = append(scops, v)
 		}
-	} else if filterDefault != "" {
+	case filterDefault != "":
 		resp, err := a.Storer.ListDefault(r.Context())
 		if err != nil {
 			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scopes")
@@ -239,7 +439,466 @@ func (a APIv1) handleListScopes(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		scops = append(scops, resp...)
+	case filterUserException != "":
+		resp, err := a.Storer.ListByUserException(r.Context(), filterUserException)
+		if err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scopes")
+			api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+			return
+		}
+		scops = append(scops, resp...)
+	case filterClientException != "":
+		resp, err := a.Storer.ListByClientException(r.Context(), filterClientException)
+		if err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving scopes")
+			api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+			return
+		}
+		scops = append(scops, resp...)
 	}
 	yall.FromContext(r.Context()).Debug("scopes retrieved")
-	api.Encode(w, r, http.StatusOK, Response{Scopes: apiScopes(scops)})
+	api.Encode(w, r, http.StatusOK, Response{Scopes: apiScopes(scops), Next: next})
+}
+
+func (a APIv1) handleRemoveUserException(w http.ResponseWriter, r *http.Request) {
+	a.handleRemoveException(w, r, "REMOVE_USER_EXCEPTION", a.Storer.RemoveUserException)
+}
+
+func (a APIv1) handleRemoveClientException(w http.ResponseWriter, r *http.Request) {
+	a.handleRemoveException(w, r, "REMOVE_CLIENT_EXCEPTION", a.Storer.RemoveClientException)
+}
+
+func (a APIv1) handleRemoveException(w http.ResponseWriter, r *http.Request, action string, remove func(context.Context, string) ([]scopes.Scope, error)) {
+	vars := trout.RequestVars(r)
+	id := vars.Get("id")
+	if id == "" {
+		api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrMissing}}})
+		return
+	}
+
+	if resp := a.VerifyPayload(r, action+","+id); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+
+	changed, err := remove(r.Context(), id)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error removing exception")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).WithField("scopes_changed", len(changed)).Debug("exception removed")
+	api.Encode(w, r, http.StatusOK, Response{Scopes: apiScopes(changed)})
+}
+
+const (
+	// defaultPageLimit is the number of Scopes returned by paginated
+	// endpoints when no limit is specified.
+	defaultPageLimit = 100
+	// maxPageLimit is the largest limit paginated endpoints accept.
+	maxPageLimit = 1000
+)
+
+// parseLimit returns the page size requested by the limit query parameter of
+// `r`, or a RequestError if it isn't valid.
+func parseLimit(r *http.Request) (int, *api.RequestError) {
+	param := r.URL.Query().Get("limit")
+	if param == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, &api.RequestError{Param: "limit", Slug: api.RequestErrInvalidValue}
+	}
+	return limit, nil
+}
+
+func (a APIv1) handleAccessReport(w http.ResponseWriter, r *http.Request) {
+	query := scopes.AccessQuery{
+		UserID:   r.URL.Query().Get("user"),
+		ClientID: r.URL.Query().Get("client"),
+	}
+	if query.UserID == "" && query.ClientID == "" {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "user,client", Slug: api.RequestErrMissing}}})
+		return
+	}
+	limit, reqErr := parseLimit(r)
+	if reqErr != nil {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{*reqErr}})
+		return
+	}
+
+	if resp := a.VerifyPayload(r, "ACCESS,"+r.URL.RawQuery); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+
+	report, err := scopes.UsableScopes(r.Context(), a.Storer, query, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error generating access report")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).Debug("access report generated")
+	api.Encode(w, r, http.StatusOK, Response{Scopes: apiScopes(report.Scopes), Next: report.Next})
+}
+
+// exportContentType is the Content-Type of exports, which are JSON Lines.
+const exportContentType = "application/x-ndjson"
+
+func (a APIv1) handleExport(w http.ResponseWriter, r *http.Request) {
+	if resp := a.VerifyPayload(r, "EXPORT,"); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	// the export is written to a temporary file before it's sent, so
+	// the transaction Export reads in isn't held open for as long as a
+	// slow client takes to read it
+	spool, err := ioutil.TempFile("", "scopes-export-*.jsonl")
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error creating export file")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	defer func() {
+		if err := spool.Close(); err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error closing export file")
+		}
+		if err := os.Remove(spool.Name()); err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error removing export file")
+		}
+	}()
+	buf := bufio.NewWriter(spool)
+	written, err := scopes.Export(r.Context(), a.Storer, buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).WithField("scopes", written).Error("Error exporting scopes")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentType)
+	w.WriteHeader(http.StatusOK)
+	// once the export starts streaming, the status can't be changed, so
+	// errors can only be logged; clients can tell the export is
+	// incomplete because the connection is closed early
+	_, err = io.Copy(w, spool)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).WithField("scopes", written).Error("Error sending export")
+		panic(http.ErrAbortHandler)
+	}
+	yall.FromContext(r.Context()).WithField("scopes", written).Debug("scopes exported")
+}
+
+func (a APIv1) handleImport(w http.ResponseWriter, r *http.Request) {
+	strategy := scopes.ConflictStrategy(r.URL.Query().Get("conflict"))
+	switch strategy {
+	case "":
+		strategy = scopes.ConflictFail
+	case scopes.ConflictSkip, scopes.ConflictOverwrite, scopes.ConflictFail:
+	default:
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Param: "conflict", Slug: api.RequestErrInvalidValue}}})
+		return
+	}
+	limit := a.MaxImportSize
+	if limit <= 0 {
+		limit = DefaultMaxImportSize
+	}
+	// the body is signed as a whole, so it has to be read before any of
+	// it is imported; its size is limited to bound the memory that takes
+	body, resp := a.readLimitedBody(r, limit)
+	if resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	// the query is signed along with the body, so the conflict strategy
+	// can't be tampered with
+	if resp := a.VerifyPayload(r, "IMPORT,"+r.URL.RawQuery+","+body); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+
+	result, err := scopes.Import(r.Context(), a.Storer, strings.NewReader(body), strategy)
+	var importErr scopes.ImportError
+	if errors.As(err, &importErr) {
+		field := "/" + strconv.Itoa(importErr.Line)
+		slug := api.RequestErrInvalidFormat
+		if importErr.Field != "" {
+			field += "/" + importErr.Field
+			slug = api.RequestErrInvalidValue
+		}
+		if errors.Is(err, scopes.ErrScopeAlreadyExists) {
+			slug = api.RequestErrConflict
+		}
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: field, Slug: slug}}})
+		return
+	}
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error importing scopes")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).
+		WithField("created", result.Created).
+		WithField("updated", result.Updated).
+		WithField("skipped", result.Skipped).
+		Debug("scopes imported")
+	api.Encode(w, r, http.StatusOK, Response{Imported: apiImportResult(result)})
+}
+
+const (
+	// eventsKeepAlive is how often a comment is sent on otherwise idle
+	// event streams, to keep intermediaries from closing the connection.
+	eventsKeepAlive = 30 * time.Second
+
+	// eventsAuthParam and eventsDateParam are the query parameters
+	// clients that can't set headers, like browsers using EventSource,
+	// can pass the Authorization and Date headers of a GET /events
+	// request in.
+	eventsAuthParam = "authorization"
+	eventsDateParam = "date"
+)
+
+// verifyEventsRequest authenticates a GET /events request. Requests are
+// signed with the payload "EVENTS," followed by the query string. Clients
+// that can't set headers can instead pass the Authorization and Date headers
+// in the authorization and date query parameters, in which case those
+// parameters are left out of the signed query string, and the rest of it is
+// encoded with its keys sorted. As the signature is only valid while the
+// date is within the Signer's MaxSkew, leaked URLs stop working shortly
+// after they're signed.
+func (a APIv1) verifyEventsRequest(r *http.Request) *Response {
+	query := r.URL.Query()
+	auth := query.Get(eventsAuthParam)
+	if r.Header.Get("Authorization") != "" || auth == "" {
+		return a.VerifyPayload(r, "EVENTS,"+r.URL.RawQuery)
+	}
+	signed := r.Clone(r.Context())
+	signed.Header.Set("Authorization", auth)
+	if date := query.Get(eventsDateParam); date != "" {
+		signed.Header.Set("Date", date)
+	}
+	query.Del(eventsAuthParam)
+	query.Del(eventsDateParam)
+	return a.VerifyPayload(signed, "EVENTS,"+query.Encode())
+}
+
+// notWatchable writes the response for GET /events when the Storer can't
+// watch for changes.
+func notWatchable(w http.ResponseWriter, r *http.Request) {
+	api.Encode(w, r, http.StatusNotImplemented, Response{Errors: []api.RequestError{{Slug: RequestErrUnsupported}}})
+}
+
+func (a APIv1) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if resp := a.verifyEventsRequest(r); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+
+	watcher, ok := a.Storer.(scopes.Watcher)
+	if !ok {
+		notWatchable(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		yall.FromContext(r.Context()).Error("ResponseWriter does not support flushing")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+
+	var from uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		var err error
+		from, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Header: "Last-Event-ID", Slug: api.RequestErrInvalidValue}}})
+			return
+		}
+	} else {
+		var err error
+		from, err = watcher.CurrentRevision(r.Context())
+		if errors.Is(err, scopes.ErrWatchUnsupported) {
+			notWatchable(w, r)
+			return
+		}
+		if err != nil {
+			yall.FromContext(r.Context()).WithError(err).Error("Error retrieving current revision")
+			api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+			return
+		}
+	}
+
+	events, err := watcher.Watch(r.Context(), from)
+	if err != nil {
+		if errors.Is(err, scopes.ErrRevisionCompacted) {
+			api.Encode(w, r, http.StatusGone, Response{Errors: []api.RequestError{{Header: "Last-Event-ID", Slug: api.RequestErrNotFound}}})
+			return
+		}
+		if errors.Is(err, scopes.ErrWatchUnsupported) {
+			notWatchable(w, r)
+			return
+		}
+		yall.FromContext(r.Context()).WithError(err).Error("Error watching scopes")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	yall.FromContext(r.Context()).WithField("revision", from).Debug("streaming events")
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				// the Storer stopped sending events; the client
+				// will reconnect and resume using Last-Event-ID
+				return
+			}
+			var data []byte
+			data, err = json.Marshal(apiEvent(event))
+			if err != nil {
+				yall.FromContext(r.Context()).WithError(err).Error("Error encoding event")
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Revision, event.Type, data)
+		}
+		if err != nil {
+			yall.FromContext(r.Context()).WithError(err).Debug("Error writing event")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// webhookSecretLength is the number of random bytes in a Webhook's secret.
+const webhookSecretLength = 32
+
+func (a APIv1) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	input, resp := a.VerifyRequest(r)
+	if resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	var body Webhook
+	err := json.Unmarshal([]byte(input), &body)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Debug("Error decoding request body")
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: api.InvalidFormatError})
+		return
+	}
+
+	// URL must be set and an absolute HTTP(S) URL
+	if body.URL == "" {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: "/url", Slug: api.RequestErrMissing}}})
+		return
+	}
+	parsed, err := url.Parse(body.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		api.Encode(w, r, http.StatusBadRequest, Response{Errors: []api.RequestError{{Field: "/url", Slug: api.RequestErrInvalidValue}}})
+		return
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error generating webhook ID")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	secret, err := uuid.GenerateRandomBytes(webhookSecretLength)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error generating webhook secret")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	webhook := webhooks.Webhook{
+		ID:        id,
+		URL:       body.URL,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+	err = a.Webhooks.CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error creating webhook")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).WithField("webhook_id", webhook.ID).Debug("webhook created")
+
+	// the secret is only ever returned here, when it's created
+	res := apiWebhook(webhook)
+	res.Secret = webhook.Secret
+	api.Encode(w, r, http.StatusCreated, Response{Webhooks: []Webhook{res}})
+}
+
+func (a APIv1) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if resp := a.VerifyPayload(r, "LIST_WEBHOOKS,"); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	hooks, err := a.Webhooks.ListWebhooks(r.Context())
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error retrieving webhooks")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).Debug("webhooks retrieved")
+	api.Encode(w, r, http.StatusOK, Response{Webhooks: apiWebhooks(hooks)})
+}
+
+func (a APIv1) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := trout.RequestVars(r)
+	id := vars.Get("id")
+	if id == "" {
+		api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrMissing}}})
+		return
+	}
+
+	if resp := a.VerifyPayload(r, "DELETE_WEBHOOK,"+id); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+
+	err := a.Webhooks.DeleteWebhook(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, webhooks.ErrWebhookNotFound) {
+			api.Encode(w, r, http.StatusNotFound, Response{Errors: []api.RequestError{{Param: "id", Slug: api.RequestErrNotFound}}})
+			return
+		}
+		yall.FromContext(r.Context()).WithError(err).Error("Error deleting webhook")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).WithField("webhook_id", id).Debug("webhook deleted")
+	api.Encode(w, r, http.StatusOK, Response{})
+}
+
+func (a APIv1) handleListDeadDeliveries(w http.ResponseWriter, r *http.Request) {
+	if resp := a.VerifyPayload(r, "LIST_DEAD_DELIVERIES,"); resp != nil {
+		api.Encode(w, r, resp.Status, resp)
+		return
+	}
+	deliveries, err := a.Webhooks.ListDeadDeliveries(r.Context())
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("Error retrieving dead deliveries")
+		api.Encode(w, r, http.StatusInternalServerError, Response{Errors: api.ActOfGodError})
+		return
+	}
+	yall.FromContext(r.Context()).Debug("dead deliveries retrieved")
+	api.Encode(w, r, http.StatusOK, Response{Deliveries: apiDeliveries(deliveries)})
 }
@@ -0,0 +1,96 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"darlinggo.co/trout/v2"
+	yall "yall.in"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// HealthOK is the Status of a Health, or of one of its checks, that is
+	// able to serve requests.
+	HealthOK = "ok"
+	// HealthUnavailable is the Status of a Health, or of one of its
+	// checks, that isn't able to serve requests.
+	HealthUnavailable = "unavailable"
+	// HealthPendingMigrations is the status of the storer check when the
+	// Storer's database is missing migrations.
+	HealthPendingMigrations = "pending migrations"
+)
+
+// Health is the API representation of the server's health. It dictates
+// what the JSON representation of health checks will be. Checks holds the
+// status of each dependency that was checked.
+type Health struct {
+	Status  string            `json:"status"`
+	Version string            `json:"version,omitempty"`
+	Checks  map[string]string `json:"checks,omitempty"`
+}
+
+// HealthHandler returns an http.Handler that reports the health of the
+// server, for use as liveness and readiness probes. The baseURL should be
+// set to whatever prefix the muxer matches to pass requests to the Handler.
+// Every response includes `version`, the build version of the server.
+//
+// GET /live always succeeds while the server can handle requests. GET
+// /ready also checks the Storer, if it implements scopes.HealthChecker, and
+// responds with a 503 if it isn't able to serve requests.
+//
+// Requests to the Handler aren't authenticated, so it should be served
+// separately from the Handler returned by Server, and responses don't
+// include the details of any errors; they're logged instead.
+func (a APIv1) HealthHandler(baseURL, version string) http.Handler {
+	var router trout.Router
+	router.SetPrefix(baseURL)
+	router.Endpoint("/live").Methods("GET").
+		Handler(logEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeHealth(w, r, http.StatusOK, Health{Status: HealthOK, Version: version})
+		})))
+	router.Endpoint("/ready").Methods("GET").
+		Handler(logEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.handleReady(w, r, version)
+		})))
+	return router
+}
+
+func (a APIv1) handleReady(w http.ResponseWriter, r *http.Request, version string) {
+	health := Health{
+		Status:  HealthOK,
+		Version: version,
+		Checks:  map[string]string{"storer": HealthOK},
+	}
+	checker, ok := a.Storer.(scopes.HealthChecker)
+	if !ok {
+		writeHealth(w, r, http.StatusOK, health)
+		return
+	}
+	err := checker.CheckHealth(r.Context())
+	if err == nil {
+		writeHealth(w, r, http.StatusOK, health)
+		return
+	}
+	yall.FromContext(r.Context()).WithError(err).Error("storer is unhealthy")
+	health.Status = HealthUnavailable
+	health.Checks["storer"] = HealthUnavailable
+	if errors.Is(err, scopes.ErrPendingMigrations) {
+		health.Checks["storer"] = HealthPendingMigrations
+	}
+	writeHealth(w, r, http.StatusServiceUnavailable, health)
+}
+
+// writeHealth writes `health` as JSON. Probes don't negotiate content types,
+// so health responses skip content negotiation and are always JSON.
+func writeHealth(w http.ResponseWriter, r *http.Request, status int, health Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(health)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Debug("error writing health response")
+	}
+}
@@ -0,0 +1,93 @@
+package apiv1_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+// unhealthyStorer is a Storer whose health check always fails with `err`.
+type unhealthyStorer struct {
+	*memory.Storer
+	err error
+}
+
+func (s unhealthyStorer) CheckHealth(_ context.Context) error {
+	return s.err
+}
+
+func TestHealthHandler(t *testing.T) {
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	tests := map[string]struct {
+		storer scopes.Storer
+		path   string
+		status int
+		health apiv1.Health
+	}{
+		"live": {
+			storer: unhealthyStorer{Storer: backend, err: scopes.ErrPendingMigrations},
+			path:   "/health/live",
+			status: http.StatusOK,
+			health: apiv1.Health{Status: apiv1.HealthOK, Version: "v1.2.3"},
+		},
+		"ready without checks": {
+			storer: backend,
+			path:   "/health/ready",
+			status: http.StatusOK,
+			health: apiv1.Health{Status: apiv1.HealthOK, Version: "v1.2.3", Checks: map[string]string{"storer": apiv1.HealthOK}},
+		},
+		"ready with healthy storer": {
+			storer: unhealthyStorer{Storer: backend},
+			path:   "/health/ready",
+			status: http.StatusOK,
+			health: apiv1.Health{Status: apiv1.HealthOK, Version: "v1.2.3", Checks: map[string]string{"storer": apiv1.HealthOK}},
+		},
+		"pending migrations": {
+			storer: unhealthyStorer{Storer: backend, err: fmt.Errorf("%w: 0002", scopes.ErrPendingMigrations)},
+			path:   "/health/ready",
+			status: http.StatusServiceUnavailable,
+			health: apiv1.Health{Status: apiv1.HealthUnavailable, Version: "v1.2.3", Checks: map[string]string{"storer": apiv1.HealthPendingMigrations}},
+		},
+		"unreachable": {
+			storer: unhealthyStorer{Storer: backend, err: context.DeadlineExceeded},
+			path:   "/health/ready",
+			status: http.StatusServiceUnavailable,
+			health: apiv1.Health{Status: apiv1.HealthUnavailable, Version: "v1.2.3", Checks: map[string]string{"storer": apiv1.HealthUnavailable}},
+		},
+	}
+	for name, test := range tests {
+		v1 := apiv1.APIv1{
+			Dependencies: scopes.Dependencies{Storer: test.storer},
+			Log:          yall.New(colour.New(ioutil.Discard, yall.Error)),
+		}
+		w := httptest.NewRecorder()
+		// no Authorization header; probes aren't authenticated
+		v1.HealthHandler("/health", "v1.2.3").ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", name, test.status, w.Code)
+		}
+		var health apiv1.Health
+		err := json.Unmarshal(w.Body.Bytes(), &health)
+		if err != nil {
+			t.Fatalf("%s: error decoding response %q: %s", name, w.Body.String(), err)
+		}
+		if diff := cmp.Diff(test.health, health); diff != "" {
+			t.Errorf("%s: unexpected health (-wanted, +got): %s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package apiv1_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func TestImportTooLarge(t *testing.T) {
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	signer := hmac.Signer{Key: "import-key", Secret: []byte("import-secret"), MaxSkew: time.Minute}
+	v1 := apiv1.APIv1{
+		Dependencies:  scopes.Dependencies{Storer: storer},
+		Log:           yall.New(colour.New(ioutil.Discard, yall.Error)),
+		Signer:        signer,
+		MaxImportSize: 64,
+	}
+	server := httptest.NewServer(v1.Server("/"))
+	defer server.Close()
+
+	body := `{"version":1}
+{"id":"https://scopes.impractical.co/import/too-large","userPolicy":"ALLOW_ALL","clientPolicy":"ALLOW_ALL"}
+`
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/import?conflict=fail", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash("IMPORT,conflict=fail,"+body)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	listed, err := storer.List(context.Background(), "", 0)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected nothing to be imported, got %+v", listed)
+	}
+}
@@ -0,0 +1,74 @@
+package apiv1_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func TestListAllPages(t *testing.T) {
+	ctx := context.Background()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	ids := []string{
+		"https://scopes.impractical.co/list/a",
+		"https://scopes.impractical.co/list/b",
+		"https://scopes.impractical.co/list/c",
+	}
+	createWatchedScopes(ctx, t, storer, ids...)
+	server, signer := newEventsServer(t, storer)
+
+	list := func(after string) apiv1.Response {
+		t.Helper()
+		query := url.Values{"all": []string{"true"}, "limit": []string{"2"}}
+		if after != "" {
+			query.Set("after", after)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatalf("Error creating request: %s", err)
+		}
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("Authorization", signer.Sign(req, apiv1.ContentHash("LIST,"+query.Encode())))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error making request: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var got apiv1.Response
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Error decoding response: %s", err)
+		}
+		return got
+	}
+
+	// a full page points to the next one
+	first := list("")
+	if len(first.Scopes) != 2 {
+		t.Fatalf("Expected 2 scopes in the first page, got %+v", first.Scopes)
+	}
+	if first.Next != ids[1] {
+		t.Errorf("Expected next to be %q, got %q", ids[1], first.Next)
+	}
+
+	// the last page doesn't
+	last := list(first.Next)
+	if len(last.Scopes) != 1 || last.Scopes[0].ID != ids[2] {
+		t.Fatalf("Expected only %q in the last page, got %+v", ids[2], last.Scopes)
+	}
+	if last.Next != "" {
+		t.Errorf("Expected no next on the last page, got %q", last.Next)
+	}
+}
@@ -66,3 +66,88 @@ func coreChange(change Change) scopes.Change {
 		IsDefault:        change.IsDefault,
 	}
 }
+
+// Event is the API representation of an Event.
+// It dictates what the JSON representation of Events
+// will be.
+type Event struct {
+	Revision uint64 `json:"revision"`
+	Type     string `json:"type"`
+	Scope    Scope  `json:"scope"`
+	Previous *Scope `json:"previous,omitempty"`
+}
+
+func apiEvent(event scopes.Event) Event {
+	res := Event{
+		Revision: event.Revision,
+		Type:     string(event.Type),
+		Scope:    apiScope(event.Scope),
+	}
+	if event.Previous != nil {
+		previous := apiScope(*event.Previous)
+		res.Previous = &previous
+	}
+	return res
+}
+
+// Operation is the API representation of a scopes.Operation. Which fields
+// are used depends on Type: create Operations use all of them as the Scope
+// to create, update Operations use the set fields besides ID as the Change to
+// apply, and delete Operations only use ID. Update and delete Operations can
+// also set Expected, the Scope they expect to change.
+type Operation struct {
+	Type             string    `json:"type"`
+	ID               string    `json:"id"`
+	UserPolicy       *string   `json:"userPolicy,omitempty"`
+	UserExceptions   *[]string `json:"userExceptions,omitempty"`
+	ClientPolicy     *string   `json:"clientPolicy,omitempty"`
+	ClientExceptions *[]string `json:"clientExceptions,omitempty"`
+	IsDefault        *bool     `json:"isDefault,omitempty"`
+	Expected         *Scope    `json:"expected,omitempty"`
+}
+
+// Batch is the API representation of a batch of Operations to apply
+// atomically.
+type Batch struct {
+	Operations []Operation `json:"operations"`
+}
+
+func coreOperation(op Operation) scopes.Operation {
+	res := scopes.Operation{
+		Type: scopes.OperationType(op.Type),
+		ID:   op.ID,
+		Change: scopes.Change{
+			UserPolicy:       op.UserPolicy,
+			UserExceptions:   op.UserExceptions,
+			ClientPolicy:     op.ClientPolicy,
+			ClientExceptions: op.ClientExceptions,
+			IsDefault:        op.IsDefault,
+		},
+	}
+	if op.Expected != nil {
+		expected := coreScope(*op.Expected)
+		res.Expected = &expected
+	}
+	if res.Type != scopes.OperationCreate {
+		return res
+	}
+	res.Scope = scopes.Apply(res.Change, scopes.Scope{ID: op.ID})
+	res.Change = scopes.Change{}
+	return res
+}
+
+// ImportResult is the API representation of a scopes.ImportResult. It
+// dictates what the JSON representation of ImportResults will be.
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+func apiImportResult(result scopes.ImportResult) *ImportResult {
+	return &ImportResult{
+		Created: result.Created,
+		Updated: result.Updated,
+		Skipped: result.Skipped,
+	}
+}
@@ -0,0 +1,68 @@
+package apiv1
+
+import (
+	"encoding/json"
+	"time"
+
+	"lockbox.dev/scopes/webhooks"
+)
+
+// Webhook is the API representation of a Webhook.
+// It dictates what the JSON representation of Webhooks
+// will be. The Secret is only included when the Webhook
+// is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Delivery is the API representation of a Delivery.
+// It dictates what the JSON representation of Deliveries
+// will be.
+type Delivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhookID"`
+	Revision      uint64          `json:"revision"`
+	Body          json.RawMessage `json:"body"`
+	State         string          `json:"state"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	LastError     string          `json:"lastError"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+func apiWebhook(webhook webhooks.Webhook) Webhook {
+	return Webhook{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+func apiWebhooks(hooks []webhooks.Webhook) []Webhook {
+	res := make([]Webhook, 0, len(hooks))
+	for _, hook := range hooks {
+		res = append(res, apiWebhook(hook))
+	}
+	return res
+}
+
+func apiDeliveries(deliveries []webhooks.Delivery) []Delivery {
+	res := make([]Delivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		res = append(res, Delivery{
+			ID:            delivery.ID,
+			WebhookID:     delivery.WebhookID,
+			Revision:      delivery.Revision,
+			Body:          json.RawMessage(delivery.Body),
+			State:         delivery.State,
+			Attempts:      delivery.Attempts,
+			NextAttemptAt: delivery.NextAttemptAt,
+			LastError:     delivery.LastError,
+			CreatedAt:     delivery.CreatedAt,
+		})
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package scopes
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// AuditRemoveUserException is the Action of AuditEntries recording a
+	// user being removed from every Scope's UserExceptions.
+	AuditRemoveUserException = "remove_user_exception"
+	// AuditRemoveClientException is the Action of AuditEntries recording a
+	// client being removed from every Scope's ClientExceptions.
+	AuditRemoveClientException = "remove_client_exception"
+)
+
+// AuditEntry records a change that affected many Scopes at once. ScopeIDs
+// holds the IDs of the Scopes that were changed, sorted lexicographically.
+type AuditEntry struct {
+	ID          string
+	Action      string
+	PrincipalID string
+	ScopeIDs    []string
+	CreatedAt   time.Time
+}
+
+// AuditLister is an optional interface that Storers can implement to let
+// callers retrieve the AuditEntries they have recorded.
+type AuditLister interface {
+	// ListAuditEntries returns every AuditEntry the Storer has recorded,
+	// oldest first.
+	ListAuditEntries(ctx context.Context) ([]AuditEntry, error)
+}
+
+// WithoutException returns a copy of `exceptions` with every occurrence of
+// `id` removed, and whether any were removed.
+func WithoutException(exceptions []string, id string) ([]string, bool) {
+	results := make([]string, 0, len(exceptions))
+	var removed bool
+	for _, exception := range exceptions {
+		if exception == id {
+			removed = true
+			continue
+		}
+		results = append(results, exception)
+	}
+	return results, removed
+}
@@ -0,0 +1,178 @@
+package scopes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// OperationCreate is the OperationType for Operations that create a
+	// Scope.
+	OperationCreate OperationType = "create"
+	// OperationUpdate is the OperationType for Operations that apply a
+	// Change to a Scope.
+	OperationUpdate OperationType = "update"
+	// OperationDelete is the OperationType for Operations that delete a
+	// Scope.
+	OperationDelete OperationType = "delete"
+)
+
+var (
+	// ErrBatchUnsupported is returned when attempting to apply a batch of
+	// Operations to a Storer that can't apply them atomically.
+	ErrBatchUnsupported = errors.New("storer can't apply batches atomically")
+	// ErrUnknownOperation is returned when an Operation has an
+	// OperationType that isn't recognized.
+	ErrUnknownOperation = errors.New("unknown operation type")
+	// ErrScopeChanged is returned when an Operation's Expected Scope
+	// doesn't match the Scope it would change.
+	ErrScopeChanged = errors.New("scope has changed")
+)
+
+// OperationType describes what an Operation does.
+type OperationType string
+
+// Operation is a single mutation in a batch. Create Operations store Scope;
+// Update Operations apply Change to the Scope specified by ID; Delete
+// Operations delete the Scope specified by ID.
+//
+// If Expected is set on an Update or Delete Operation, the Operation fails
+// with ErrScopeChanged unless the Scope specified by ID matches Expected
+// when the Operation is applied, with its exceptions in the same order.
+type Operation struct {
+	Type     OperationType
+	ID       string
+	Scope    Scope
+	Change   Change
+	Expected *Scope
+}
+
+// BatchError is returned when an Operation in a batch fails, and the batch is
+// rolled back. Index is the position of the failed Operation in the batch,
+// starting from 0.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Err)
+}
+
+func (e BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batcher is an optional interface that Storers can implement to apply a
+// batch of Operations themselves, instead of relying on ApplyBatch to apply
+// them in a transaction. ApplyBatch must follow the same rules as the
+// ApplyBatch function.
+type Batcher interface {
+	ApplyBatch(ctx context.Context, ops []Operation) ([]Scope, error)
+}
+
+// ApplyBatch applies every Operation in `ops` to `storer`, in order, so that
+// they all take effect or none do. It returns a Scope for each Operation: the
+// created Scope for creates, the Scope as it is after the Change for updates,
+// and the Scope as it was before it was deleted for deletes.
+//
+// If an Operation fails, a BatchError wrapping the failure is returned and
+// none of the Operations take effect. If `storer` implements Batcher, the
+// batch is passed to it; otherwise, `storer` must implement Transactor and be
+// able to start a transaction, or ErrBatchUnsupported is returned.
+func ApplyBatch(ctx context.Context, storer Storer, ops []Operation) ([]Scope, error) {
+	if batcher, ok := storer.(Batcher); ok {
+		return batcher.ApplyBatch(ctx, ops) //nolint:wrapcheck // the Batcher follows the same rules we do
+	}
+	transactor, ok := storer.(Transactor)
+	if !ok {
+		return nil, ErrBatchUnsupported
+	}
+	var results []Scope
+	err := transactor.WithTx(ctx, func(ctx context.Context, tx Storer) error {
+		results = make([]Scope, 0, len(ops))
+		for pos, op := range ops {
+			result, err := applyOperation(ctx, tx, op)
+			if err != nil {
+				return BatchError{Index: pos, Err: err}
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrTxUnsupported) {
+		return nil, ErrBatchUnsupported
+	}
+	if err != nil {
+		return nil, err //nolint:wrapcheck // errors are BatchErrors or the Transactor's own
+	}
+	return results, nil
+}
+
+func applyOperation(ctx context.Context, storer Storer, op Operation) (Scope, error) {
+	switch op.Type {
+	case OperationCreate:
+		err := storer.Create(ctx, op.Scope)
+		if err != nil {
+			return Scope{}, err //nolint:wrapcheck // wrapped in a BatchError by our caller
+		}
+		return op.Scope, nil
+	case OperationUpdate:
+		err := checkExpected(ctx, storer, op)
+		if err != nil {
+			return Scope{}, err
+		}
+		return storer.Update(ctx, op.ID, op.Change) //nolint:wrapcheck // wrapped in a BatchError by our caller
+	case OperationDelete:
+		err := checkExpected(ctx, storer, op)
+		if err != nil {
+			return Scope{}, err
+		}
+		return storer.Delete(ctx, op.ID) //nolint:wrapcheck // wrapped in a BatchError by our caller
+	default:
+		return Scope{}, fmt.Errorf("%w %q", ErrUnknownOperation, op.Type)
+	}
+}
+
+// checkExpected returns ErrScopeChanged if `op` has an Expected Scope that
+// doesn't match the Scope specified by op.ID, or ErrScopeNotFound if that
+// Scope doesn't exist.
+func checkExpected(ctx context.Context, storer Storer, op Operation) error {
+	if op.Expected == nil {
+		return nil
+	}
+	current, err := storer.GetMulti(ctx, []string{op.ID})
+	if err != nil {
+		return err //nolint:wrapcheck // wrapped in a BatchError by our caller
+	}
+	scope, ok := current[op.ID]
+	if !ok {
+		return ErrScopeNotFound
+	}
+	if !sameScope(scope, *op.Expected) {
+		return ErrScopeChanged
+	}
+	return nil
+}
+
+// sameScope returns true if `a` and `b` have the same fields, treating nil
+// and empty exceptions as the same.
+func sameScope(a, b Scope) bool {
+	if a.ID != b.ID || a.UserPolicy != b.UserPolicy || a.ClientPolicy != b.ClientPolicy || a.IsDefault != b.IsDefault {
+		return false
+	}
+	return sameIDs(a.UserExceptions, b.UserExceptions) && sameIDs(a.ClientExceptions, b.ClientExceptions)
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for pos := range a {
+		if a[pos] != b[pos] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"lockbox.dev/scopes"
+)
+
+var (
+	// errNotFound is returned when a Scope a command needs doesn't exist.
+	errNotFound = errors.New("scope not found")
+	// errNothingToChange is returned when update isn't told to change
+	// anything.
+	errNothingToChange = errors.New("nothing to change")
+)
+
+// stringsFlag is a flag that can be repeated, collecting every value.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parse parses `args` into `flags`, requiring at least `minArgs` positional
+// arguments.
+func parse(flags *flag.FlagSet, args []string, minArgs int) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if flags.NArg() < minArgs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// getScopes retrieves the Scopes specified by `ids`, in the same order,
+// returning an error if any don't exist.
+func getScopes(ctx context.Context, env environment, ids []string) ([]scopes.Scope, error) {
+	found, err := env.storer.GetMulti(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving scopes: %w", err)
+	}
+	results := make([]scopes.Scope, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		scope, ok := found[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		results = append(results, scope)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errNotFound, strings.Join(missing, ", "))
+	}
+	return results, nil
+}
+
+// policies lists the valid policies, for usage messages.
+var policies = strings.Join([]string{scopes.PolicyDenyAll, scopes.PolicyDefaultDeny, scopes.PolicyDefaultAllow, scopes.PolicyAllowAll}, ", ")
+
+func runCreate(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	var scope scopes.Scope
+	var userExceptions, clientExceptions stringsFlag
+	flags.StringVar(&scope.ID, "id", "", "the ID of the scope, usually a URI")
+	flags.StringVar(&scope.UserPolicy, "user-policy", "", "the policy for users: "+policies)
+	flags.StringVar(&scope.ClientPolicy, "client-policy", "", "the policy for clients: "+policies)
+	flags.Var(&userExceptions, "user-exception", "a user excepted from the user policy; can be repeated")
+	flags.Var(&clientExceptions, "client-exception", "a client excepted from the client policy; can be repeated")
+	flags.BoolVar(&scope.IsDefault, "default", false, "whether the scope is granted when none are requested")
+	err := parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	if scope.ID == "" || scope.UserPolicy == "" || scope.ClientPolicy == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+	scope.UserExceptions = userExceptions
+	scope.ClientExceptions = clientExceptions
+
+	err = env.storer.Create(ctx, scope)
+	if err != nil {
+		return fmt.Errorf("error creating scope: %w", err)
+	}
+	return env.out.scopes([]scopes.Scope{scope})
+}
+
+func runGet(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	results, err := getScopes(ctx, env, flags.Args())
+	if err != nil {
+		return err
+	}
+	return env.out.scopes(results)
+}
+
+func runList(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	onlyDefault := flags.Bool("default", false, "only list default scopes")
+	userException := flags.String("user-exception", "", "only list scopes excepting this user")
+	clientException := flags.String("client-exception", "", "only list scopes excepting this client")
+	err := parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	var filters int
+	for _, set := range []bool{*onlyDefault, *userException != "", *clientException != ""} {
+		if set {
+			filters++
+		}
+	}
+	if filters > 1 || flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	var results []scopes.Scope
+	switch {
+	case *onlyDefault:
+		results, err = env.storer.ListDefault(ctx)
+	case *userException != "":
+		results, err = env.storer.ListByUserException(ctx, *userException)
+	case *clientException != "":
+		results, err = env.storer.ListByClientException(ctx, *clientException)
+	default:
+		results, err = env.storer.List(ctx, "", 0)
+	}
+	if err != nil {
+		return fmt.Errorf("error listing scopes: %w", err)
+	}
+	return env.out.scopes(results)
+}
+
+func runUpdate(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	var change scopes.Change
+	var addUsers, removeUsers, addClients, removeClients stringsFlag
+	flags.Func("user-policy", "the new policy for users: "+policies, func(value string) error {
+		change.UserPolicy = &value
+		return nil
+	})
+	flags.Func("client-policy", "the new policy for clients: "+policies, func(value string) error {
+		change.ClientPolicy = &value
+		return nil
+	})
+	flags.Func("default", "whether the scope is granted when none are requested: true or false", func(value string) error {
+		isDefault, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("error parsing %q: %w", value, err)
+		}
+		change.IsDefault = &isDefault
+		return nil
+	})
+	flags.Var(&addUsers, "add-user-exception", "a user to add to the user exceptions; can be repeated")
+	flags.Var(&removeUsers, "remove-user-exception", "a user to remove from the user exceptions; can be repeated")
+	flags.Var(&addClients, "add-client-exception", "a client to add to the client exceptions; can be repeated")
+	flags.Var(&removeClients, "remove-client-exception", "a client to remove from the client exceptions; can be repeated")
+	err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errUsage
+	}
+	id := flags.Arg(0)
+
+	// the API replaces the whole list of exceptions, so adding or
+	// removing them means starting from the current list
+	if len(addUsers) > 0 || len(removeUsers) > 0 || len(addClients) > 0 || len(removeClients) > 0 {
+		current, err := getScopes(ctx, env, []string{id})
+		if err != nil {
+			return err
+		}
+		if len(addUsers) > 0 || len(removeUsers) > 0 {
+			userExceptions := editExceptions(current[0].UserExceptions, addUsers, removeUsers)
+			change.UserExceptions = &userExceptions
+		}
+		if len(addClients) > 0 || len(removeClients) > 0 {
+			clientExceptions := editExceptions(current[0].ClientExceptions, addClients, removeClients)
+			change.ClientExceptions = &clientExceptions
+		}
+	}
+	if change.IsEmpty() {
+		return errNothingToChange
+	}
+
+	updated, err := env.storer.Update(ctx, id, change)
+	if errors.Is(err, scopes.ErrScopeNotFound) {
+		return fmt.Errorf("%w: %s", errNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating scope: %w", err)
+	}
+	return env.out.scopes([]scopes.Scope{updated})
+}
+
+// editExceptions returns a copy of `exceptions` with every ID in `remove`
+// removed and every ID in `add` that isn't already listed added.
+func editExceptions(exceptions, add, remove []string) []string {
+	results := append([]string{}, exceptions...)
+	for _, id := range remove {
+		results, _ = scopes.WithoutException(results, id)
+	}
+	for _, id := range add {
+		if _, listed := scopes.WithoutException(results, id); !listed {
+			results = append(results, id)
+		}
+	}
+	return results
+}
+
+func runDelete(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	deleted := make([]scopes.Scope, 0, flags.NArg())
+	for _, id := range flags.Args() {
+		scope, err := env.storer.Delete(ctx, id)
+		if errors.Is(err, scopes.ErrScopeNotFound) {
+			err = fmt.Errorf("%w: %s", errNotFound, id)
+		}
+		if err != nil {
+			// report what was deleted before the failure
+			if printErr := env.out.scopes(deleted); printErr != nil {
+				return printErr
+			}
+			return fmt.Errorf("error deleting scope: %w", err)
+		}
+		deleted = append(deleted, scope)
+	}
+	return env.out.scopes(deleted)
+}
+
+func runEvaluate(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	user := flags.String("user", "", "the ID of the user to evaluate")
+	client := flags.String("client", "", "the ID of the client to evaluate")
+	err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if *user == "" && *client == "" {
+		flags.Usage()
+		return errUsage
+	}
+	results, err := getScopes(ctx, env, flags.Args())
+	if err != nil {
+		return err
+	}
+	var evaluations []evaluation
+	for _, scope := range results {
+		if *user != "" {
+			evaluations = append(evaluations, evaluation{
+				Scope:     scope.ID,
+				Principal: "user",
+				ID:        *user,
+				Allowed:   scopes.UserCanUseScope(ctx, scope, *user),
+			})
+		}
+		if *client != "" {
+			evaluations = append(evaluations, evaluation{
+				Scope:     scope.ID,
+				Principal: "client",
+				ID:        *client,
+				Allowed:   scopes.ClientCanUseScope(ctx, scope, *client),
+			})
+		}
+	}
+	return env.out.evaluations(evaluations)
+}
+
+func runExport(ctx context.Context, env environment, flags *flag.FlagSet, args []string) (retErr error) {
+	path := flags.String("file", "", "the file to write the export to, instead of stdout")
+	err := parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+	w := env.stdout
+	if *path != "" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return fmt.Errorf("error creating export file: %w", err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil && retErr == nil {
+				retErr = fmt.Errorf("error closing export file: %w", err)
+			}
+		}()
+		w = file
+	}
+	err = env.storer.Export(ctx, w)
+	if err != nil {
+		return fmt.Errorf("error exporting scopes: %w", err)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error {
+	conflict := flags.String("conflict", string(scopes.ConflictFail), "what to do with scopes that already exist: skip, overwrite, or fail")
+	err := parse(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errUsage
+	}
+	var r io.Reader = env.stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening export file: %w", err)
+		}
+		defer file.Close() //nolint:errcheck // only read from, nothing to lose
+		r = file
+	}
+	result, err := env.storer.Import(ctx, r, scopes.ConflictStrategy(*conflict))
+	if err != nil {
+		return fmt.Errorf("error importing scopes: %w", err)
+	}
+	return env.out.importResult(result)
+}
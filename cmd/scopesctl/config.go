@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// envConfig is the environment variable that overrides the path to
+	// the config file.
+	envConfig = "SCOPESCTL_CONFIG"
+	// envURL, envKey, and envSecret are the environment variables that
+	// override the values in the config file.
+	envURL    = "SCOPESCTL_URL"
+	envKey    = "SCOPESCTL_KEY"
+	envSecret = "SCOPESCTL_SECRET"
+)
+
+var (
+	// errNoURL is returned when no server URL is configured.
+	errNoURL = errors.New("no server URL configured; set url in the config file or " + envURL)
+	// errNoCredentials is returned when no HMAC key or secret is
+	// configured.
+	errNoCredentials = errors.New("no credentials configured; set key and secret in the config file or " + envKey + " and " + envSecret)
+)
+
+// config is how scopesctl finds and authenticates with the server. It's read
+// from a YAML file, and each value can be overridden by an environment
+// variable.
+type config struct {
+	// URL is the root path of v1 of the API on the server.
+	URL string `yaml:"url"`
+	// Key and Secret are the HMAC credentials requests are signed with.
+	Key    string `yaml:"key"`
+	Secret string `yaml:"secret"`
+}
+
+// defaultConfigPath returns where the config file is read from if no other
+// path is given, or an empty string if there's no sensible default.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "scopesctl", "config.yaml")
+}
+
+// loadConfig reads the config file at `path`, then applies any overrides
+// from the environment, read using `getenv`. If `path` is empty, the path in
+// the environment is used, falling back on the default path. A missing
+// config file is only an error if its path was set explicitly.
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	explicit := path != ""
+	if path == "" {
+		path = getenv(envConfig)
+		explicit = path != ""
+	}
+	if path == "" {
+		path = defaultConfigPath()
+	}
+
+	var conf config
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+		case err != nil:
+			return config{}, fmt.Errorf("error reading config file: %w", err)
+		default:
+			err = yaml.UnmarshalStrict(contents, &conf)
+			if err != nil {
+				return config{}, fmt.Errorf("error decoding config file %s: %w", path, err)
+			}
+		}
+	}
+
+	if url := getenv(envURL); url != "" {
+		conf.URL = url
+	}
+	if key := getenv(envKey); key != "" {
+		conf.Key = key
+	}
+	if secret := getenv(envSecret); secret != "" {
+		conf.Secret = secret
+	}
+
+	if conf.URL == "" {
+		return config{}, errNoURL
+	}
+	if conf.Key == "" || conf.Secret == "" {
+		return config{}, errNoCredentials
+	}
+	return conf, nil
+}
@@ -0,0 +1,144 @@
+// Command scopesctl manages the Scopes on a server running v1 of the scopes
+// API, signing each request with the configured HMAC credentials.
+//
+// Credentials are read from a YAML config file with url, key, and secret
+// fields, found at the path passed with -config, the path in
+// SCOPESCTL_CONFIG, or scopesctl/config.yaml in the user's config directory.
+// The SCOPESCTL_URL, SCOPESCTL_KEY, and SCOPESCTL_SECRET environment
+// variables override the values in the file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"lockbox.dev/hmac"
+
+	"lockbox.dev/scopes/storers/remote"
+)
+
+// requestSkew is how far the server's clock can drift from ours before it
+// rejects our signatures.
+const requestSkew = time.Minute
+
+// errUsage is returned when a command is used incorrectly. The command's
+// usage has already been printed, so it doesn't need to be reported again.
+var errUsage = errors.New("invalid usage")
+
+// environment is everything a command needs to run.
+type environment struct {
+	storer *remote.Storer
+	out    printer
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, env environment, flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"create":   {usage: "create -id ID -user-policy POLICY -client-policy POLICY [flags]", description: "create a scope", run: runCreate},
+	"get":      {usage: "get ID...", description: "show the specified scopes", run: runGet},
+	"list":     {usage: "list [-default | -user-exception ID | -client-exception ID]", description: "list scopes", run: runList},
+	"update":   {usage: "update [flags] ID", description: "change a scope's policies, exceptions, or default status", run: runUpdate},
+	"delete":   {usage: "delete ID...", description: "delete the specified scopes", run: runDelete},
+	"evaluate": {usage: "evaluate [-user ID] [-client ID] SCOPE...", description: "check whether a user or client can use scopes", run: runEvaluate},
+	"export":   {usage: "export [-file PATH]", description: "write every scope as JSON Lines", run: runExport},
+	"import":   {usage: "import [-conflict skip|overwrite|fail] [PATH]", description: "import scopes written by export", run: runImport},
+}
+
+func usage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: scopesctl [-config PATH] [-output table|json] COMMAND [ARGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "flags:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].description)
+	}
+}
+
+// run runs scopesctl with `args`, not including the program name, and returns
+// the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	flags := flag.NewFlagSet("scopesctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "path to the config file")
+	format := flags.String("output", formatTable, "output format, table or json")
+	flags.Usage = func() { usage(stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintln(stderr, errUnknownFormat)
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+	if flags.NArg() < 1 {
+		usage(stderr, flags)
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", flags.Arg(0))
+		usage(stderr, flags)
+		return 2 //nolint:gomnd // exit code for invalid usage
+	}
+
+	conf, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	env := environment{
+		storer: remote.NewStorer(ctx, &http.Client{}, conf.URL, hmac.Signer{
+			Key:     conf.Key,
+			Secret:  []byte(conf.Secret),
+			MaxSkew: requestSkew,
+		}),
+		out:    printer{w: stdout, format: *format},
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	cmdFlags := flag.NewFlagSet(flags.Arg(0), flag.ContinueOnError)
+	cmdFlags.SetOutput(stderr)
+	cmdFlags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: scopesctl", cmd.usage)
+		cmdFlags.PrintDefaults()
+	}
+	err = cmd.run(ctx, env, cmdFlags, flags.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		return 2 //nolint:gomnd // exit code for invalid usage
+	case err != nil:
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
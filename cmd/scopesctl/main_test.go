@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+// newServer starts an API server backed by an in-memory Storer, returning
+// the environment variables scopesctl needs to talk to it.
+func newServer(t *testing.T) map[string]string {
+	t.Helper()
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	v1 := apiv1.APIv1{
+		Dependencies: scopes.Dependencies{Storer: backend},
+		Log:          yall.New(colour.New(ioutil.Discard, yall.Error)),
+		Signer:       hmac.Signer{Key: "test", Secret: []byte("very secret"), MaxSkew: requestSkew},
+	}
+	server := httptest.NewServer(v1.Server("/"))
+	t.Cleanup(server.Close)
+	return map[string]string{
+		envConfig: filepath.Join(t.TempDir(), "missing.yaml"),
+		envURL:    server.URL,
+		envKey:    "test",
+		envSecret: "very secret",
+	}
+}
+
+// runCommand runs scopesctl with `args`, failing the test if the exit code
+// isn't `code`, and returns what it wrote to stdout.
+func runCommand(t *testing.T, env map[string]string, stdin string, code int, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	got := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr, func(key string) string {
+		return env[key]
+	})
+	if got != code {
+		t.Fatalf("Expected exit code %d from %v, got %d; stderr: %s", code, args, got, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	env := newServer(t)
+	// SCOPESCTL_CONFIG is set explicitly, so a missing file is an error;
+	// clear it to fall back on the environment alone
+	delete(env, envConfig)
+
+	runCommand(t, env, "", 0, "create", "-id", "https://scopes.impractical.co/a",
+		"-user-policy", scopes.PolicyDefaultDeny, "-user-exception", "user-1",
+		"-client-policy", scopes.PolicyAllowAll, "-default")
+	runCommand(t, env, "", 0, "create", "-id", "https://scopes.impractical.co/b",
+		"-user-policy", scopes.PolicyAllowAll, "-client-policy", scopes.PolicyDefaultAllow)
+	runCommand(t, env, "", 0, "update", "-add-user-exception", "user-2", "-remove-user-exception", "user-1",
+		"-default", "false", "https://scopes.impractical.co/a")
+
+	expectedTable := "ID                               USER POLICY   USER EXCEPTIONS  CLIENT POLICY  CLIENT EXCEPTIONS  DEFAULT\n" +
+		"https://scopes.impractical.co/a  DEFAULT_DENY  user-2           ALLOW_ALL      -                  false\n" +
+		"https://scopes.impractical.co/b  ALLOW_ALL     -                DEFAULT_ALLOW  -                  false\n"
+	if diff := cmp.Diff(expectedTable, runCommand(t, env, "", 0, "list")); diff != "" {
+		t.Errorf("Unexpected list output (-wanted, +got): %s", diff)
+	}
+
+	var evaluations []evaluation
+	out := runCommand(t, env, "", 0, "-output", "json", "evaluate", "-user", "user-2", "-client", "client-1", "https://scopes.impractical.co/a")
+	if err := json.Unmarshal([]byte(out), &evaluations); err != nil {
+		t.Fatalf("Error decoding evaluate output %q: %s", out, err)
+	}
+	if diff := cmp.Diff([]evaluation{
+		{Scope: "https://scopes.impractical.co/a", Principal: "user", ID: "user-2", Allowed: true},
+		{Scope: "https://scopes.impractical.co/a", Principal: "client", ID: "client-1", Allowed: true},
+	}, evaluations); diff != "" {
+		t.Errorf("Unexpected evaluations (-wanted, +got): %s", diff)
+	}
+
+	// exports can be imported into another server
+	exported := runCommand(t, env, "", 0, "export")
+	runCommand(t, env, "", 0, "delete", "https://scopes.impractical.co/a", "https://scopes.impractical.co/b")
+	runCommand(t, env, "", 1, "get", "https://scopes.impractical.co/a")
+	out = runCommand(t, env, exported, 0, "-output", "json", "import", "-conflict", "skip")
+	var result apiv1.ImportResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Error decoding import output %q: %s", out, err)
+	}
+	if diff := cmp.Diff(apiv1.ImportResult{Created: 2}, result); diff != "" {
+		t.Errorf("Unexpected import result (-wanted, +got): %s", diff)
+	}
+	if diff := cmp.Diff(expectedTable, runCommand(t, env, "", 0, "list")); diff != "" {
+		t.Errorf("Unexpected list output after import (-wanted, +got): %s", diff)
+	}
+
+	// and importing again fails, unless conflicts are skipped
+	runCommand(t, env, exported, 1, "import")
+
+	runCommand(t, env, "", 2, "create", "-id", "https://scopes.impractical.co/c")
+	runCommand(t, env, "", 2, "frobnicate")
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte("url: https://scopes.example.com/v1\nkey: file-key\nsecret: file-secret\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	env := map[string]string{envKey: "env-key"}
+	getenv := func(key string) string { return env[key] }
+
+	conf, err := loadConfig(path, getenv)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	if diff := cmp.Diff(config{URL: "https://scopes.example.com/v1", Key: "env-key", Secret: "file-secret"}, conf); diff != "" {
+		t.Errorf("Unexpected config (-wanted, +got): %s", diff)
+	}
+
+	// explicitly configured files need to exist
+	_, err = loadConfig(filepath.Join(filepath.Dir(path), "missing.yaml"), getenv)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+	// and something needs to provide the URL and credentials
+	empty := filepath.Join(filepath.Dir(path), "empty.yaml")
+	err = ioutil.WriteFile(empty, nil, 0o600)
+	if err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	env = map[string]string{envConfig: empty, envURL: "https://scopes.example.com/v1"}
+	_, err = loadConfig("", getenv)
+	if !errors.Is(err, errNoCredentials) {
+		t.Errorf("Expected errNoCredentials, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+)
+
+const (
+	// formatTable writes output as a table, for people to read.
+	formatTable = "table"
+	// formatJSON writes output as JSON, for programs to read.
+	formatJSON = "json"
+)
+
+// errUnknownFormat is returned when an output format isn't recognized.
+var errUnknownFormat = errors.New("unknown output format; use " + formatTable + " or " + formatJSON)
+
+// printer writes the results of commands in the chosen format.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func (p printer) json(value interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(value)
+	if err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+// table writes `rows` under `headers`, with the columns aligned.
+func (p printer) table(headers []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0) //nolint:gomnd // padding, not magic
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+func formatExceptions(exceptions []string) string {
+	if len(exceptions) < 1 {
+		return "-"
+	}
+	return strings.Join(exceptions, ",")
+}
+
+func (p printer) scopes(results []scopes.Scope) error {
+	if p.format == formatJSON {
+		out := make([]apiv1.Scope, 0, len(results))
+		for _, scope := range results {
+			out = append(out, apiv1.Scope{
+				ID:               scope.ID,
+				UserPolicy:       scope.UserPolicy,
+				UserExceptions:   scope.UserExceptions,
+				ClientPolicy:     scope.ClientPolicy,
+				ClientExceptions: scope.ClientExceptions,
+				IsDefault:        scope.IsDefault,
+			})
+		}
+		return p.json(out)
+	}
+	rows := make([][]string, 0, len(results))
+	for _, scope := range results {
+		rows = append(rows, []string{
+			scope.ID,
+			scope.UserPolicy,
+			formatExceptions(scope.UserExceptions),
+			scope.ClientPolicy,
+			formatExceptions(scope.ClientExceptions),
+			strconv.FormatBool(scope.IsDefault),
+		})
+	}
+	return p.table([]string{"ID", "USER POLICY", "USER EXCEPTIONS", "CLIENT POLICY", "CLIENT EXCEPTIONS", "DEFAULT"}, rows)
+}
+
+// evaluation is whether a user or client can use a Scope.
+type evaluation struct {
+	Scope     string `json:"scope"`
+	Principal string `json:"principal"`
+	ID        string `json:"id"`
+	Allowed   bool   `json:"allowed"`
+}
+
+func (p printer) evaluations(results []evaluation) error {
+	if p.format == formatJSON {
+		if results == nil {
+			results = []evaluation{}
+		}
+		return p.json(results)
+	}
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		decision := "deny"
+		if result.Allowed {
+			decision = "allow"
+		}
+		rows = append(rows, []string{result.Scope, result.Principal, result.ID, decision})
+	}
+	return p.table([]string{"SCOPE", "PRINCIPAL", "ID", "DECISION"}, rows)
+}
+
+func (p printer) importResult(result scopes.ImportResult) error {
+	if p.format == formatJSON {
+		return p.json(apiv1.ImportResult(result))
+	}
+	return p.table([]string{"CREATED", "UPDATED", "SKIPPED"}, [][]string{{
+		strconv.Itoa(result.Created),
+		strconv.Itoa(result.Updated),
+		strconv.Itoa(result.Skipped),
+	}})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"yall.in"
+)
+
+const (
+	// storerMemory keeps Scopes in memory, losing them when the server
+	// stops.
+	storerMemory = "memory"
+	// storerPostgres keeps Scopes in a PostgreSQL database.
+	storerPostgres = "postgres"
+
+	defaultListen          = ":8080"
+	defaultBasePath        = "/"
+	defaultHealthPath      = "/health"
+	defaultMetricsPath     = "/metrics"
+	defaultLogLevel        = "info"
+	defaultShutdownTimeout = 30 * time.Second
+	defaultMaxSkew         = time.Minute
+)
+
+var (
+	// errUnknownStorer is returned when the configured Storer isn't
+	// recognized.
+	errUnknownStorer = errors.New("unknown storer; use " + storerMemory + " or " + storerPostgres)
+	// errUnknownLogLevel is returned when the configured log level isn't
+	// recognized.
+	errUnknownLogLevel = errors.New("unknown log level; use debug, info, or error")
+	// errNoPostgresURL is returned when the postgres Storer is configured
+	// without a database to connect to.
+	errNoPostgresURL = errors.New("postgres.url must be set to use the postgres storer")
+	// errNoCredentials is returned when no HMAC key or secret is
+	// configured.
+	errNoCredentials = errors.New("hmac.key and hmac.secret must be set")
+	// errIncompleteTLS is returned when only one of the TLS certificate
+	// and key is configured.
+	errIncompleteTLS = errors.New("tls.cert and tls.key must both be set to use TLS")
+)
+
+// config is everything scopesd needs to run. It's read from a YAML file, and
+// each value can be overridden by the environment variable listed next to
+// it.
+type config struct {
+	// Listen is the address to listen on. SCOPESD_LISTEN.
+	Listen string `yaml:"listen"`
+	// BasePath is the path v1 of the API is served under.
+	// SCOPESD_BASE_PATH.
+	BasePath string `yaml:"basePath"`
+	// HealthPath is the path the unauthenticated liveness and readiness
+	// probes are served under, at /live and /ready. SCOPESD_HEALTH_PATH.
+	HealthPath string `yaml:"healthPath"`
+	// MetricsPath is the path Prometheus metrics are served at, without
+	// authentication. If it's empty, metrics aren't collected.
+	// SCOPESD_METRICS_PATH.
+	MetricsPath string `yaml:"metricsPath"`
+	// LogLevel is the least severe level that gets logged: debug, info,
+	// or error. SCOPESD_LOG_LEVEL.
+	LogLevel string `yaml:"logLevel"`
+	// ShutdownTimeout is how long requests in progress get to finish
+	// when the server is stopped. SCOPESD_SHUTDOWN_TIMEOUT.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// Storer is where Scopes are kept: memory or postgres.
+	// SCOPESD_STORER.
+	Storer   string         `yaml:"storer"`
+	Postgres postgresConfig `yaml:"postgres"`
+	HMAC     hmacConfig     `yaml:"hmac"`
+	TLS      tlsConfig      `yaml:"tls"`
+}
+
+type postgresConfig struct {
+	// URL is the connection string for the database.
+	// SCOPESD_POSTGRES_URL.
+	URL string `yaml:"url"`
+	// Migrate, if true, applies any migrations the database is missing
+	// at startup. If false, the server refuses to start with migrations
+	// pending. SCOPESD_POSTGRES_MIGRATE.
+	Migrate bool `yaml:"migrate"`
+}
+
+type hmacConfig struct {
+	// Key and Secret are the credentials requests must be signed with.
+	// SCOPESD_HMAC_KEY and SCOPESD_HMAC_SECRET.
+	Key    string `yaml:"key"`
+	Secret string `yaml:"secret"`
+	// MaxSkew is how far the clocks of clients can drift from the
+	// server's before their signatures are rejected.
+	// SCOPESD_HMAC_MAX_SKEW.
+	MaxSkew time.Duration `yaml:"maxSkew"`
+}
+
+type tlsConfig struct {
+	// Cert and Key are paths to the PEM-encoded certificate and private
+	// key to serve TLS with. If neither is set, the server serves plain
+	// HTTP. SCOPESD_TLS_CERT and SCOPESD_TLS_KEY.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:          defaultListen,
+		BasePath:        defaultBasePath,
+		HealthPath:      defaultHealthPath,
+		MetricsPath:     defaultMetricsPath,
+		LogLevel:        defaultLogLevel,
+		ShutdownTimeout: defaultShutdownTimeout,
+		Storer:          storerMemory,
+		Postgres:        postgresConfig{Migrate: true},
+		HMAC:            hmacConfig{MaxSkew: defaultMaxSkew},
+	}
+}
+
+// loadConfig reads the config file at `path`, if it's not empty, on top of
+// the defaults, then applies any overrides from the environment, read using
+// `getenv`, and validates the result.
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	conf := defaultConfig()
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config{}, fmt.Errorf("error reading config file: %w", err)
+		}
+		err = yaml.UnmarshalStrict(contents, &conf)
+		if err != nil {
+			return config{}, fmt.Errorf("error decoding config file %s: %w", path, err)
+		}
+	}
+
+	stringVars := map[string]*string{
+		"SCOPESD_LISTEN":       &conf.Listen,
+		"SCOPESD_BASE_PATH":    &conf.BasePath,
+		"SCOPESD_HEALTH_PATH":  &conf.HealthPath,
+		"SCOPESD_METRICS_PATH": &conf.MetricsPath,
+		"SCOPESD_LOG_LEVEL":    &conf.LogLevel,
+		"SCOPESD_STORER":       &conf.Storer,
+		"SCOPESD_POSTGRES_URL": &conf.Postgres.URL,
+		"SCOPESD_HMAC_KEY":     &conf.HMAC.Key,
+		"SCOPESD_HMAC_SECRET":  &conf.HMAC.Secret,
+		"SCOPESD_TLS_CERT":     &conf.TLS.Cert,
+		"SCOPESD_TLS_KEY":      &conf.TLS.Key,
+	}
+	for name, value := range stringVars {
+		if env := getenv(name); env != "" {
+			*value = env
+		}
+	}
+	durationVars := map[string]*time.Duration{
+		"SCOPESD_SHUTDOWN_TIMEOUT": &conf.ShutdownTimeout,
+		"SCOPESD_HMAC_MAX_SKEW":    &conf.HMAC.MaxSkew,
+	}
+	for name, value := range durationVars {
+		env := getenv(name)
+		if env == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(env)
+		if err != nil {
+			return config{}, fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*value = parsed
+	}
+	if env := getenv("SCOPESD_POSTGRES_MIGRATE"); env != "" {
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return config{}, fmt.Errorf("error parsing SCOPESD_POSTGRES_MIGRATE: %w", err)
+		}
+		conf.Postgres.Migrate = parsed
+	}
+
+	return conf, conf.validate()
+}
+
+func (c config) validate() error {
+	if _, err := c.logLevel(); err != nil {
+		return err
+	}
+	switch c.Storer {
+	case storerMemory:
+	case storerPostgres:
+		if c.Postgres.URL == "" {
+			return errNoPostgresURL
+		}
+	default:
+		return fmt.Errorf("%w: %q", errUnknownStorer, c.Storer)
+	}
+	if c.HMAC.Key == "" || c.HMAC.Secret == "" {
+		return errNoCredentials
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return errIncompleteTLS
+	}
+	return nil
+}
+
+func (c config) logLevel() (yall.Severity, error) {
+	switch strings.ToLower(c.LogLevel) {
+	case "debug":
+		return yall.Debug, nil
+	case "info":
+		return yall.Info, nil
+	case "error":
+		return yall.Error, nil
+	default:
+		return yall.Debug, fmt.Errorf("%w: %q", errUnknownLogLevel, c.LogLevel)
+	}
+}
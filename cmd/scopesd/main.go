@@ -0,0 +1,238 @@
+// Command scopesd serves v1 of the scopes API, storing Scopes in memory or in
+// a PostgreSQL database.
+//
+// It's configured using a YAML file, passed with -config or found at the path
+// in SCOPESD_CONFIG, and environment variables, which override the values in
+// the file. See the config type for the available settings. Requests must be
+// signed with the configured HMAC credentials.
+//
+// Liveness and readiness probes are served, without authentication, at
+// /live and /ready under the configured health path. The readiness probe
+// checks that the database can be reached and has no pending migrations.
+// Prometheus metrics about requests, database calls, and access decisions
+// are served, also without authentication, at the configured metrics path.
+//
+// When it receives SIGINT or SIGTERM, scopesd stops accepting new requests
+// and waits for the requests in progress to finish, up to the configured
+// shutdown timeout, before exiting.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/hmac"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/metrics"
+	"lockbox.dev/scopes/storers/memory"
+	"lockbox.dev/scopes/storers/postgres"
+)
+
+// readHeaderTimeout is how long clients get to send the headers of a
+// request.
+const readHeaderTimeout = 10 * time.Second
+
+// version is the build version reported by the health probes. It can be set
+// at build time with -ldflags "-X main.version=...".
+var version string
+
+// buildVersion returns the version reported by the health probes: version,
+// if it was set at build time, or the version of the module scopesd was
+// built from.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// errPendingMigrations is returned when the database is missing migrations
+// and scopesd isn't configured to apply them.
+var errPendingMigrations = errors.New("database has pending migrations; apply them or set postgres.migrate")
+
+// newStorer returns the Storer `conf` describes, and a function to call to
+// release its resources once it's no longer needed.
+func newStorer(ctx context.Context, conf config, log *yall.Logger) (scopes.Storer, func() error, error) {
+	if conf.Storer != storerPostgres {
+		storer, err := memory.NewStorer()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating memory storer: %w", err)
+		}
+		log.Info("storing scopes in memory; they will be lost when the server stops")
+		return storer, func() error { return nil }, nil
+	}
+
+	db, err := sql.Open("postgres", conf.Postgres.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening database: %w", err)
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close() //nolint:errcheck,gosec // already failing, nothing else to do
+		return nil, nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	if conf.Postgres.Migrate {
+		applied, err := postgres.Migrate(ctx, db, postgres.MigrateUp)
+		if err != nil {
+			db.Close() //nolint:errcheck,gosec // already failing, nothing else to do
+			return nil, nil, fmt.Errorf("error migrating database: %w", err)
+		}
+		log.WithField("migrations", applied).Info("migrated database")
+	} else {
+		pending, err := postgres.PendingMigrations(ctx, db)
+		if err != nil {
+			db.Close() //nolint:errcheck,gosec // already failing, nothing else to do
+			return nil, nil, fmt.Errorf("error checking migrations: %w", err)
+		}
+		if len(pending) > 0 {
+			db.Close() //nolint:errcheck,gosec // already failing, nothing else to do
+			return nil, nil, fmt.Errorf("%w: %s", errPendingMigrations, strings.Join(pending, ", "))
+		}
+	}
+	return postgres.NewWatchingStorer(ctx, db, conf.Postgres.URL), db.Close, nil
+}
+
+// serve serves the API on `listener` until `ctx` is canceled, then shuts
+// down gracefully.
+func serve(ctx context.Context, conf config, log *yall.Logger, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return yall.InContext(context.Background(), log)
+		},
+	}
+	errs := make(chan error, 1)
+	go func() {
+		if conf.TLS.Cert != "" {
+			errs <- server.ServeTLS(listener, conf.TLS.Cert, conf.TLS.Key)
+			return
+		}
+		errs <- server.Serve(listener)
+	}()
+	log.WithField("addr", listener.Addr().String()).WithField("tls", conf.TLS.Cert != "").Info("serving requests")
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("error serving requests: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.WithField("timeout", conf.ShutdownTimeout.String()).Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// long-lived requests, like event streams, don't finish on
+		// their own; once the timeout is up, cut them off
+		log.WithError(err).Warn("requests still in progress at shutdown timeout, closing them")
+		err = server.Close()
+		if err != nil {
+			return fmt.Errorf("error closing server: %w", err)
+		}
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving requests: %w", err)
+	}
+	log.Info("shut down")
+	return nil
+}
+
+// run loads the config and serves the API until `ctx` is canceled.
+func run(ctx context.Context, args []string, getenv func(string) string, logOut io.Writer) error {
+	flags := flag.NewFlagSet("scopesd", flag.ContinueOnError)
+	configPath := flags.String("config", getenv("SCOPESD_CONFIG"), "path to the config file")
+	err := flags.Parse(args)
+	if err != nil {
+		return err //nolint:wrapcheck // the flag package already reported it
+	}
+	conf, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		return err
+	}
+	level, err := conf.logLevel()
+	if err != nil {
+		return err
+	}
+	log := yall.New(colour.New(logOut, level))
+
+	storer, closeStorer, err := newStorer(ctx, conf, log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := closeStorer(); err != nil {
+			log.WithError(err).Error("error closing storer")
+		}
+	}()
+
+	mux := http.NewServeMux()
+	var middleware func(http.Handler) http.Handler
+	if conf.MetricsPath != "" {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		m, err := metrics.New(reg)
+		if err != nil {
+			return err //nolint:wrapcheck // already wrapped by metrics.New
+		}
+		storer = m.InstrumentStorer(storer)
+		middleware = m.Middleware
+		mux.Handle(path.Join("/", conf.MetricsPath), promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+
+	v1 := apiv1.APIv1{
+		Dependencies: scopes.Dependencies{Storer: storer},
+		Log:          log,
+		Signer: hmac.Signer{
+			Key:     conf.HMAC.Key,
+			Secret:  []byte(conf.HMAC.Secret),
+			MaxSkew: conf.HMAC.MaxSkew,
+		},
+		Middleware: middleware,
+	}
+	listener, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", conf.Listen, err)
+	}
+	mux.Handle(path.Join("/", conf.HealthPath)+"/", v1.HealthHandler(conf.HealthPath, buildVersion()))
+	mux.Handle("/", v1.Server(conf.BasePath))
+	return serve(ctx, conf, log, listener, mux)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Getenv, os.Stdout)
+	stop()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
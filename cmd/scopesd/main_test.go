@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"yall.in"
+	"yall.in/colour"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte(`listen: ":9000"
+storer: postgres
+postgres:
+  url: postgres://file
+hmac:
+  key: file-key
+  secret: file-secret
+shutdownTimeout: 5s
+`), 0o600)
+	if err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	env := map[string]string{
+		"SCOPESD_POSTGRES_URL":     "postgres://env",
+		"SCOPESD_POSTGRES_MIGRATE": "false",
+		"SCOPESD_LOG_LEVEL":        "debug",
+		"SCOPESD_HMAC_MAX_SKEW":    "2m",
+	}
+	conf, err := loadConfig(path, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	expected := config{
+		Listen:          ":9000",
+		BasePath:        defaultBasePath,
+		HealthPath:      defaultHealthPath,
+		MetricsPath:     defaultMetricsPath,
+		LogLevel:        "debug",
+		ShutdownTimeout: 5 * time.Second,
+		Storer:          storerPostgres,
+		Postgres:        postgresConfig{URL: "postgres://env"},
+		HMAC:            hmacConfig{Key: "file-key", Secret: "file-secret", MaxSkew: 2 * time.Minute},
+	}
+	if diff := cmp.Diff(expected, conf); diff != "" {
+		t.Errorf("Unexpected config (-wanted, +got): %s", diff)
+	}
+
+	invalid := map[string]struct {
+		env map[string]string
+		err error
+	}{
+		"no credentials": {
+			env: map[string]string{},
+			err: errNoCredentials,
+		},
+		"unknown storer": {
+			env: map[string]string{"SCOPESD_HMAC_KEY": "k", "SCOPESD_HMAC_SECRET": "s", "SCOPESD_STORER": "bolt"},
+			err: errUnknownStorer,
+		},
+		"no postgres url": {
+			env: map[string]string{"SCOPESD_HMAC_KEY": "k", "SCOPESD_HMAC_SECRET": "s", "SCOPESD_STORER": "postgres"},
+			err: errNoPostgresURL,
+		},
+		"unknown log level": {
+			env: map[string]string{"SCOPESD_HMAC_KEY": "k", "SCOPESD_HMAC_SECRET": "s", "SCOPESD_LOG_LEVEL": "loud"},
+			err: errUnknownLogLevel,
+		},
+		"half of tls": {
+			env: map[string]string{"SCOPESD_HMAC_KEY": "k", "SCOPESD_HMAC_SECRET": "s", "SCOPESD_TLS_CERT": "cert.pem"},
+			err: errIncompleteTLS,
+		},
+	}
+	for name, test := range invalid {
+		_, err := loadConfig("", func(key string) string { return test.env[key] })
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+	}
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	conf := defaultConfig()
+	log := yall.New(colour.New(ioutil.Discard, yall.Error))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+
+	// a request that's in progress when shutdown starts gets to finish
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, conf, log, listener, handler)
+	}()
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			t.Errorf("Error making request: %s", err)
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	if status := <-responses; status != http.StatusNoContent {
+		t.Errorf("Expected in-progress request to finish with %d, got %d", http.StatusNoContent, status)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Unexpected error serving: %s", err)
+	}
+}
@@ -0,0 +1,47 @@
+package scopes
+
+import (
+	"context"
+)
+
+const (
+	// PrincipalUser is the Principal of Decisions about users.
+	PrincipalUser = "user"
+	// PrincipalClient is the Principal of Decisions about clients.
+	PrincipalClient = "client"
+)
+
+type decisionRecorderKey struct{}
+
+// Decision describes the outcome of a call to UserCanUseScope or
+// ClientCanUseScope. Principal is PrincipalUser or PrincipalClient, and
+// Policy is the policy of the Scope that applied to that principal.
+type Decision struct {
+	Scope     string
+	Principal string
+	Policy    string
+	Allowed   bool
+}
+
+// DecisionRecorder is notified of every Decision made using a context.Context
+// it was added to using WithDecisionRecorder, so access decisions can be
+// counted or audited.
+type DecisionRecorder interface {
+	RecordDecision(ctx context.Context, decision Decision)
+}
+
+// WithDecisionRecorder returns a copy of `ctx` that UserCanUseScope and
+// ClientCanUseScope will notify `recorder` of their Decisions through.
+func WithDecisionRecorder(ctx context.Context, recorder DecisionRecorder) context.Context {
+	return context.WithValue(ctx, decisionRecorderKey{}, recorder)
+}
+
+// recordDecision notifies the DecisionRecorder in `ctx`, if there is one, of
+// `decision`.
+func recordDecision(ctx context.Context, decision Decision) {
+	recorder, ok := ctx.Value(decisionRecorderKey{}).(DecisionRecorder)
+	if !ok || recorder == nil {
+		return
+	}
+	recorder.RecordDecision(ctx, decision)
+}
@@ -0,0 +1,286 @@
+package scopes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// ExportVersion is the version of the format written by Export.
+	// Import reads exports of this version and earlier.
+	ExportVersion = 1
+
+	// ConflictSkip is the ConflictStrategy that leaves Scopes that already
+	// exist alone, importing only the Scopes that don't.
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictOverwrite is the ConflictStrategy that replaces Scopes that
+	// already exist with the imported version.
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	// ConflictFail is the ConflictStrategy that stops the import when a
+	// Scope already exists.
+	ConflictFail ConflictStrategy = "fail"
+
+	// exportPageSize is how many Scopes Export reads from the Storer at a
+	// time.
+	exportPageSize = 100
+)
+
+var (
+	// ErrUnknownConflictStrategy is returned when importing with a
+	// ConflictStrategy that isn't recognized.
+	ErrUnknownConflictStrategy = errors.New("unknown conflict strategy")
+)
+
+// ConflictStrategy controls what Import does when a Scope being imported
+// already exists.
+type ConflictStrategy string
+
+// UnsupportedExportVersionError is returned when importing an export written
+// in a format this version of the package doesn't understand.
+type UnsupportedExportVersionError struct {
+	Version int
+}
+
+func (e UnsupportedExportVersionError) Error() string {
+	return fmt.Sprintf("unsupported export version %d, expected %d or earlier", e.Version, ExportVersion)
+}
+
+// ImportError is returned when a line of an import isn't valid, or holds a
+// Scope that already exists and the ConflictStrategy is ConflictFail. Line
+// is the line number the problem is on, starting from 1. ID is the ID of the
+// Scope on that line, if it could be read, and Field is the name of the
+// field in the line that caused the problem, if there is one.
+type ImportError struct {
+	Line  int
+	ID    string
+	Field string
+	Err   error
+}
+
+func (e ImportError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d (%q): invalid %s: %s", e.Line, e.ID, e.Field, e.Err)
+}
+
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportResult describes what an import changed.
+type ImportResult struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+// exportHeader is the first line of an export.
+type exportHeader struct {
+	Version int `json:"version"`
+}
+
+// exportScope is the representation of a Scope on each line of an export
+// after the header.
+type exportScope struct {
+	ID               string   `json:"id"`
+	UserPolicy       string   `json:"userPolicy"`
+	UserExceptions   []string `json:"userExceptions"`
+	ClientPolicy     string   `json:"clientPolicy"`
+	ClientExceptions []string `json:"clientExceptions"`
+	IsDefault        bool     `json:"isDefault"`
+}
+
+func toExportScope(scope Scope) exportScope {
+	return exportScope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func fromExportScope(scope exportScope) Scope {
+	return Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+// Export writes every Scope in `storer`, sorted lexicographically by ID, to
+// `w` as JSON Lines: a header line recording the ExportVersion, followed by
+// one line per Scope. Scopes are read from `storer` a page at a time, so
+// exports of any size can be streamed. If `storer` implements Transactor
+// and can start a transaction, the pages are all read in the same
+// transaction, so the export is consistent. The number of Scopes written is
+// returned.
+func Export(ctx context.Context, storer Storer, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	err := enc.Encode(exportHeader{Version: ExportVersion})
+	if err != nil {
+		return 0, fmt.Errorf("error writing export header: %w", err)
+	}
+	var written int
+	export := func(ctx context.Context, storer Storer) error {
+		var after string
+		for {
+			page, err := storer.List(ctx, after, exportPageSize)
+			if err != nil {
+				return fmt.Errorf("error listing scopes after %q: %w", after, err)
+			}
+			if len(page) < 1 {
+				return nil
+			}
+			for _, scope := range page {
+				err = enc.Encode(toExportScope(scope))
+				if err != nil {
+					return fmt.Errorf("error writing scope %q: %w", scope.ID, err)
+				}
+				written++
+			}
+			after = page[len(page)-1].ID
+		}
+	}
+	err = ErrTxUnsupported
+	if transactor, ok := storer.(Transactor); ok {
+		err = transactor.WithTx(ctx, export)
+	}
+	if errors.Is(err, ErrTxUnsupported) {
+		err = export(ctx, storer)
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// Import reads an export written by Export from `r` and stores each of its
+// Scopes in `storer`, handling Scopes that already exist according to
+// `strategy`. Lines are read and stored one at a time, so imports of any
+// size can be streamed.
+//
+// If `storer` implements Transactor and can start a transaction, the import
+// is applied in a single transaction, and either every Scope is imported or none are. Otherwise,
+// the Scopes before a failed line stay imported, and the returned
+// ImportResult describes them.
+//
+// Problems with a line, including a Scope that already exists when
+// `strategy` is ConflictFail, are returned as an ImportError.
+func Import(ctx context.Context, storer Storer, r io.Reader, strategy ConflictStrategy) (ImportResult, error) {
+	switch strategy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return ImportResult{}, fmt.Errorf("%w %q", ErrUnknownConflictStrategy, strategy)
+	}
+	var result ImportResult
+	importScopes := func(ctx context.Context, storer Storer) error {
+		return importLines(ctx, storer, bufio.NewReader(r), strategy, &result)
+	}
+	if transactor, ok := storer.(Transactor); ok {
+		err := transactor.WithTx(ctx, importScopes)
+		if err == nil {
+			return result, nil
+		}
+		if !errors.Is(err, ErrTxUnsupported) {
+			// the transaction was rolled back, so nothing changed
+			return ImportResult{}, err
+		}
+	}
+	err := importScopes(ctx, storer)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func importLines(ctx context.Context, storer Storer, r *bufio.Reader, strategy ConflictStrategy, result *ImportResult) error {
+	var sawHeader bool
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := r.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("error reading line %d: %w", lineNum, readErr)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var err error
+			if !sawHeader {
+				err = checkExportHeader(lineNum, line)
+				sawHeader = true
+			} else {
+				err = importLine(ctx, storer, lineNum, line, strategy, result)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if !sawHeader {
+		return ImportError{Line: 1, Field: "version", Err: UnsupportedExportVersionError{}}
+	}
+	return nil
+}
+
+func checkExportHeader(lineNum int, line []byte) error {
+	var header exportHeader
+	err := json.Unmarshal(line, &header)
+	if err != nil {
+		return ImportError{Line: lineNum, Err: fmt.Errorf("error decoding header: %w", err)}
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return ImportError{Line: lineNum, Field: "version", Err: UnsupportedExportVersionError{Version: header.Version}}
+	}
+	return nil
+}
+
+func importLine(ctx context.Context, storer Storer, lineNum int, line []byte, strategy ConflictStrategy, result *ImportResult) error {
+	var imported exportScope
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&imported)
+	if err != nil {
+		return ImportError{Line: lineNum, Err: fmt.Errorf("error decoding scope: %w", err)}
+	}
+	scope := fromExportScope(imported)
+	if field, err := Validate(scope); err != nil {
+		return ImportError{Line: lineNum, ID: scope.ID, Field: field, Err: err}
+	}
+
+	if strategy == ConflictOverwrite {
+		created, err := storer.Put(ctx, scope)
+		if err != nil {
+			return fmt.Errorf("error storing %q from line %d: %w", scope.ID, lineNum, err)
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		return nil
+	}
+	err = storer.Create(ctx, scope)
+	switch {
+	case errors.Is(err, ErrScopeAlreadyExists) && strategy == ConflictSkip:
+		result.Skipped++
+	case errors.Is(err, ErrScopeAlreadyExists):
+		return ImportError{Line: lineNum, ID: scope.ID, Field: "id", Err: err}
+	case err != nil:
+		return fmt.Errorf("error storing %q from line %d: %w", scope.ID, lineNum, err)
+	default:
+		result.Created++
+	}
+	return nil
+}
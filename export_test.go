@@ -0,0 +1,191 @@
+package scopes_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func newMemoryStorer(t *testing.T, ctx context.Context, contents ...scopes.Scope) *memory.Storer {
+	t.Helper()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	for _, scope := range contents {
+		err = storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Error creating scope %q: %s", scope.ID, err)
+		}
+	}
+	return storer
+}
+
+var exportedScopes = []scopes.Scope{
+	{
+		ID:               "https://scopes.impractical.co/a",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"user-1", "user-2"},
+		ClientPolicy:     scopes.PolicyDefaultAllow,
+		ClientExceptions: []string{"client-1"},
+		IsDefault:        true,
+	},
+	{
+		ID:           "https://scopes.impractical.co/b",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyDenyAll,
+	},
+}
+
+const exported = `{"version":1}
+{"id":"https://scopes.impractical.co/a","userPolicy":"DEFAULT_DENY","userExceptions":["user-1","user-2"],"clientPolicy":"DEFAULT_ALLOW","clientExceptions":["client-1"],"isDefault":true}
+{"id":"https://scopes.impractical.co/b","userPolicy":"ALLOW_ALL","userExceptions":null,"clientPolicy":"DENY_ALL","clientExceptions":null,"isDefault":false}
+`
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryStorer(t, ctx, exportedScopes[1], exportedScopes[0])
+
+	var buf bytes.Buffer
+	written, err := scopes.Export(ctx, source, &buf)
+	if err != nil {
+		t.Fatalf("Error exporting: %s", err)
+	}
+	if written != len(exportedScopes) {
+		t.Errorf("Expected %d scopes to be exported, got %d", len(exportedScopes), written)
+	}
+	if diff := cmp.Diff(exported, buf.String()); diff != "" {
+		t.Errorf("Unexpected export (-wanted, +got): %s", diff)
+	}
+
+	dest := newMemoryStorer(t, ctx)
+	result, err := scopes.Import(ctx, dest, &buf, scopes.ConflictFail)
+	if err != nil {
+		t.Fatalf("Error importing: %s", err)
+	}
+	if diff := cmp.Diff(scopes.ImportResult{Created: 2}, result); diff != "" {
+		t.Errorf("Unexpected import result (-wanted, +got): %s", diff)
+	}
+	imported, err := dest.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	if diff := cmp.Diff(exportedScopes, imported, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected imported scopes (-wanted, +got): %s", diff)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	ctx := context.Background()
+	existing := scopes.Scope{
+		ID:           exportedScopes[0].ID,
+		UserPolicy:   scopes.PolicyDenyAll,
+		ClientPolicy: scopes.PolicyDenyAll,
+	}
+
+	tests := map[scopes.ConflictStrategy]struct {
+		result   scopes.ImportResult
+		expected []scopes.Scope
+		err      error
+	}{
+		scopes.ConflictSkip: {
+			result:   scopes.ImportResult{Created: 1, Skipped: 1},
+			expected: []scopes.Scope{existing, exportedScopes[1]},
+		},
+		scopes.ConflictOverwrite: {
+			result:   scopes.ImportResult{Created: 1, Updated: 1},
+			expected: exportedScopes,
+		},
+		scopes.ConflictFail: {
+			// the import is rolled back, so nothing changes
+			expected: []scopes.Scope{existing},
+			err:      scopes.ErrScopeAlreadyExists,
+		},
+	}
+	for strategy, test := range tests {
+		storer := newMemoryStorer(t, ctx, existing)
+		result, err := scopes.Import(ctx, storer, strings.NewReader(exported), strategy)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %v", strategy, test.err, err)
+		}
+		if diff := cmp.Diff(test.result, result); diff != "" {
+			t.Errorf("%s: unexpected import result (-wanted, +got): %s", strategy, diff)
+		}
+		listed, err := storer.List(ctx, "", 0)
+		if err != nil {
+			t.Fatalf("%s: error listing scopes: %s", strategy, err)
+		}
+		if diff := cmp.Diff(test.expected, listed, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: unexpected scopes after import (-wanted, +got): %s", strategy, diff)
+		}
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		input string
+		line  int
+		field string
+	}{
+		"no header": {
+			input: "",
+			line:  1,
+			field: "version",
+		},
+		"future version": {
+			input: `{"version":2}` + "\n",
+			line:  1,
+			field: "version",
+		},
+		"bad policy": {
+			input: `{"version":1}` + "\n\n" +
+				`{"id":"https://scopes.impractical.co/a","userPolicy":"ALLOW_ALL","clientPolicy":"ALLOW_ALL"}` + "\n" +
+				`{"id":"https://scopes.impractical.co/b","userPolicy":"ALLOW_ALL","clientPolicy":"NOPE"}` + "\n",
+			line:  4,
+			field: "clientPolicy",
+		},
+		"missing id": {
+			input: `{"version":1}` + "\n" + `{"userPolicy":"ALLOW_ALL","clientPolicy":"ALLOW_ALL"}`,
+			line:  2,
+			field: "id",
+		},
+		"unknown field": {
+			input: `{"version":1}` + "\n" + `{"id":"https://scopes.impractical.co/a","policy":"ALLOW_ALL"}` + "\n",
+			line:  2,
+		},
+	}
+	for name, test := range tests {
+		storer := newMemoryStorer(t, ctx)
+		_, err := scopes.Import(ctx, storer, strings.NewReader(test.input), scopes.ConflictFail)
+		var importErr scopes.ImportError
+		if !errors.As(err, &importErr) {
+			t.Errorf("%s: expected ImportError, got %v", name, err)
+			continue
+		}
+		if importErr.Line != test.line || importErr.Field != test.field {
+			t.Errorf("%s: expected error on line %d, field %q, got %+v", name, test.line, test.field, importErr)
+		}
+		listed, err := storer.List(ctx, "", 0)
+		if err != nil {
+			t.Fatalf("%s: error listing scopes: %s", name, err)
+		}
+		if len(listed) != 0 {
+			t.Errorf("%s: expected nothing to be imported, got %+v", name, listed)
+		}
+	}
+
+	_, err := scopes.Import(ctx, newMemoryStorer(t, ctx), strings.NewReader(exported), "merge")
+	if !errors.Is(err, scopes.ErrUnknownConflictStrategy) {
+		t.Errorf("Expected ErrUnknownConflictStrategy, got %v", err)
+	}
+}
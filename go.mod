@@ -8,10 +8,14 @@ require (
 	github.com/hashicorp/go-memdb v1.3.4
 	github.com/hashicorp/go-uuid v1.0.3
 	github.com/lib/pq v1.10.7
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/prometheus/client_golang v1.13.0
 	github.com/rubenv/sql-migrate v0.0.0-20200616145509-8d140a17f351
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v2 v2.4.0
 	impractical.co/pqarrays v0.1.0
 	lockbox.dev/hmac v0.2.0
 	yall.in v0.0.8
 )
 
-go 1.13
+go 1.16
@@ -0,0 +1,160 @@
+// Package metrics records Prometheus metrics about the scopes service: the
+// requests served by the API, the latency and errors of the scopes.Storer
+// serving them, and the access decisions made along the way.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// namespace is the prefix of every metric's name.
+	namespace = "scopes"
+
+	// unknownPolicy is the policy label used for decisions about Scopes
+	// with a policy that isn't valid, so the label's values stay bounded.
+	unknownPolicy = "unknown"
+)
+
+// Metrics holds the Prometheus collectors for the scopes service. Use New to
+// create one.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storerDuration  *prometheus.HistogramVec
+	storerErrors    *prometheus.CounterVec
+	decisions       *prometheus.CounterVec
+}
+
+// New returns a Metrics whose collectors are registered with `reg`.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of requests served, by endpoint pattern, method, and status code.",
+		}, []string{"endpoint", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve requests, by endpoint pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "method"}),
+		storerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storer",
+			Name:      "call_duration_seconds",
+			Help:      "Time taken by calls to the Storer, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		storerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storer",
+			Name:      "errors_total",
+			Help:      "Number of calls to the Storer that failed, by method.",
+		}, []string{"method"}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "access",
+			Name:      "decisions_total",
+			Help:      "Number of decisions about whether a user or client can use a Scope, by principal, policy, and decision.",
+		}, []string{"principal", "policy", "decision"}),
+	}
+	for _, collector := range []prometheus.Collector{
+		m.requests, m.requestDuration, m.storerDuration, m.storerErrors, m.decisions,
+	} {
+		if err := reg.Register(collector); err != nil {
+			return nil, fmt.Errorf("error registering metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Middleware returns an http.Handler that records the number of requests
+// served by `h`, and how long they took, by the endpoint pattern in the
+// Trout-Pattern header. It's meant to be used as apiv1.APIv1.Middleware. The
+// request's context is given `m` as its scopes.DecisionRecorder, so access
+// decisions made while serving it are recorded.
+func (m *Metrics) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		endpoint := r.Header.Get("Trout-Pattern")
+		rec := &statusRecorder{ResponseWriter: w}
+		var rw http.ResponseWriter = rec
+		if _, ok := w.(http.Flusher); ok {
+			// keep event streams working
+			rw = flushingRecorder{rec}
+		}
+		r = r.WithContext(scopes.WithDecisionRecorder(r.Context(), m))
+		h.ServeHTTP(rw, r)
+		m.requestDuration.WithLabelValues(endpoint, r.Method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(endpoint, r.Method, strconv.Itoa(rec.code())).Inc()
+	})
+}
+
+// RecordDecision counts `decision`, by its principal, policy, and whether
+// access was allowed. It implements scopes.DecisionRecorder.
+func (m *Metrics) RecordDecision(_ context.Context, decision scopes.Decision) {
+	policy := decision.Policy
+	if !scopes.IsValidPolicy(policy) {
+		policy = unknownPolicy
+	}
+	result := "deny"
+	if decision.Allowed {
+		result = "allow"
+	}
+	m.decisions.WithLabelValues(decision.Principal, policy, result).Inc()
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status code
+// written to it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b) //nolint:wrapcheck // we want to return the wrapped ResponseWriter's errors unmodified
+}
+
+// code returns the status code of the response, which is http.StatusOK if
+// nothing was written.
+func (s *statusRecorder) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// flushingRecorder is a statusRecorder for http.ResponseWriters that
+// implement http.Flusher.
+type flushingRecorder struct {
+	*statusRecorder
+}
+
+func (f flushingRecorder) Flush() {
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	f.ResponseWriter.(http.Flusher).Flush() //nolint:forcetypeassert // only used when the ResponseWriter is an http.Flusher
+}
@@ -0,0 +1,239 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/metrics"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+var errListFailed = errors.New("list failed")
+
+// failingStorer is a Storer whose List always fails. It only has the
+// methods of scopes.Storer.
+type failingStorer struct {
+	scopes.Storer
+}
+
+func (failingStorer) List(_ context.Context, _ string, _ int) ([]scopes.Scope, error) {
+	return nil, errListFailed
+}
+
+// checkingStorer is a Storer that implements scopes.AccessChecker, allowing
+// everything.
+type checkingStorer struct {
+	scopes.Storer
+}
+
+func (checkingStorer) UserCanUse(_ context.Context, _, _ string) (bool, error) {
+	return true, nil
+}
+
+func (checkingStorer) ClientCanUse(_ context.Context, _, _ string) (bool, error) {
+	return true, nil
+}
+
+func (checkingStorer) ListUsable(_ context.Context, _ scopes.AccessQuery, _ string, _ int) ([]scopes.Scope, error) {
+	return nil, nil
+}
+
+func newMetrics(t *testing.T) (*metrics.Metrics, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("Error creating metrics: %s", err)
+	}
+	return m, reg
+}
+
+// sampleCount returns the number of observations made by the histogram
+// `name` with the label `label` set to `value`.
+func sampleCount(t *testing.T, reg *prometheus.Registry, name, label, value string) uint64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label && pair.GetValue() == value {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestMiddleware(t *testing.T) {
+	m, reg := newMetrics(t)
+	scope := scopes.Scope{
+		ID:               "https://scopes.impractical.co/metrics",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"allowed-user"},
+		ClientPolicy:     scopes.PolicyAllowAll,
+		ClientExceptions: []string{},
+	}
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes.UserCanUseScope(r.Context(), scope, "allowed-user")
+		scopes.UserCanUseScope(r.Context(), scope, "other-user")
+		scopes.ClientCanUseScope(r.Context(), scope, "client")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/scopes/abc", nil)
+		req.Header.Set("Trout-Pattern", "/scopes/{id}")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP scopes_http_requests_total Number of requests served, by endpoint pattern, method, and status code.
+# TYPE scopes_http_requests_total counter
+scopes_http_requests_total{code="200",endpoint="/scopes/{id}",method="GET"} 2
+scopes_http_requests_total{code="404",endpoint="/scopes/{id}",method="DELETE"} 1
+# HELP scopes_access_decisions_total Number of decisions about whether a user or client can use a Scope, by principal, policy, and decision.
+# TYPE scopes_access_decisions_total counter
+scopes_access_decisions_total{decision="allow",policy="ALLOW_ALL",principal="client"} 3
+scopes_access_decisions_total{decision="allow",policy="DEFAULT_DENY",principal="user"} 3
+scopes_access_decisions_total{decision="deny",policy="DEFAULT_DENY",principal="user"} 3
+`), "scopes_http_requests_total", "scopes_access_decisions_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if count := sampleCount(t, reg, "scopes_http_request_duration_seconds", "method", http.MethodGet); count != 2 {
+		t.Errorf("Expected 2 GET request durations, got %d", count)
+	}
+}
+
+func TestMiddlewareKeepsFlusher(t *testing.T) {
+	m, _ := newMetrics(t)
+	var flushes bool
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, flushes = w.(http.Flusher)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+	if !flushes {
+		t.Error("Expected ResponseWriter to implement http.Flusher")
+	}
+}
+
+func TestInstrumentStorer(t *testing.T) {
+	ctx := context.Background()
+	m, reg := newMetrics(t)
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	storer := m.InstrumentStorer(backend)
+	transactor, ok := storer.(scopes.Transactor)
+	if !ok {
+		t.Fatal("Expected instrumented Storer to implement scopes.Transactor")
+	}
+
+	scope := scopes.Scope{ID: "https://scopes.impractical.co/storer", UserPolicy: scopes.PolicyAllowAll, ClientPolicy: scopes.PolicyAllowAll}
+	err = transactor.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		return tx.Create(ctx, scope)
+	})
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+	// expected outcomes don't count as errors
+	if err := storer.Create(ctx, scope); !errors.Is(err, scopes.ErrScopeAlreadyExists) {
+		t.Errorf("Expected %v, got %v", scopes.ErrScopeAlreadyExists, err)
+	}
+	if _, err := storer.Delete(ctx, "https://scopes.impractical.co/missing"); !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Errorf("Expected %v, got %v", scopes.ErrScopeNotFound, err)
+	}
+
+	failing := m.InstrumentStorer(failingStorer{Storer: backend})
+	// optional interfaces failingStorer doesn't implement are reported as
+	// unsupported, and aren't recorded
+	if _, err := failing.(scopes.Watcher).CurrentRevision(ctx); !errors.Is(err, scopes.ErrWatchUnsupported) { //nolint:forcetypeassert // instrumented Storers always implement scopes.Watcher
+		t.Errorf("Expected %v, got %v", scopes.ErrWatchUnsupported, err)
+	}
+	if _, err := failing.(scopes.AuditLister).ListAuditEntries(ctx); !errors.Is(err, scopes.ErrAuditUnsupported) { //nolint:forcetypeassert // instrumented Storers always implement scopes.AuditLister
+		t.Errorf("Expected %v, got %v", scopes.ErrAuditUnsupported, err)
+	}
+	if _, err := failing.(scopes.AccessChecker).ListUsable(ctx, scopes.AccessQuery{UserID: "user"}, "", 10); !errors.Is(err, scopes.ErrAccessCheckUnsupported) { //nolint:forcetypeassert // instrumented Storers always implement scopes.AccessChecker
+		t.Errorf("Expected %v, got %v", scopes.ErrAccessCheckUnsupported, err)
+	}
+	if _, err := scopes.ApplyBatch(ctx, failing, nil); !errors.Is(err, scopes.ErrBatchUnsupported) {
+		t.Errorf("Expected %v, got %v", scopes.ErrBatchUnsupported, err)
+	}
+	if _, err := failing.List(ctx, "", 10); !errors.Is(err, errListFailed) {
+		t.Errorf("Expected %v, got %v", errListFailed, err)
+	}
+
+	checker, ok := m.InstrumentStorer(checkingStorer{Storer: backend}).(scopes.AccessChecker)
+	if !ok {
+		t.Fatal("Expected instrumented checkingStorer to implement scopes.AccessChecker")
+	}
+	if _, err := checker.ListUsable(ctx, scopes.AccessQuery{UserID: "user"}, "", 10); err != nil {
+		t.Errorf("Unexpected error listing usable scopes: %s", err)
+	}
+
+	for method, expected := range map[string]uint64{"WithTx": 1, "Create": 2, "Delete": 1, "List": 1, "ListUsable": 1, "CurrentRevision": 0, "ListAuditEntries": 0, "ApplyBatch": 0} {
+		if count := sampleCount(t, reg, "scopes_storer_call_duration_seconds", "method", method); count != expected {
+			t.Errorf("Expected %d %s calls, got %d", expected, method, count)
+		}
+	}
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP scopes_storer_errors_total Number of calls to the Storer that failed, by method.
+# TYPE scopes_storer_errors_total counter
+scopes_storer_errors_total{method="List"} 1
+`), "scopes_storer_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAccessReportsAreNotDecisions(t *testing.T) {
+	ctx := context.Background()
+	m, reg := newMetrics(t)
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	for _, scope := range []scopes.Scope{
+		{ID: "https://scopes.impractical.co/allowed", UserPolicy: scopes.PolicyAllowAll, ClientPolicy: scopes.PolicyAllowAll},
+		{ID: "https://scopes.impractical.co/denied", UserPolicy: scopes.PolicyDenyAll, ClientPolicy: scopes.PolicyDenyAll},
+	} {
+		err = backend.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Error creating scope %q: %s", scope.ID, err)
+		}
+	}
+
+	// an access report checks every Scope it scans, but none of those
+	// checks are decisions about whether to let anyone use a Scope
+	report, err := scopes.UsableScopes(scopes.WithDecisionRecorder(ctx, m), backend, scopes.AccessQuery{UserID: "user", ClientID: "client"}, "", 10)
+	if err != nil {
+		t.Fatalf("Error generating access report: %s", err)
+	}
+	if len(report.Scopes) != 1 {
+		t.Errorf("Expected 1 usable scope, got %+v", report.Scopes)
+	}
+	err = testutil.GatherAndCompare(reg, strings.NewReader(""), "scopes_access_decisions_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"lockbox.dev/scopes"
+)
+
+// storer is a scopes.Storer that records the latency and errors of every call
+// to the scopes.Storer it wraps.
+type storer struct {
+	storer  scopes.Storer
+	metrics *Metrics
+}
+
+// InstrumentStorer returns a scopes.Storer that records how long each call to
+// `s` takes and whether it fails, by method. scopes.ErrScopeNotFound and
+// scopes.ErrScopeAlreadyExists are expected outcomes, not failures, and
+// aren't counted as errors.
+//
+// The returned Storer implements every optional interface in the scopes
+// package. When `s` doesn't implement one, its methods return the matching
+// sentinel error, like scopes.ErrWatchUnsupported, without recording a
+// call, except CheckHealth, which considers `s` healthy. Storers passed to
+// WithTx callbacks are instrumented, too.
+func (m *Metrics) InstrumentStorer(s scopes.Storer) scopes.Storer { //nolint:ireturn // callers only need a scopes.Storer
+	return &storer{storer: s, metrics: m}
+}
+
+// observe records a call to `method` that started at `start` and returned
+// `err`.
+func (s *storer) observe(method string, start time.Time, err error) {
+	s.metrics.storerDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, scopes.ErrScopeNotFound) && !errors.Is(err, scopes.ErrScopeAlreadyExists) {
+		s.metrics.storerErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *storer) Create(ctx context.Context, scope scopes.Scope) error {
+	start := time.Now()
+	err := s.storer.Create(ctx, scope)
+	s.observe("Create", start, err)
+	return err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.GetMulti(ctx, ids)
+	s.observe("GetMulti", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.ListDefault(ctx)
+	s.observe("ListDefault", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) List(ctx context.Context, after string, limit int) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.List(ctx, after, limit)
+	s.observe("List", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ListByUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.ListByUserException(ctx, userID)
+	s.observe("ListByUserException", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ListByClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.ListByClientException(ctx, clientID)
+	s.observe("ListByClientException", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) RemoveUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.RemoveUserException(ctx, userID)
+	s.observe("RemoveUserException", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) RemoveClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := s.storer.RemoveClientException(ctx, clientID)
+	s.observe("RemoveClientException", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) Update(ctx context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	start := time.Now()
+	result, err := s.storer.Update(ctx, id, change)
+	s.observe("Update", start, err)
+	return result, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) Delete(ctx context.Context, id string) (scopes.Scope, error) {
+	start := time.Now()
+	result, err := s.storer.Delete(ctx, id)
+	s.observe("Delete", start, err)
+	return result, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) Put(ctx context.Context, scope scopes.Scope) (bool, error) {
+	start := time.Now()
+	created, err := s.storer.Put(ctx, scope)
+	s.observe("Put", start, err)
+	return created, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// ApplyBatch applies `ops` to the wrapped Storer using scopes.ApplyBatch,
+// recording the batch as a single call. Batches the wrapped Storer can't
+// apply aren't recorded.
+func (s *storer) ApplyBatch(ctx context.Context, ops []scopes.Operation) ([]scopes.Scope, error) {
+	start := time.Now()
+	results, err := scopes.ApplyBatch(ctx, s.storer, ops)
+	if errors.Is(err, scopes.ErrBatchUnsupported) {
+		return nil, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.observe("ApplyBatch", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// CheckHealth checks the health of the wrapped Storer, if it implements
+// scopes.HealthChecker. Otherwise, the Storer is always considered healthy.
+func (s *storer) CheckHealth(ctx context.Context) error {
+	checker, ok := s.storer.(scopes.HealthChecker)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := checker.CheckHealth(ctx)
+	s.observe("CheckHealth", start, err)
+	return err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// Watch records how long it takes to start watching, not how long the
+// returned channel stays open.
+func (s *storer) Watch(ctx context.Context, fromRevision uint64) (<-chan scopes.Event, error) {
+	watcher, ok := s.storer.(scopes.Watcher)
+	if !ok {
+		return nil, scopes.ErrWatchUnsupported
+	}
+	start := time.Now()
+	events, err := watcher.Watch(ctx, fromRevision)
+	s.observe("Watch", start, err)
+	return events, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) CurrentRevision(ctx context.Context) (uint64, error) {
+	watcher, ok := s.storer.(scopes.Watcher)
+	if !ok {
+		return 0, scopes.ErrWatchUnsupported
+	}
+	start := time.Now()
+	revision, err := watcher.CurrentRevision(ctx)
+	s.observe("CurrentRevision", start, err)
+	return revision, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// WithTx records the whole transaction as a single call, and passes `fn` an
+// instrumented Storer, so the calls made in the transaction are recorded
+// too.
+func (s *storer) WithTx(ctx context.Context, fn func(ctx context.Context, tx scopes.Storer) error) error {
+	transactor, ok := s.storer.(scopes.Transactor)
+	if !ok {
+		return scopes.ErrTxUnsupported
+	}
+	start := time.Now()
+	err := transactor.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		return fn(ctx, s.metrics.InstrumentStorer(tx))
+	})
+	s.observe("WithTx", start, err)
+	return err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) UserCanUse(ctx context.Context, scopeID, userID string) (bool, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return false, scopes.ErrAccessCheckUnsupported
+	}
+	start := time.Now()
+	allowed, err := checker.UserCanUse(ctx, scopeID, userID)
+	s.observe("UserCanUse", start, err)
+	return allowed, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ClientCanUse(ctx context.Context, scopeID, clientID string) (bool, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return false, scopes.ErrAccessCheckUnsupported
+	}
+	start := time.Now()
+	allowed, err := checker.ClientCanUse(ctx, scopeID, clientID)
+	s.observe("ClientCanUse", start, err)
+	return allowed, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ListUsable(ctx context.Context, query scopes.AccessQuery, after string, limit int) ([]scopes.Scope, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return nil, scopes.ErrAccessCheckUnsupported
+	}
+	start := time.Now()
+	results, err := checker.ListUsable(ctx, query, after, limit)
+	s.observe("ListUsable", start, err)
+	return results, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+func (s *storer) ListAuditEntries(ctx context.Context) ([]scopes.AuditEntry, error) {
+	auditor, ok := s.storer.(scopes.AuditLister)
+	if !ok {
+		return nil, scopes.ErrAuditUnsupported
+	}
+	start := time.Now()
+	entries, err := auditor.ListAuditEntries(ctx)
+	s.observe("ListAuditEntries", start, err)
+	return entries, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
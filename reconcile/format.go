@@ -0,0 +1,100 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+
+	"lockbox.dev/scopes"
+)
+
+// fieldChanges describes the fields that differ between `from` and `to`. A
+// nil `from` describes every field of `to` being set, and a nil `to`
+// describes every field of `from` being removed.
+func fieldChanges(from, to *scopes.Scope) []FieldChange {
+	var empty scopes.Scope
+	before, after := from, to
+	if before == nil {
+		before = &empty
+	}
+	if after == nil {
+		after = &empty
+	}
+	fields := []struct {
+		name     string
+		from, to interface{}
+		changed  bool
+	}{
+		{"userPolicy", before.UserPolicy, after.UserPolicy, before.UserPolicy != after.UserPolicy},
+		{"userExceptions", before.UserExceptions, after.UserExceptions, !sameExceptions(before.UserExceptions, after.UserExceptions)},
+		{"clientPolicy", before.ClientPolicy, after.ClientPolicy, before.ClientPolicy != after.ClientPolicy},
+		{"clientExceptions", before.ClientExceptions, after.ClientExceptions, !sameExceptions(before.ClientExceptions, after.ClientExceptions)},
+		{"isDefault", before.IsDefault, after.IsDefault, before.IsDefault != after.IsDefault},
+	}
+	var changes []FieldChange
+	for _, field := range fields {
+		if !field.changed {
+			continue
+		}
+		change := FieldChange{Field: field.name}
+		if from != nil {
+			change.From = field.from
+		}
+		if to != nil {
+			change.To = field.to
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func formatValue(value interface{}) string {
+	if exceptions, ok := value.([]string); ok {
+		return "[" + strings.Join(exceptions, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+func writeStep(b *strings.Builder, prefix string, step Step) {
+	fmt.Fprintf(b, "%s %s %s\n", prefix, step.Action, step.ID)
+	for _, field := range step.Fields {
+		switch {
+		case field.From == nil:
+			fmt.Fprintf(b, "    %s: %s\n", field.Field, formatValue(field.To))
+		case field.To == nil:
+			fmt.Fprintf(b, "    %s: %s\n", field.Field, formatValue(field.From))
+		default:
+			fmt.Fprintf(b, "    %s: %s -> %s\n", field.Field, formatValue(field.From), formatValue(field.To))
+		}
+	}
+}
+
+// String returns a human-readable description of the Plan, listing each
+// Step and the fields it changes, followed by a summary.
+func (p Plan) String() string {
+	var b strings.Builder
+	counts := map[Action]int{}
+	for _, step := range p.Steps {
+		counts[step.Action]++
+		switch step.Action {
+		case ActionCreate:
+			writeStep(&b, "+", step)
+		case ActionUpdate:
+			writeStep(&b, "~", step)
+		case ActionDelete:
+			writeStep(&b, "-", step)
+		}
+	}
+	for _, step := range p.Protected {
+		fmt.Fprintf(&b, "! not deleting %s: scope is in use\n", step.ID)
+	}
+	if p.IsEmpty() && len(p.Protected) < 1 {
+		b.WriteString("No changes; scopes are up to date.\n")
+		return b.String()
+	}
+	format := "Plan: %d to create, %d to update, %d to delete, %d protected.\n"
+	if p.Applied {
+		format = "Applied: %d created, %d updated, %d deleted, %d protected.\n"
+	}
+	fmt.Fprintf(&b, format, counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], len(p.Protected))
+	return b.String()
+}
@@ -0,0 +1,280 @@
+// Package reconcile brings the Scopes in a Storer in line with a desired set
+// of Scopes, like those declared in a document loaded with the file Storer,
+// by planning which Scopes need to be created, changed, or deleted, and
+// optionally applying that plan.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// ActionCreate is the Action for Steps that create a Scope.
+	ActionCreate Action = "create"
+	// ActionUpdate is the Action for Steps that change an existing Scope.
+	ActionUpdate Action = "update"
+	// ActionDelete is the Action for Steps that delete a Scope.
+	ActionDelete Action = "delete"
+
+	// listPageSize is how many Scopes are requested at a time when listing
+	// every Scope in a Storer.
+	listPageSize = 100
+)
+
+// Action describes what a Step does.
+type Action string
+
+// Step is a single change needed to reconcile a Storer with the desired
+// Scopes. Scope is the desired Scope for creates and the current Scope for
+// updates and deletes; Change is only set for updates. Fields describes each
+// field the Step changes.
+type Step struct {
+	Action Action        `json:"action"`
+	ID     string        `json:"id"`
+	Scope  scopes.Scope  `json:"-"`
+	Change scopes.Change `json:"-"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange describes the change to a single field of a Scope. From is
+// omitted for creates, To is omitted for deletes.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// Plan is the set of Steps needed to reconcile a Storer with the desired
+// Scopes. Protected holds the deletes that were withheld because the Scopes
+// are in use. Applied is true once the Steps have been applied.
+type Plan struct {
+	Steps     []Step `json:"steps"`
+	Protected []Step `json:"protected,omitempty"`
+	Applied   bool   `json:"applied"`
+}
+
+// IsEmpty returns true if the Plan has no Steps to apply.
+func (p Plan) IsEmpty() bool {
+	return len(p.Steps) < 1
+}
+
+// Options control how a Storer is reconciled.
+type Options struct {
+	// DryRun, if true, plans the Steps without applying them.
+	DryRun bool
+
+	// Protect, if true, keeps Scopes that are in use from being deleted.
+	// Their deletes are listed in the Plan's Protected Steps instead.
+	Protect bool
+
+	// InUse reports whether a Scope is in use. If not set, a Scope is
+	// considered in use if it's a default Scope or lists any user or
+	// client exceptions.
+	InUse func(ctx context.Context, scope scopes.Scope) (bool, error)
+}
+
+func defaultInUse(_ context.Context, scope scopes.Scope) (bool, error) {
+	return scope.IsDefault || len(scope.UserExceptions) > 0 || len(scope.ClientExceptions) > 0, nil
+}
+
+// ListAll returns every Scope in `storer`, sorted by ID.
+func ListAll(ctx context.Context, storer scopes.Storer) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	var after string
+	for {
+		page, err := storer.List(ctx, after, listPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("error listing scopes after %q: %w", after, err)
+		}
+		if len(page) < 1 {
+			break
+		}
+		results = append(results, page...)
+		after = page[len(page)-1].ID
+	}
+	return results, nil
+}
+
+// Diff returns the Steps that turn the Scopes in `current` into the Scopes
+// in `desired`: creates for Scopes only in `desired`, updates for Scopes
+// that differ, and deletes for Scopes only in `current`. Exceptions are
+// compared without regard to their order. The Steps are sorted by ID.
+func Diff(current, desired []scopes.Scope) []Step {
+	existing := make(map[string]scopes.Scope, len(current))
+	for _, scope := range current {
+		existing[scope.ID] = scope
+	}
+	wanted := make(map[string]struct{}, len(desired))
+
+	var steps []Step
+	for _, scope := range desired {
+		wanted[scope.ID] = struct{}{}
+		prev, ok := existing[scope.ID]
+		if !ok {
+			steps = append(steps, Step{
+				Action: ActionCreate,
+				ID:     scope.ID,
+				Scope:  scope,
+				Fields: fieldChanges(nil, &scope),
+			})
+			continue
+		}
+		change := changeBetween(prev, scope)
+		if change.IsEmpty() {
+			continue
+		}
+		next := scopes.Apply(change, prev)
+		steps = append(steps, Step{
+			Action: ActionUpdate,
+			ID:     scope.ID,
+			Scope:  prev,
+			Change: change,
+			Fields: fieldChanges(&prev, &next),
+		})
+	}
+	for _, scope := range current {
+		if _, ok := wanted[scope.ID]; ok {
+			continue
+		}
+		scope := scope
+		steps = append(steps, Step{
+			Action: ActionDelete,
+			ID:     scope.ID,
+			Scope:  scope,
+			Fields: fieldChanges(&scope, nil),
+		})
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].ID < steps[j].ID
+	})
+	return steps
+}
+
+// changeBetween returns the Change that turns `from` into `to`, setting only
+// the fields that differ.
+func changeBetween(from, to scopes.Scope) scopes.Change {
+	var change scopes.Change
+	if from.UserPolicy != to.UserPolicy {
+		userPolicy := to.UserPolicy
+		change.UserPolicy = &userPolicy
+	}
+	if !sameExceptions(from.UserExceptions, to.UserExceptions) {
+		userExceptions := append([]string{}, to.UserExceptions...)
+		change.UserExceptions = &userExceptions
+	}
+	if from.ClientPolicy != to.ClientPolicy {
+		clientPolicy := to.ClientPolicy
+		change.ClientPolicy = &clientPolicy
+	}
+	if !sameExceptions(from.ClientExceptions, to.ClientExceptions) {
+		clientExceptions := append([]string{}, to.ClientExceptions...)
+		change.ClientExceptions = &clientExceptions
+	}
+	if from.IsDefault != to.IsDefault {
+		isDefault := to.IsDefault
+		change.IsDefault = &isDefault
+	}
+	return change
+}
+
+// sameExceptions returns true if `a` and `b` hold the same IDs, in any order.
+func sameExceptions(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for pos := range sortedA {
+		if sortedA[pos] != sortedB[pos] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewPlan compares the Scopes in `current` against the Scopes in `desired`
+// and returns the Plan for reconciling them, without applying it. If
+// opts.Protect is set, deletes of Scopes that are in use are moved to the
+// Plan's Protected Steps.
+func NewPlan(ctx context.Context, current, desired scopes.Storer, opts Options) (Plan, error) {
+	have, err := ListAll(ctx, current)
+	if err != nil {
+		return Plan{}, fmt.Errorf("error listing current scopes: %w", err)
+	}
+	want, err := ListAll(ctx, desired)
+	if err != nil {
+		return Plan{}, fmt.Errorf("error listing desired scopes: %w", err)
+	}
+	inUse := opts.InUse
+	if inUse == nil {
+		inUse = defaultInUse
+	}
+	plan := Plan{Steps: []Step{}}
+	for _, step := range Diff(have, want) {
+		if step.Action == ActionDelete && opts.Protect {
+			used, err := inUse(ctx, step.Scope)
+			if err != nil {
+				return Plan{}, fmt.Errorf("error checking if %q is in use: %w", step.ID, err)
+			}
+			if used {
+				plan.Protected = append(plan.Protected, step)
+				continue
+			}
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// Apply applies the Steps in `plan` to `storer` as a single batch, so either
+// all of them take effect or none do, and returns the Plan marked as
+// applied. `storer` must support scopes.ApplyBatch. If any of the Scopes
+// changed after the Plan was made, a scopes.BatchError is returned, wrapping
+// scopes.ErrScopeChanged if a Scope to update or delete no longer matches
+// the Step's Scope; its Index matches the failed Step.
+func Apply(ctx context.Context, storer scopes.Storer, plan Plan) (Plan, error) {
+	if plan.IsEmpty() {
+		plan.Applied = true
+		return plan, nil
+	}
+	ops := make([]scopes.Operation, 0, len(plan.Steps))
+	for _, step := range plan.Steps {
+		step := step
+		switch step.Action {
+		case ActionCreate:
+			ops = append(ops, scopes.Operation{Type: scopes.OperationCreate, Scope: step.Scope})
+		case ActionUpdate:
+			ops = append(ops, scopes.Operation{Type: scopes.OperationUpdate, ID: step.ID, Change: step.Change, Expected: &step.Scope})
+		case ActionDelete:
+			ops = append(ops, scopes.Operation{Type: scopes.OperationDelete, ID: step.ID, Expected: &step.Scope})
+		default:
+			return plan, fmt.Errorf("%w %q", scopes.ErrUnknownOperation, step.Action)
+		}
+	}
+	_, err := scopes.ApplyBatch(ctx, storer, ops)
+	if err != nil {
+		return plan, fmt.Errorf("error applying plan: %w", err)
+	}
+	plan.Applied = true
+	return plan, nil
+}
+
+// Reconcile plans the changes needed to make the Scopes in `current` match
+// the Scopes in `desired` and, unless opts.DryRun is set, applies them to
+// `current`. The Plan is returned either way.
+func Reconcile(ctx context.Context, current, desired scopes.Storer, opts Options) (Plan, error) {
+	plan, err := NewPlan(ctx, current, desired, opts)
+	if err != nil {
+		return Plan{}, err
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+	return Apply(ctx, current, plan)
+}
@@ -0,0 +1,256 @@
+package reconcile_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/reconcile"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+func newStorer(t *testing.T, ctx context.Context, contents ...scopes.Scope) *memory.Storer {
+	t.Helper()
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	for _, scope := range contents {
+		err = storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Error creating scope %q: %s", scope.ID, err)
+		}
+	}
+	return storer
+}
+
+var (
+	unchanged = scopes.Scope{
+		ID:             "https://scopes.impractical.co/unchanged",
+		UserPolicy:     scopes.PolicyDefaultDeny,
+		UserExceptions: []string{"user-1", "user-2"},
+		ClientPolicy:   scopes.PolicyAllowAll,
+	}
+	changed = scopes.Scope{
+		ID:           "https://scopes.impractical.co/changed",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	removed = scopes.Scope{
+		ID:           "https://scopes.impractical.co/removed",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	used = scopes.Scope{
+		ID:           "https://scopes.impractical.co/used",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+		IsDefault:    true,
+	}
+	created = scopes.Scope{
+		ID:           "https://scopes.impractical.co/created",
+		UserPolicy:   scopes.PolicyDenyAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+		IsDefault:    true,
+	}
+)
+
+func desiredState(t *testing.T, ctx context.Context) *memory.Storer {
+	t.Helper()
+	reordered := unchanged
+	reordered.UserExceptions = []string{"user-2", "user-1"}
+	updated := changed
+	updated.ClientPolicy = scopes.PolicyDefaultAllow
+	updated.ClientExceptions = []string{"client-1"}
+	return newStorer(t, ctx, reordered, updated, created)
+}
+
+func TestPlanAndApply(t *testing.T) {
+	ctx := context.Background()
+	current := newStorer(t, ctx, unchanged, changed, removed, used)
+	desired := desiredState(t, ctx)
+
+	plan, err := reconcile.Reconcile(ctx, current, desired, reconcile.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+	if plan.Applied {
+		t.Error("Expected dry run not to be applied")
+	}
+	var actions []string
+	for _, step := range plan.Steps {
+		actions = append(actions, string(step.Action)+" "+step.ID)
+	}
+	if diff := cmp.Diff([]string{
+		"update " + changed.ID,
+		"create " + created.ID,
+		"delete " + removed.ID,
+		"delete " + used.ID,
+	}, actions); diff != "" {
+		t.Errorf("Unexpected steps (-wanted, +got): %s", diff)
+	}
+	expectedText := `~ update https://scopes.impractical.co/changed
+    clientPolicy: DEFAULT_DENY -> DEFAULT_ALLOW
+    clientExceptions: [] -> [client-1]
++ create https://scopes.impractical.co/created
+    userPolicy: DENY_ALL
+    clientPolicy: ALLOW_ALL
+    isDefault: true
+- delete https://scopes.impractical.co/removed
+    userPolicy: ALLOW_ALL
+    clientPolicy: ALLOW_ALL
+- delete https://scopes.impractical.co/used
+    userPolicy: ALLOW_ALL
+    clientPolicy: ALLOW_ALL
+    isDefault: true
+Plan: 1 to create, 1 to update, 2 to delete, 0 protected.
+`
+	if diff := cmp.Diff(expectedText, plan.String()); diff != "" {
+		t.Errorf("Unexpected plan text (-wanted, +got): %s", diff)
+	}
+
+	// a dry run leaves the storer alone
+	before, err := reconcile.ListAll(ctx, current)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	if diff := cmp.Diff([]scopes.Scope{changed, removed, unchanged, used}, before, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff after dry run (-wanted, +got): %s", diff)
+	}
+
+	plan, err = reconcile.Apply(ctx, current, plan)
+	if err != nil {
+		t.Fatalf("Error applying plan: %s", err)
+	}
+	if !plan.Applied {
+		t.Error("Expected plan to be applied")
+	}
+	after, err := reconcile.ListAll(ctx, current)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	want, err := reconcile.ListAll(ctx, desired)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	// exceptions are compared without regard to their order
+	sortExceptions := cmpopts.SortSlices(func(a, b string) bool { return a < b })
+	if diff := cmp.Diff(want, after, cmpopts.EquateEmpty(), sortExceptions); diff != "" {
+		t.Errorf("Unexpected diff after applying (-wanted, +got): %s", diff)
+	}
+
+	// once applied, there's nothing left to do
+	plan, err = reconcile.NewPlan(ctx, current, desired, reconcile.Options{})
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("Expected empty plan, got %s", plan)
+	}
+}
+
+func TestApplyAfterChange(t *testing.T) {
+	ctx := context.Background()
+	current := newStorer(t, ctx, unchanged, changed, removed, used)
+	desired := desiredState(t, ctx)
+
+	plan, err := reconcile.NewPlan(ctx, current, desired, reconcile.Options{})
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	// a change made after planning isn't overwritten by the plan
+	deny := scopes.PolicyDenyAll
+	edited, err := current.Update(ctx, changed.ID, scopes.Change{UserPolicy: &deny})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+	_, err = reconcile.Apply(ctx, current, plan)
+	var batchErr scopes.BatchError
+	if !errors.As(err, &batchErr) || !errors.Is(err, scopes.ErrScopeChanged) {
+		t.Fatalf("Expected BatchError wrapping %v, got %v", scopes.ErrScopeChanged, err)
+	}
+	if plan.Steps[batchErr.Index].ID != changed.ID {
+		t.Errorf("Expected the step for %q to fail, got %+v", changed.ID, plan.Steps[batchErr.Index])
+	}
+
+	after, err := reconcile.ListAll(ctx, current)
+	if err != nil {
+		t.Fatalf("Error listing scopes: %s", err)
+	}
+	if diff := cmp.Diff([]scopes.Scope{edited, removed, unchanged, used}, after, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff after failed apply (-wanted, +got): %s", diff)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	ctx := context.Background()
+	current := newStorer(t, ctx, unchanged, changed, removed, used)
+	desired := desiredState(t, ctx)
+
+	plan, err := reconcile.Reconcile(ctx, current, desired, reconcile.Options{Protect: true})
+	if err != nil {
+		t.Fatalf("Error reconciling: %s", err)
+	}
+	if len(plan.Protected) != 1 || plan.Protected[0].ID != used.ID {
+		t.Errorf("Expected %q to be protected, got %+v", used.ID, plan.Protected)
+	}
+	res, err := current.GetMulti(ctx, []string{used.ID, removed.ID})
+	if err != nil {
+		t.Fatalf("Error retrieving scopes: %s", err)
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{used.ID: used}, res, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Expected only the unused scope to be deleted (-wanted, +got): %s", diff)
+	}
+
+	// a custom InUse check overrides the default
+	current = newStorer(t, ctx, unchanged, removed, used)
+	plan, err = reconcile.NewPlan(ctx, current, desired, reconcile.Options{
+		Protect: true,
+		InUse: func(_ context.Context, scope scopes.Scope) (bool, error) {
+			return scope.ID == removed.ID, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+	if len(plan.Protected) != 1 || plan.Protected[0].ID != removed.ID {
+		t.Errorf("Expected %q to be protected, got %+v", removed.ID, plan.Protected)
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	ctx := context.Background()
+	current := newStorer(t, ctx, unchanged, changed, used)
+	desired := desiredState(t, ctx)
+
+	plan, err := reconcile.NewPlan(ctx, current, desired, reconcile.Options{Protect: true})
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+	out, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("Error encoding plan: %s", err)
+	}
+	expected := `{"steps":[` +
+		`{"action":"update","id":"https://scopes.impractical.co/changed","fields":[` +
+		`{"field":"clientPolicy","from":"DEFAULT_DENY","to":"DEFAULT_ALLOW"},` +
+		`{"field":"clientExceptions","from":null,"to":["client-1"]}]},` +
+		`{"action":"create","id":"https://scopes.impractical.co/created","fields":[` +
+		`{"field":"userPolicy","to":"DENY_ALL"},` +
+		`{"field":"clientPolicy","to":"ALLOW_ALL"},` +
+		`{"field":"isDefault","to":true}]}],` +
+		`"protected":[{"action":"delete","id":"https://scopes.impractical.co/used","fields":[` +
+		`{"field":"userPolicy","from":"ALLOW_ALL"},` +
+		`{"field":"clientPolicy","from":"ALLOW_ALL"},` +
+		`{"field":"isDefault","from":true}]}],` +
+		`"applied":false}`
+	if diff := cmp.Diff(expected, string(out)); diff != "" {
+		t.Errorf("Unexpected plan JSON (-wanted, +got): %s", diff)
+	}
+}
@@ -3,6 +3,7 @@ package scopes
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
 
 	yall "yall.in"
@@ -22,6 +23,42 @@ const (
 var (
 	// ErrScopeAlreadyExists is returned when attempting to create a Scope that already exists.
 	ErrScopeAlreadyExists = errors.New("scope already exists")
+	// ErrScopeNotFound is returned when attempting to update or delete a
+	// Scope that doesn't exist.
+	ErrScopeNotFound = errors.New("scope not found")
+	// ErrRevisionCompacted is returned when attempting to watch for changes
+	// from a revision that is no longer retained by the Storer.
+	ErrRevisionCompacted = errors.New("revision has been compacted")
+	// ErrWatchUnsupported is returned when attempting to watch for changes
+	// using a Storer that can't deliver them, like a decorator wrapping a
+	// Storer that doesn't implement Watcher.
+	ErrWatchUnsupported = errors.New("storer can't watch for changes")
+	// ErrTxUnsupported is returned when attempting to start a transaction
+	// using a Storer that can't run one, like a decorator wrapping a Storer
+	// that doesn't implement Transactor.
+	ErrTxUnsupported = errors.New("storer can't run transactions")
+	// ErrAccessCheckUnsupported is returned when attempting to check access
+	// using a Storer that can't check it itself, like a decorator wrapping a
+	// Storer that doesn't implement AccessChecker.
+	ErrAccessCheckUnsupported = errors.New("storer can't check access")
+	// ErrAuditUnsupported is returned when attempting to list audit entries
+	// using a Storer that doesn't record them, like a decorator wrapping a
+	// Storer that doesn't implement AuditLister.
+	ErrAuditUnsupported = errors.New("storer doesn't record audit entries")
+	// ErrPendingMigrations is returned when a Storer's database is missing
+	// migrations it needs.
+	ErrPendingMigrations = errors.New("database has pending migrations")
+	// ErrEmptyID is returned when a Scope has no ID.
+	ErrEmptyID = errors.New("scope has no ID")
+	// ErrReservedID is returned when a Scope's ID is reserved by the API;
+	// see IsReservedID.
+	ErrReservedID = errors.New("scope ID is reserved")
+	// ErrEmptyPrincipalID is returned when a Scope lists an empty user or
+	// client ID as an exception.
+	ErrEmptyPrincipalID = errors.New("exception has no ID")
+	// ErrInvalidPolicy is returned when a Scope's user or client policy
+	// isn't valid.
+	ErrInvalidPolicy = errors.New("invalid policy")
 )
 
 // Scope defines a scope of access to user data that users can grant.
@@ -45,6 +82,76 @@ func IsValidPolicy(p string) bool {
 	return false
 }
 
+// reservedIDs are the IDs the API uses as the paths of its own endpoints.
+var reservedIDs = map[string]bool{
+	"access":   true,
+	"batch":    true,
+	"events":   true,
+	"export":   true,
+	"import":   true,
+	"webhooks": true,
+}
+
+// IsReservedID returns whether `id` is reserved by the API. The API serves
+// its own endpoints at those paths, next to the paths of Scopes, so a Scope
+// with a reserved ID couldn't be retrieved, updated, or deleted over HTTP.
+func IsReservedID(id string) bool {
+	return reservedIDs[id]
+}
+
+// Validate checks that `scope` has an ID that isn't reserved, valid
+// policies, and no empty exceptions. If it doesn't, Validate returns the name
+// of the first invalid field, as it's spelled in the API and in files, and
+// the problem with it.
+func Validate(scope Scope) (string, error) {
+	switch {
+	case scope.ID == "":
+		return "id", ErrEmptyID
+	case IsReservedID(scope.ID):
+		return "id", fmt.Errorf("%w: %q", ErrReservedID, scope.ID)
+	case !IsValidPolicy(scope.UserPolicy):
+		return "userPolicy", fmt.Errorf("%w %q", ErrInvalidPolicy, scope.UserPolicy)
+	case !IsValidPolicy(scope.ClientPolicy):
+		return "clientPolicy", fmt.Errorf("%w %q", ErrInvalidPolicy, scope.ClientPolicy)
+	case hasEmptyID(scope.UserExceptions):
+		return "userExceptions", fmt.Errorf("%w: user exceptions", ErrEmptyPrincipalID)
+	case hasEmptyID(scope.ClientExceptions):
+		return "clientExceptions", fmt.Errorf("%w: client exceptions", ErrEmptyPrincipalID)
+	}
+	return "", nil
+}
+
+// ValidateIDs returns ErrEmptyID if `scope` has no ID, or an error wrapping
+// ErrEmptyPrincipalID if it lists an empty user or client ID as an exception.
+// Not every Storer can store empty IDs, so every Storer rejects them.
+func ValidateIDs(scope Scope) error {
+	if scope.ID == "" {
+		return ErrEmptyID
+	}
+	return ValidateChangeIDs(Change{UserExceptions: &scope.UserExceptions, ClientExceptions: &scope.ClientExceptions})
+}
+
+// ValidateChangeIDs returns an error wrapping ErrEmptyPrincipalID if `change`
+// sets exceptions that list an empty user or client ID.
+func ValidateChangeIDs(change Change) error {
+	if change.UserExceptions != nil && hasEmptyID(*change.UserExceptions) {
+		return fmt.Errorf("%w: user exceptions", ErrEmptyPrincipalID)
+	}
+	if change.ClientExceptions != nil && hasEmptyID(*change.ClientExceptions) {
+		return fmt.Errorf("%w: client exceptions", ErrEmptyPrincipalID)
+	}
+	return nil
+}
+
+func hasEmptyID(ids []string) bool {
+	for _, id := range ids {
+		if id == "" {
+			return true
+		}
+	}
+	return false
+}
+
 // Change represents a change to a Scope.
 type Change struct {
 	UserPolicy       *string
@@ -123,8 +230,16 @@ func FilterByClientID(ctx context.Context, scopes []Scope, clientID string) []Sc
 	return results
 }
 
-// ClientCanUseScope returns true if the client specified by `client` can use `scope`.
+// ClientCanUseScope returns true if the client specified by `client` can use
+// `scope`. The Decision is recorded by the DecisionRecorder in `ctx`, if
+// there is one.
 func ClientCanUseScope(ctx context.Context, scope Scope, client string) bool {
+	allowed := clientCanUseScope(ctx, scope, client)
+	recordDecision(ctx, Decision{Scope: scope.ID, Principal: PrincipalClient, Policy: scope.ClientPolicy, Allowed: allowed})
+	return allowed
+}
+
+func clientCanUseScope(ctx context.Context, scope Scope, client string) bool {
 	switch scope.ClientPolicy {
 	case PolicyDenyAll:
 		return false
@@ -163,8 +278,15 @@ func FilterByUserID(ctx context.Context, scopes []Scope, userID string) []Scope
 }
 
 // UserCanUseScope returns true if the user specified by `userID` can use
-// `scope`.
+// `scope`. The Decision is recorded by the DecisionRecorder in `ctx`, if
+// there is one.
 func UserCanUseScope(ctx context.Context, scope Scope, userID string) bool {
+	allowed := userCanUseScope(ctx, scope, userID)
+	recordDecision(ctx, Decision{Scope: scope.ID, Principal: PrincipalUser, Policy: scope.UserPolicy, Allowed: allowed})
+	return allowed
+}
+
+func userCanUseScope(ctx context.Context, scope Scope, userID string) bool {
 	switch scope.UserPolicy {
 	case PolicyDenyAll:
 		return false
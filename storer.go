@@ -4,12 +4,122 @@ import (
 	"context"
 )
 
+const (
+	// EventCreated is the EventType for Events describing the creation of
+	// a Scope.
+	EventCreated EventType = "created"
+	// EventUpdated is the EventType for Events describing a change to a
+	// Scope.
+	EventUpdated EventType = "updated"
+	// EventDeleted is the EventType for Events describing the deletion of
+	// a Scope.
+	EventDeleted EventType = "deleted"
+)
+
 // Storer is an interface for storing and retrieving Scopes and the metadata
 // surrounding them.
+//
+// Create, Put, and Update return an error wrapping ErrEmptyID or
+// ErrEmptyPrincipalID, and store nothing, if they're asked to store a Scope
+// with an empty ID or an exception with an empty user or client ID.
 type Storer interface {
 	Create(ctx context.Context, scope Scope) error
 	GetMulti(ctx context.Context, ids []string) (map[string]Scope, error)
 	ListDefault(ctx context.Context) ([]Scope, error)
-	Update(ctx context.Context, id string, change Change) error
-	Delete(ctx context.Context, id string) error
+	// List returns up to `limit` Scopes, sorted lexicographically by
+	// their ID, starting with the first Scope whose ID sorts after
+	// `after`. Passing an empty `after` starts at the first Scope.
+	List(ctx context.Context, after string, limit int) ([]Scope, error)
+	ListByUserException(ctx context.Context, userID string) ([]Scope, error)
+	ListByClientException(ctx context.Context, clientID string) ([]Scope, error)
+
+	// RemoveUserException and RemoveClientException atomically remove
+	// a user or client from the exceptions of every Scope that lists
+	// them, recording a single AuditEntry, and return the Scopes that
+	// were changed, as they are after the change, sorted
+	// lexicographically by their ID.
+	RemoveUserException(ctx context.Context, userID string) ([]Scope, error)
+	RemoveClientException(ctx context.Context, clientID string) ([]Scope, error)
+
+	// Update applies `change` to the Scope specified by `id` and returns
+	// the Scope as it is after the change. Delete removes the Scope
+	// specified by `id` and returns the Scope as it was before it was
+	// deleted. Both return ErrScopeNotFound if the Scope doesn't exist.
+	Update(ctx context.Context, id string, change Change) (Scope, error)
+	Delete(ctx context.Context, id string) (Scope, error)
+
+	// Put stores `scope`, creating it if no Scope with its ID exists and
+	// replacing the existing Scope entirely if one does. It returns true
+	// if the Scope was created.
+	Put(ctx context.Context, scope Scope) (bool, error)
+}
+
+// EventType describes the kind of mutation an Event represents.
+type EventType string
+
+// Event represents a single mutation of a Scope. Revision is assigned by the
+// Storer, and increases with every mutation. Scope holds the Scope as it was
+// after the mutation, or as it was before it was deleted for EventDeleted
+// Events. Previous holds the Scope as it was before the mutation for
+// EventUpdated Events, and is nil otherwise.
+type Event struct {
+	Revision uint64
+	Type     EventType
+	Scope    Scope
+	Previous *Scope
+}
+
+// Watcher is an optional interface that Storers can implement to let callers
+// learn about changes to Scopes without polling.
+type Watcher interface {
+	// Watch returns a channel that receives every Event with a Revision
+	// greater than `fromRevision`, in Revision order, followed by new
+	// Events as they happen. The channel is closed when `ctx` is
+	// canceled or the Storer can no longer deliver Events; callers can
+	// resume by calling Watch again with the Revision of the last Event
+	// they received. If Events after `fromRevision` are no longer
+	// retained, ErrRevisionCompacted is returned.
+	Watch(ctx context.Context, fromRevision uint64) (<-chan Event, error)
+
+	// CurrentRevision returns the Revision of the most recent Event, so
+	// callers can Watch for only the Events that happen after they start
+	// watching.
+	CurrentRevision(ctx context.Context) (uint64, error)
+}
+
+// Transactor is an optional interface that Storers can implement to let
+// callers group several operations so they either all take effect or none
+// do.
+type Transactor interface {
+	// WithTx calls `fn` with a Storer whose operations all happen in a
+	// single transaction. If `fn` returns an error, the transaction is
+	// rolled back and that error is returned; otherwise, the transaction
+	// is committed. The Storer passed to `fn` must not be used after `fn`
+	// returns, and `fn` should not use the Storer WithTx was called on.
+	// Calling WithTx on the Storer passed to `fn` joins the existing
+	// transaction.
+	WithTx(ctx context.Context, fn func(ctx context.Context, tx Storer) error) error
+}
+
+// AccessChecker is an optional interface that Storers can implement to check
+// whether a user or client can use Scopes without retrieving all of the
+// Scopes' exceptions. UserCanUse and ClientCanUse follow the same rules as
+// UserCanUseScope and ClientCanUseScope, and return false if the Scope
+// doesn't exist. ListUsable returns up to `limit` of the Scopes the
+// principals described by `query` can use, sorted lexicographically by their
+// ID, starting after the Scope whose ID is `after`, and is used by
+// UsableScopes.
+type AccessChecker interface {
+	UserCanUse(ctx context.Context, scopeID, userID string) (bool, error)
+	ClientCanUse(ctx context.Context, scopeID, clientID string) (bool, error)
+	ListUsable(ctx context.Context, query AccessQuery, after string, limit int) ([]Scope, error)
+}
+
+// HealthChecker is an optional interface that Storers can implement to report
+// whether they're able to serve requests, for use in readiness checks.
+type HealthChecker interface {
+	// CheckHealth returns an error if the Storer can't currently serve
+	// requests, like when its database can't be reached, or is missing
+	// migrations, in which case the error wraps ErrPendingMigrations.
+	CheckHealth(ctx context.Context) error
 }
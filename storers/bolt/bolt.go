@@ -0,0 +1,506 @@
+// Package bolt provides an implementation of the scopes.Storer interface that
+// stores data in a bbolt file, for deployments without a database server.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"lockbox.dev/scopes"
+)
+
+var (
+	// scopesBucket holds every Scope, keyed by its ID. bbolt keeps keys
+	// sorted bytewise, so iterating over it returns Scopes sorted
+	// lexicographically by their ID.
+	scopesBucket = []byte("scopes")
+	// userExceptionsBucket holds a bucket for every user listed in a
+	// Scope's UserExceptions, keyed by the user's ID, holding the IDs of
+	// the Scopes that list them.
+	userExceptionsBucket = []byte("user_exceptions")
+	// clientExceptionsBucket holds a bucket for every client listed in a
+	// Scope's ClientExceptions, keyed by the client's ID, holding the IDs
+	// of the Scopes that list them.
+	clientExceptionsBucket = []byte("client_exceptions")
+	// auditBucket holds every AuditEntry, keyed by the order they were
+	// recorded in.
+	auditBucket = []byte("audit")
+)
+
+// Storer is an implementation of the Storer interface
+// that stores data in a bbolt database.
+type Storer struct {
+	db *bolt.DB
+
+	// tx is the transaction all operations happen in, for Storers
+	// passed to WithTx callbacks.
+	tx *bolt.Tx
+}
+
+// NewStorer returns a Storer instance that is backed by the specified
+// *bolt.DB, creating the buckets it needs if they don't exist yet. The
+// returned Storer instance is ready to be used as a Storer.
+func NewStorer(_ context.Context, db *bolt.DB) (*Storer, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{scopesBucket, userExceptionsBucket, clientExceptionsBucket, auditBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return fmt.Errorf("error creating %s bucket: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck // already wrapped in the callback
+	}
+	return &Storer{db: db}, nil
+}
+
+// view calls `fn` with the Storer's transaction if it has one, or a new
+// read-only transaction otherwise.
+func (s *Storer) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn) //nolint:wrapcheck // errors come from fn, and are already wrapped
+}
+
+// update calls `fn` with the Storer's transaction if it has one, or a new
+// read-write transaction otherwise, which is committed only if `fn` returns
+// nil.
+func (s *Storer) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn) //nolint:wrapcheck // errors come from fn, and are already wrapped
+}
+
+// WithTx calls `fn` with a Storer whose operations all happen in a single
+// bbolt read-write transaction, committing it only if `fn` returns nil. Only
+// one read-write transaction can be open at a time, so `fn` must not make
+// changes through the Storer WithTx was called on, or it will deadlock.
+func (s *Storer) WithTx(ctx context.Context, fn func(ctx context.Context, tx scopes.Storer) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if s.tx != nil {
+			return fn(ctx, s)
+		}
+		return fn(ctx, &Storer{db: s.db, tx: tx})
+	})
+}
+
+func getScope(tx *bolt.Tx, id string) (*scopes.Scope, error) {
+	val := tx.Bucket(scopesBucket).Get([]byte(id))
+	if val == nil {
+		return nil, nil
+	}
+	var scope scopes.Scope
+	err := json.Unmarshal(val, &scope)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding scope %s: %w", id, err)
+	}
+	return &scope, nil
+}
+
+// putScope stores `scope`, replacing `previous` if it's set, and keeps the
+// exception buckets in sync with it.
+func putScope(tx *bolt.Tx, scope scopes.Scope, previous *scopes.Scope) error {
+	val, err := json.Marshal(scope)
+	if err != nil {
+		return fmt.Errorf("error encoding scope %s: %w", scope.ID, err)
+	}
+	err = tx.Bucket(scopesBucket).Put([]byte(scope.ID), val)
+	if err != nil {
+		return fmt.Errorf("error writing scope %s: %w", scope.ID, err)
+	}
+	var prevUsers, prevClients []string
+	if previous != nil {
+		prevUsers, prevClients = previous.UserExceptions, previous.ClientExceptions
+	}
+	err = indexExceptions(tx.Bucket(userExceptionsBucket), scope.ID, prevUsers, scope.UserExceptions)
+	if err != nil {
+		return err
+	}
+	return indexExceptions(tx.Bucket(clientExceptionsBucket), scope.ID, prevClients, scope.ClientExceptions)
+}
+
+// indexExceptions updates `index` so the Scope specified by `scopeID` is
+// listed under each of the principals in `current`, and none of the
+// principals only in `previous`.
+func indexExceptions(index *bolt.Bucket, scopeID string, previous, current []string) error {
+	keep := make(map[string]struct{}, len(current))
+	for _, principal := range current {
+		keep[principal] = struct{}{}
+		principals, err := index.CreateBucketIfNotExists([]byte(principal))
+		if err != nil {
+			return fmt.Errorf("error creating exception bucket for %s: %w", principal, err)
+		}
+		err = principals.Put([]byte(scopeID), []byte{})
+		if err != nil {
+			return fmt.Errorf("error indexing exception %s: %w", principal, err)
+		}
+	}
+	for _, principal := range previous {
+		if _, ok := keep[principal]; ok {
+			continue
+		}
+		err := unindexException(index, scopeID, principal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexException removes the Scope specified by `scopeID` from the bucket
+// for `principal` in `index`, removing the bucket if it's left empty.
+func unindexException(index *bolt.Bucket, scopeID, principal string) error {
+	principals := index.Bucket([]byte(principal))
+	if principals == nil {
+		return nil
+	}
+	err := principals.Delete([]byte(scopeID))
+	if err != nil {
+		return fmt.Errorf("error unindexing exception %s: %w", principal, err)
+	}
+	if key, _ := principals.Cursor().First(); key != nil {
+		return nil
+	}
+	err = index.DeleteBucket([]byte(principal))
+	if err != nil {
+		return fmt.Errorf("error removing exception bucket for %s: %w", principal, err)
+	}
+	return nil
+}
+
+// Create inserts the passed Scope into the database,
+// returning an ErrScopeAlreadyExists error if a Scope
+// with the same ID already exists in the database.
+func (s *Storer) Create(_ context.Context, scope scopes.Scope) error {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(scopesBucket).Get([]byte(scope.ID)) != nil {
+			return scopes.ErrScopeAlreadyExists
+		}
+		return putScope(tx, scope, nil)
+	})
+}
+
+// Put inserts the passed Scope into the database, replacing any Scope with the
+// same ID, and returns true if no Scope with that ID existed.
+func (s *Storer) Put(_ context.Context, scope scopes.Scope) (bool, error) {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return false, err
+	}
+	var created bool
+	err := s.update(func(tx *bolt.Tx) error {
+		previous, err := getScope(tx, scope.ID)
+		if err != nil {
+			return err
+		}
+		created = previous == nil
+		return putScope(tx, scope, previous)
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// GetMulti retrieves the Scopes specified by the passed IDs
+// from the database, returning an empty map if no matching
+// Scopes are found. If a Scope is not found, no error will
+// be returned, it will just be omitted from the map.
+func (s *Storer) GetMulti(_ context.Context, ids []string) (map[string]scopes.Scope, error) {
+	results := map[string]scopes.Scope{}
+	err := s.view(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			scope, err := getScope(tx, id)
+			if err != nil {
+				return err
+			}
+			if scope == nil {
+				continue
+			}
+			results[id] = *scope
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Update applies the passed Change to the Scope that matches the specified ID
+// in the database and returns the updated Scope. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Update(_ context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	if err := scopes.ValidateChangeIDs(change); err != nil {
+		return scopes.Scope{}, err
+	}
+	var updated scopes.Scope
+	err := s.update(func(tx *bolt.Tx) error {
+		previous, err := getScope(tx, id)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			return scopes.ErrScopeNotFound
+		}
+		updated = scopes.Apply(change, *previous)
+		if change.IsEmpty() {
+			return nil
+		}
+		return putScope(tx, updated, previous)
+	})
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	return updated, nil
+}
+
+// Delete removes the Scope that matches the specified ID from the database and
+// returns the Scope as it was before it was deleted. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Delete(_ context.Context, id string) (scopes.Scope, error) {
+	var deleted *scopes.Scope
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = getScope(tx, id)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return scopes.ErrScopeNotFound
+		}
+		err = tx.Bucket(scopesBucket).Delete([]byte(id))
+		if err != nil {
+			return fmt.Errorf("error deleting scope %s: %w", id, err)
+		}
+		err = indexExceptions(tx.Bucket(userExceptionsBucket), id, deleted.UserExceptions, nil)
+		if err != nil {
+			return err
+		}
+		return indexExceptions(tx.Bucket(clientExceptionsBucket), id, deleted.ClientExceptions, nil)
+	})
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	return *deleted, nil
+}
+
+// list calls `include` with each Scope in the database in order, starting
+// with the first Scope whose ID sorts after `after`, and returns the Scopes
+// it returns true for, stopping once `limit` Scopes have been included if
+// `limit` is positive.
+func (s *Storer) list(after string, limit int, include func(scopes.Scope) bool) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	err := s.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(scopesBucket).Cursor()
+		key, val := cursor.First()
+		if after != "" {
+			key, val = cursor.Seek([]byte(after))
+			if key != nil && bytes.Equal(key, []byte(after)) {
+				key, val = cursor.Next()
+			}
+		}
+		for ; key != nil; key, val = cursor.Next() {
+			if limit > 0 && len(results) >= limit {
+				return nil
+			}
+			var scope scopes.Scope
+			err := json.Unmarshal(val, &scope)
+			if err != nil {
+				return fmt.Errorf("error decoding scope %s: %w", key, err)
+			}
+			if !include(scope) {
+				continue
+			}
+			results = append(results, scope)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ListDefault returns all the Scopes with IsDefault set to true.
+// sorted lexicographically by their ID.
+func (s *Storer) ListDefault(_ context.Context) ([]scopes.Scope, error) {
+	return s.list("", 0, func(scope scopes.Scope) bool {
+		return scope.IsDefault
+	})
+}
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`.
+func (s *Storer) List(_ context.Context, after string, limit int) ([]scopes.Scope, error) {
+	return s.list(after, limit, func(scopes.Scope) bool {
+		return true
+	})
+}
+
+// listByException returns all the Scopes listed under `principalID` in the
+// bucket named `index`, sorted lexicographically by their ID.
+func (s *Storer) listByException(index []byte, principalID string) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	err := s.view(func(tx *bolt.Tx) error {
+		principals := tx.Bucket(index).Bucket([]byte(principalID))
+		if principals == nil {
+			return nil
+		}
+		return principals.ForEach(func(key, _ []byte) error {
+			scope, err := getScope(tx, string(key))
+			if err != nil {
+				return err
+			}
+			if scope == nil {
+				return nil
+			}
+			results = append(results, *scope)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByUserException(_ context.Context, userID string) ([]scopes.Scope, error) {
+	return s.listByException(userExceptionsBucket, userID)
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByClientException(_ context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.listByException(clientExceptionsBucket, clientID)
+}
+
+// removeException removes `principalID` from the exceptions of every Scope
+// listed under it in the bucket named `index`, recording an AuditEntry with
+// `action`.
+func (s *Storer) removeException(index []byte, action, principalID string) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	err := s.update(func(tx *bolt.Tx) error {
+		var ids []string
+		if principals := tx.Bucket(index).Bucket([]byte(principalID)); principals != nil {
+			err := principals.ForEach(func(key, _ []byte) error {
+				ids = append(ids, string(key))
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("error listing scopes: %w", err)
+			}
+		}
+		results = make([]scopes.Scope, 0, len(ids))
+		for _, id := range ids {
+			previous, err := getScope(tx, id)
+			if err != nil {
+				return err
+			}
+			if previous == nil {
+				continue
+			}
+			updated := *previous
+			if bytes.Equal(index, userExceptionsBucket) {
+				updated.UserExceptions, _ = scopes.WithoutException(previous.UserExceptions, principalID)
+			} else {
+				updated.ClientExceptions, _ = scopes.WithoutException(previous.ClientExceptions, principalID)
+			}
+			err = putScope(tx, updated, previous)
+			if err != nil {
+				return err
+			}
+			results = append(results, updated)
+		}
+
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return fmt.Errorf("error generating audit entry ID: %w", err)
+		}
+		entry := scopes.AuditEntry{
+			ID:          id,
+			Action:      action,
+			PrincipalID: principalID,
+			ScopeIDs:    make([]string, 0, len(results)),
+			CreatedAt:   time.Now(),
+		}
+		for _, scope := range results {
+			entry.ScopeIDs = append(entry.ScopeIDs, scope.ID)
+		}
+		return recordAuditEntry(tx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// recordAuditEntry stores `entry` after every AuditEntry already recorded.
+func recordAuditEntry(tx *bolt.Tx, entry scopes.AuditEntry) error {
+	bucket := tx.Bucket(auditBucket)
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return fmt.Errorf("error generating audit entry sequence: %w", err)
+	}
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding audit entry: %w", err)
+	}
+	key := make([]byte, 8) //nolint:gomnd // the size of a uint64
+	binary.BigEndian.PutUint64(key, sequence)
+	err = bucket.Put(key, val)
+	if err != nil {
+		return fmt.Errorf("error inserting audit entry: %w", err)
+	}
+	return nil
+}
+
+// RemoveUserException removes `userID` from the UserExceptions of every
+// Scope in the database, recording a single AuditEntry, and returns the
+// Scopes that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveUserException(_ context.Context, userID string) ([]scopes.Scope, error) {
+	return s.removeException(userExceptionsBucket, scopes.AuditRemoveUserException, userID)
+}
+
+// RemoveClientException removes `clientID` from the ClientExceptions of
+// every Scope in the database, recording a single AuditEntry, and returns the
+// Scopes that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveClientException(_ context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.removeException(clientExceptionsBucket, scopes.AuditRemoveClientException, clientID)
+}
+
+// ListAuditEntries returns every AuditEntry recorded in the database, oldest
+// first.
+func (s *Storer) ListAuditEntries(_ context.Context) ([]scopes.AuditEntry, error) {
+	var results []scopes.AuditEntry
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(_, val []byte) error {
+			var entry scopes.AuditEntry
+			err := json.Unmarshal(val, &entry)
+			if err != nil {
+				return fmt.Errorf("error decoding audit entry: %w", err)
+			}
+			results = append(results, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
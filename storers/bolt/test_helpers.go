@@ -0,0 +1,86 @@
+package bolt
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	uuid "github.com/hashicorp/go-uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"lockbox.dev/scopes"
+)
+
+// Factory is a generator of Storers for testing purposes. It knows how to
+// create, track, and clean up bbolt files that tests can be run against.
+type Factory struct {
+	dir       string
+	databases []*bolt.DB
+	lock      sync.Mutex
+}
+
+// NewFactory returns a Factory that is ready to be used. Each test will have
+// its own bbolt file, stored in a temporary directory that is removed by
+// TeardownStorers.
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+// NewStorer creates a new, isolated bbolt file with a random name in the
+// Factory's temporary directory, and returns a Storer backed by it.
+func (f *Factory) NewStorer(ctx context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling wants an interface returned
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.dir == "" {
+		dir, err := ioutil.TempDir("", "scopes_test_")
+		if err != nil {
+			log.Printf("Error creating temporary directory: %+v\n", err)
+			return nil, err
+		}
+		f.dir = dir
+	}
+
+	suffix, err := uuid.GenerateRandomBytes(6) //nolint:gomnd // not magic, just arbitrary
+	if err != nil {
+		log.Printf("Error generating file suffix: %+v\n", err)
+		return nil, err
+	}
+	path := filepath.Join(f.dir, "scopes_test_"+hex.EncodeToString(suffix)+".db")
+
+	db, err := bolt.Open(path, 0o600, nil) //nolint:gomnd // file permissions
+	if err != nil {
+		log.Printf("Error opening %s: %+v\n", path, err)
+		return nil, err
+	}
+	f.databases = append(f.databases, db)
+
+	return NewStorer(ctx, db)
+}
+
+// TeardownStorers closes all the tracked files created by NewStorer and
+// removes the directory they were stored in.
+func (f *Factory) TeardownStorers() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, db := range f.databases {
+		if err := db.Close(); err != nil {
+			return err
+		}
+	}
+	f.databases = nil
+	if f.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(f.dir)
+	if err != nil {
+		return err
+	}
+	f.dir = ""
+	return nil
+}
@@ -0,0 +1,507 @@
+// Package cache provides a read-through caching implementation of the
+// scopes.Storer interface that wraps another scopes.Storer.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"yall.in"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// DefaultTTL is the amount of time a Scope will be cached for if no TTL
+	// is specified.
+	DefaultTTL = time.Minute
+	// DefaultMaxEntries is the number of Scopes that will be cached if no
+	// size bound is specified.
+	DefaultMaxEntries = 1024
+)
+
+// Notifier is an interface for informing other instances of the service that
+// Scopes have changed, so they can invalidate their caches. Implementations
+// are expected to arrange for Storer.Invalidate to be called on the other
+// instances.
+type Notifier interface {
+	Notify(ctx context.Context, ids []string) error
+}
+
+// Options configures the caching behavior of a Storer.
+type Options struct {
+	// TTL is the amount of time a Scope will be cached for. If not set,
+	// DefaultTTL is used.
+	TTL time.Duration
+
+	// NegativeTTL is the amount of time the absence of a Scope will be
+	// cached for. If not set, TTL is used.
+	NegativeTTL time.Duration
+
+	// MaxEntries is the maximum number of Scopes, or absences of Scopes,
+	// that will be cached at once. When it is exceeded, the least recently
+	// used entries are evicted. If not set, DefaultMaxEntries is used.
+	MaxEntries int
+
+	// Notifier, if set, will be informed of every Scope changed through
+	// this Storer, so that other instances can invalidate their caches.
+	Notifier Notifier
+}
+
+type entry struct {
+	id      string
+	scope   scopes.Scope
+	found   bool
+	expires time.Time
+}
+
+// changeCollector is a Notifier that records the IDs of the Scopes changed
+// in a transaction, so they can be invalidated once it's finished.
+type changeCollector struct {
+	lock sync.Mutex
+	ids  []string
+}
+
+func (c *changeCollector) Notify(_ context.Context, ids []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ids = append(c.ids, ids...)
+	return nil
+}
+
+func (c *changeCollector) list() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ids
+}
+
+// Storer is an implementation of the Storer interface that caches the
+// results of another Storer in memory.
+type Storer struct {
+	storer scopes.Storer
+	opts   Options
+	now    func() time.Time
+
+	lock           sync.Mutex
+	entries        map[string]*list.Element
+	lru            *list.List
+	defaults       []scopes.Scope
+	defaultsCached bool
+	defaultsExpire time.Time
+	// generation is incremented every time the cache is invalidated, so
+	// reads that started before the invalidation don't repopulate the
+	// cache with stale results.
+	generation uint64
+}
+
+// NewStorer returns a Storer instance that caches the results of `storer`
+// according to `opts`. The returned Storer instance is ready to be used as a
+// Storer.
+func NewStorer(storer scopes.Storer, opts Options) *Storer {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = opts.TTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	return &Storer{
+		storer:  storer,
+		opts:    opts,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Invalidate removes the Scopes specified by the passed IDs from the cache,
+// along with the cached list of default Scopes. It should be called when a
+// Scope has been changed without using this Storer, e.g. when another
+// instance's Notifier reports a change.
+func (s *Storer) Invalidate(ids ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.generation++
+	for _, id := range ids {
+		if elem, ok := s.entries[id]; ok {
+			s.lru.Remove(elem)
+			delete(s.entries, id)
+		}
+	}
+	s.defaults = nil
+	s.defaultsCached = false
+}
+
+// Purge removes everything from the cache.
+func (s *Storer) Purge() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.generation++
+	s.entries = map[string]*list.Element{}
+	s.lru.Init()
+	s.defaults = nil
+	s.defaultsCached = false
+}
+
+// changed invalidates the cache for the Scopes specified by the passed IDs
+// and informs the Notifier, if there is one.
+func (s *Storer) changed(ctx context.Context, ids ...string) {
+	s.Invalidate(ids...)
+	if s.opts.Notifier == nil {
+		return
+	}
+	if err := s.opts.Notifier.Notify(ctx, ids); err != nil {
+		yall.FromContext(ctx).WithError(err).Error("error notifying of scope change")
+	}
+}
+
+// get returns the cached entry for `id`, if there is an unexpired one.
+// s.lock must be held when calling get.
+func (s *Storer) get(id string, now time.Time) (entry, bool) {
+	elem, ok := s.entries[id]
+	if !ok {
+		return entry{}, false
+	}
+	ent := elem.Value.(*entry) //nolint:forcetypeassert // we control what goes in the list
+	if !now.Before(ent.expires) {
+		s.lru.Remove(elem)
+		delete(s.entries, id)
+		return entry{}, false
+	}
+	s.lru.MoveToFront(elem)
+	return *ent, true
+}
+
+// set caches `ent`, evicting the least recently used entries if necessary.
+// s.lock must be held when calling set.
+func (s *Storer) set(ent entry) {
+	if elem, ok := s.entries[ent.id]; ok {
+		elem.Value = &ent
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[ent.id] = s.lru.PushFront(&ent)
+	for s.lru.Len() > s.opts.MaxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).id) //nolint:forcetypeassert // we control what goes in the list
+	}
+}
+
+// Create inserts the passed Scope into the underlying Storer and invalidates
+// any cached information about it.
+func (s *Storer) Create(ctx context.Context, scope scopes.Scope) error {
+	err := s.storer.Create(ctx, scope)
+	if err != nil {
+		return err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changed(ctx, scope.ID)
+	return nil
+}
+
+// Put stores the passed Scope in the underlying Storer, creating or replacing
+// it, and invalidates any cached information about it.
+func (s *Storer) Put(ctx context.Context, scope scopes.Scope) (bool, error) {
+	created, err := s.storer.Put(ctx, scope)
+	if err != nil {
+		return false, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changed(ctx, scope.ID)
+	return created, nil
+}
+
+// ApplyBatch applies `ops` to the underlying Storer using scopes.ApplyBatch,
+// and invalidates any cached information about the Scopes they changed.
+func (s *Storer) ApplyBatch(ctx context.Context, ops []scopes.Operation) ([]scopes.Scope, error) {
+	results, err := scopes.ApplyBatch(ctx, s.storer, ops)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changedScopes(ctx, results)
+	return results, nil
+}
+
+// CheckHealth checks the health of the underlying Storer, if it implements
+// scopes.HealthChecker. Otherwise, the Storer is always considered healthy.
+func (s *Storer) CheckHealth(ctx context.Context) error {
+	checker, ok := s.storer.(scopes.HealthChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckHealth(ctx) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// Watch watches the underlying Storer for changes, if it implements
+// scopes.Watcher. Otherwise, scopes.ErrWatchUnsupported is returned. Events
+// are never cached.
+func (s *Storer) Watch(ctx context.Context, fromRevision uint64) (<-chan scopes.Event, error) {
+	watcher, ok := s.storer.(scopes.Watcher)
+	if !ok {
+		return nil, scopes.ErrWatchUnsupported
+	}
+	return watcher.Watch(ctx, fromRevision) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// CurrentRevision returns the current Revision of the underlying Storer, if
+// it implements scopes.Watcher. Otherwise, scopes.ErrWatchUnsupported is
+// returned.
+func (s *Storer) CurrentRevision(ctx context.Context) (uint64, error) {
+	watcher, ok := s.storer.(scopes.Watcher)
+	if !ok {
+		return 0, scopes.ErrWatchUnsupported
+	}
+	return watcher.CurrentRevision(ctx) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// WithTx calls `fn` in a transaction of the underlying Storer, if it
+// implements scopes.Transactor. Otherwise, scopes.ErrTxUnsupported is
+// returned. The Storer passed to `fn` has its own cache, so the transaction
+// never reads Scopes cached outside it and its uncommitted Scopes are never
+// cached outside it. The Scopes changed in the transaction are invalidated
+// once it's finished.
+func (s *Storer) WithTx(ctx context.Context, fn func(context.Context, scopes.Storer) error) error {
+	transactor, ok := s.storer.(scopes.Transactor)
+	if !ok {
+		return scopes.ErrTxUnsupported
+	}
+	changes := &changeCollector{}
+	err := transactor.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		return fn(ctx, NewStorer(tx, Options{
+			TTL:         s.opts.TTL,
+			NegativeTTL: s.opts.NegativeTTL,
+			MaxEntries:  s.opts.MaxEntries,
+			Notifier:    changes,
+		}))
+	})
+	// even when the transaction failed, invalidating is harmless, and
+	// it's safer than trusting that nothing was committed
+	if ids := changes.list(); len(ids) > 0 {
+		s.changed(ctx, ids...)
+	}
+	return err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// UserCanUse checks whether the user can use the Scope using the underlying
+// Storer, if it implements scopes.AccessChecker. Otherwise,
+// scopes.ErrAccessCheckUnsupported is returned. Access checks are never
+// cached.
+func (s *Storer) UserCanUse(ctx context.Context, scopeID, userID string) (bool, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return false, scopes.ErrAccessCheckUnsupported
+	}
+	return checker.UserCanUse(ctx, scopeID, userID) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// ClientCanUse checks whether the client can use the Scope using the
+// underlying Storer, if it implements scopes.AccessChecker. Otherwise,
+// scopes.ErrAccessCheckUnsupported is returned. Access checks are never
+// cached.
+func (s *Storer) ClientCanUse(ctx context.Context, scopeID, clientID string) (bool, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return false, scopes.ErrAccessCheckUnsupported
+	}
+	return checker.ClientCanUse(ctx, scopeID, clientID) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// ListUsable lists the Scopes the principals described by `query` can use
+// using the underlying Storer, if it implements scopes.AccessChecker.
+// Otherwise, scopes.ErrAccessCheckUnsupported is returned. Results are
+// never cached.
+func (s *Storer) ListUsable(ctx context.Context, query scopes.AccessQuery, after string, limit int) ([]scopes.Scope, error) {
+	checker, ok := s.storer.(scopes.AccessChecker)
+	if !ok {
+		return nil, scopes.ErrAccessCheckUnsupported
+	}
+	return checker.ListUsable(ctx, query, after, limit) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// ListAuditEntries lists the audit entries recorded by the underlying
+// Storer, if it implements scopes.AuditLister. Otherwise,
+// scopes.ErrAuditUnsupported is returned. Audit entries are never cached.
+func (s *Storer) ListAuditEntries(ctx context.Context) ([]scopes.AuditEntry, error) {
+	auditor, ok := s.storer.(scopes.AuditLister)
+	if !ok {
+		return nil, scopes.ErrAuditUnsupported
+	}
+	return auditor.ListAuditEntries(ctx) //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+}
+
+// GetMulti retrieves the Scopes specified by the passed IDs, from the cache
+// if possible and from the underlying Storer if not. Scopes that aren't found
+// will be omitted from the map, and their absence will be cached.
+func (s *Storer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.Scope, error) {
+	results := map[string]scopes.Scope{}
+	var misses []string
+
+	s.lock.Lock()
+	now := s.now()
+	generation := s.generation
+	for _, id := range ids {
+		ent, ok := s.get(id, now)
+		if !ok {
+			misses = append(misses, id)
+			continue
+		}
+		if ent.found {
+			results[id] = ent.scope
+		}
+	}
+	s.lock.Unlock()
+
+	if len(misses) < 1 {
+		return results, nil
+	}
+	fetched, err := s.storer.GetMulti(ctx, misses)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving scopes: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cache := generation == s.generation
+	for _, id := range misses {
+		scope, ok := fetched[id]
+		if ok {
+			results[id] = scope
+		}
+		if !cache {
+			continue
+		}
+		ttl := s.opts.TTL
+		if !ok {
+			ttl = s.opts.NegativeTTL
+		}
+		s.set(entry{id: id, scope: scope, found: ok, expires: now.Add(ttl)})
+	}
+	return results, nil
+}
+
+// Update applies the passed Change to the Scope that matches the specified ID
+// in the underlying Storer, and invalidates any cached information about it.
+func (s *Storer) Update(ctx context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	updated, err := s.storer.Update(ctx, id, change)
+	if err != nil {
+		return scopes.Scope{}, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	if !change.IsEmpty() {
+		s.changed(ctx, id)
+	}
+	return updated, nil
+}
+
+// Delete removes the Scope that matches the specified ID from the underlying
+// Storer, and invalidates any cached information about it.
+func (s *Storer) Delete(ctx context.Context, id string) (scopes.Scope, error) {
+	deleted, err := s.storer.Delete(ctx, id)
+	if err != nil {
+		return scopes.Scope{}, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changed(ctx, id)
+	return deleted, nil
+}
+
+// ListDefault returns all the Scopes with IsDefault set to true, sorted
+// lexicographically by their ID, from the cache if possible and from the
+// underlying Storer if not.
+func (s *Storer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
+	s.lock.Lock()
+	now := s.now()
+	generation := s.generation
+	if s.defaultsCached && now.Before(s.defaultsExpire) {
+		results := append([]scopes.Scope(nil), s.defaults...)
+		s.lock.Unlock()
+		return results, nil
+	}
+	s.lock.Unlock()
+
+	results, err := s.storer.ListDefault(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing default scopes: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if generation == s.generation {
+		s.defaults = append([]scopes.Scope(nil), results...)
+		s.defaultsCached = true
+		s.defaultsExpire = now.Add(s.opts.TTL)
+	}
+	return results, nil
+}
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`. The results are
+// always retrieved from the underlying Storer, and are not cached.
+func (s *Storer) List(ctx context.Context, after string, limit int) ([]scopes.Scope, error) {
+	results, err := s.storer.List(ctx, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes: %w", err)
+	}
+	return results, nil
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID. The results are
+// always retrieved from the underlying Storer, and are not cached.
+func (s *Storer) ListByUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	results, err := s.storer.ListByUserException(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes by user exception: %w", err)
+	}
+	return results, nil
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID. The results are
+// always retrieved from the underlying Storer, and are not cached.
+func (s *Storer) ListByClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	results, err := s.storer.ListByClientException(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes by client exception: %w", err)
+	}
+	return results, nil
+}
+
+// RemoveUserException removes `userID` from the UserExceptions of every
+// Scope in the underlying Storer, and invalidates any cached information
+// about the Scopes that were changed.
+func (s *Storer) RemoveUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	results, err := s.storer.RemoveUserException(ctx, userID)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changedScopes(ctx, results)
+	return results, nil
+}
+
+// RemoveClientException removes `clientID` from the ClientExceptions of
+// every Scope in the underlying Storer, and invalidates any cached
+// information about the Scopes that were changed.
+func (s *Storer) RemoveClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	results, err := s.storer.RemoveClientException(ctx, clientID)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // we want to return the wrapped Storer's errors unmodified
+	}
+	s.changedScopes(ctx, results)
+	return results, nil
+}
+
+// changedScopes invalidates and notifies others of changes to `changed`, if
+// there are any.
+func (s *Storer) changedScopes(ctx context.Context, changed []scopes.Scope) {
+	if len(changed) < 1 {
+		return
+	}
+	ids := make([]string, 0, len(changed))
+	for _, scope := range changed {
+		ids = append(ids, scope.ID)
+	}
+	s.changed(ctx, ids...)
+}
@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+type countingStorer struct {
+	scopes.Storer
+	lock        sync.Mutex
+	getMulti    int
+	listDefault int
+}
+
+func (c *countingStorer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.Scope, error) {
+	c.lock.Lock()
+	c.getMulti++
+	c.lock.Unlock()
+	return c.Storer.GetMulti(ctx, ids) //nolint:wrapcheck // just a passthrough
+}
+
+func (c *countingStorer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
+	c.lock.Lock()
+	c.listDefault++
+	c.lock.Unlock()
+	return c.Storer.ListDefault(ctx) //nolint:wrapcheck // just a passthrough
+}
+
+type recordingNotifier struct {
+	lock sync.Mutex
+	ids  []string
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, ids []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids = append(r.ids, ids...)
+	return nil
+}
+
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func newTestStorer(t *testing.T, opts Options) (*Storer, *countingStorer, *fakeClock) {
+	t.Helper()
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Unexpected error creating memory Storer: %s", err.Error())
+	}
+	counter := &countingStorer{Storer: backend}
+	clock := &fakeClock{now: time.Now()}
+	storer := NewStorer(counter, opts)
+	storer.now = clock.Now
+	return storer, counter, clock
+}
+
+func TestGetMultiCachesUntilTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer, counter, clock := newTestStorer(t, Options{TTL: time.Minute})
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	err := counter.Storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := storer.GetMulti(ctx, []string{scope.ID})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+		}
+		if _, ok := res[scope.ID]; !ok {
+			t.Fatalf("Expected scope %q to be in results, wasn't", scope.ID)
+		}
+	}
+	if counter.getMulti != 1 {
+		t.Errorf("Expected 1 call to the underlying Storer, got %d", counter.getMulti)
+	}
+
+	clock.Advance(time.Minute)
+	_, err = storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if counter.getMulti != 2 {
+		t.Errorf("Expected 2 calls to the underlying Storer after TTL, got %d", counter.getMulti)
+	}
+}
+
+func TestGetMultiNegativeCaching(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer, counter, clock := newTestStorer(t, Options{TTL: time.Hour, NegativeTTL: time.Second})
+
+	for i := 0; i < 3; i++ {
+		res, err := storer.GetMulti(ctx, []string{"nope"})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+		}
+		if len(res) != 0 {
+			t.Fatalf("Expected 0 results, got %+v", res)
+		}
+	}
+	if counter.getMulti != 1 {
+		t.Errorf("Expected 1 call to the underlying Storer, got %d", counter.getMulti)
+	}
+
+	clock.Advance(time.Second)
+	_, err := storer.GetMulti(ctx, []string{"nope"})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if counter.getMulti != 2 {
+		t.Errorf("Expected 2 calls to the underlying Storer after negative TTL, got %d", counter.getMulti)
+	}
+}
+
+func TestWritesInvalidateAndNotify(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	storer, counter, _ := newTestStorer(t, Options{TTL: time.Hour, Notifier: notifier})
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+		IsDefault:    true,
+	}
+
+	// cache the absence of the scope and the empty default list
+	_, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	_, err = storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	res, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if _, ok := res[scope.ID]; !ok {
+		t.Fatalf("Expected scope %q to be in results after create, wasn't", scope.ID)
+	}
+	defaults, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+	if len(defaults) != 1 {
+		t.Fatalf("Expected 1 default scope after create, got %+v", defaults)
+	}
+
+	policy := scopes.PolicyDenyAll
+	_, err = storer.Update(ctx, scope.ID, scopes.Change{UserPolicy: &policy})
+	if err != nil {
+		t.Fatalf("Unexpected error updating scope: %s", err.Error())
+	}
+	res, err = storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if res[scope.ID].UserPolicy != policy {
+		t.Errorf("Expected user policy to be %q after update, got %q", policy, res[scope.ID].UserPolicy)
+	}
+
+	_, err = storer.Delete(ctx, scope.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err.Error())
+	}
+	res, err = storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if len(res) != 0 {
+		t.Errorf("Expected 0 results after delete, got %+v", res)
+	}
+
+	if counter.getMulti != 4 {
+		t.Errorf("Expected 4 calls to the underlying Storer, got %d", counter.getMulti)
+	}
+	if len(notifier.ids) != 3 {
+		t.Errorf("Expected 3 notifications, got %+v", notifier.ids)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer, counter, _ := newTestStorer(t, Options{TTL: time.Hour, MaxEntries: 2})
+
+	for _, id := range []string{"a", "b", "c", "a"} {
+		_, err := storer.GetMulti(ctx, []string{id})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+		}
+	}
+	// "a" was evicted when "c" was cached, so it must be fetched again
+	if counter.getMulti != 4 {
+		t.Errorf("Expected 4 calls to the underlying Storer, got %d", counter.getMulti)
+	}
+	if storer.lru.Len() != 2 {
+		t.Errorf("Expected 2 cached entries, got %d", storer.lru.Len())
+	}
+}
+
+func TestWithTxInvalidatesAndNotifies(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Unexpected error creating memory Storer: %s", err.Error())
+	}
+	notifier := &recordingNotifier{}
+	storer := NewStorer(backend, Options{TTL: time.Hour, Notifier: notifier})
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+
+	// cache the absence of the scope
+	_, err = storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+
+	err = storer.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		return tx.Create(ctx, scope)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error in transaction: %s", err.Error())
+	}
+	res, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if _, ok := res[scope.ID]; !ok {
+		t.Errorf("Expected scope %q to be in results after transaction, wasn't", scope.ID)
+	}
+	if len(notifier.ids) != 1 || notifier.ids[0] != scope.ID {
+		t.Errorf("Expected a notification for %q, got %+v", scope.ID, notifier.ids)
+	}
+}
+
+func TestOptionalInterfacesOfPlainStorer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	// countingStorer only implements scopes.Storer, hiding the memory
+	// Storer's optional interfaces
+	storer, _, _ := newTestStorer(t, Options{})
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	err = storer.WithTx(ctx, func(context.Context, scopes.Storer) error {
+		t.Error("Expected transaction not to run")
+		return nil
+	})
+	if !errors.Is(err, scopes.ErrTxUnsupported) {
+		t.Errorf("Expected %v from WithTx, got %v", scopes.ErrTxUnsupported, err)
+	}
+	_, err = storer.UserCanUse(ctx, scope.ID, "user")
+	if !errors.Is(err, scopes.ErrAccessCheckUnsupported) {
+		t.Errorf("Expected %v from UserCanUse, got %v", scopes.ErrAccessCheckUnsupported, err)
+	}
+	_, err = storer.ClientCanUse(ctx, scope.ID, "client")
+	if !errors.Is(err, scopes.ErrAccessCheckUnsupported) {
+		t.Errorf("Expected %v from ClientCanUse, got %v", scopes.ErrAccessCheckUnsupported, err)
+	}
+	_, err = storer.ListUsable(ctx, scopes.AccessQuery{UserID: "user"}, "", 10)
+	if !errors.Is(err, scopes.ErrAccessCheckUnsupported) {
+		t.Errorf("Expected %v from ListUsable, got %v", scopes.ErrAccessCheckUnsupported, err)
+	}
+	_, err = storer.ListAuditEntries(ctx)
+	if !errors.Is(err, scopes.ErrAuditUnsupported) {
+		t.Errorf("Expected %v from ListAuditEntries, got %v", scopes.ErrAuditUnsupported, err)
+	}
+	_, err = storer.ApplyBatch(ctx, []scopes.Operation{{Type: scopes.OperationDelete, ID: scope.ID}})
+	if !errors.Is(err, scopes.ErrBatchUnsupported) {
+		t.Errorf("Expected %v from ApplyBatch, got %v", scopes.ErrBatchUnsupported, err)
+	}
+
+	// the helpers fall back to reading the Scopes
+	report, err := scopes.UsableScopes(ctx, storer, scopes.AccessQuery{UserID: "user"}, "", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error listing usable scopes: %s", err.Error())
+	}
+	if len(report.Scopes) != 1 || report.Scopes[0].ID != scope.ID {
+		t.Errorf("Expected %q to be usable, got %+v", scope.ID, report.Scopes)
+	}
+	written, err := scopes.Export(ctx, storer, io.Discard)
+	if err != nil {
+		t.Fatalf("Unexpected error exporting scopes: %s", err.Error())
+	}
+	if written != 1 {
+		t.Errorf("Expected 1 scope to be exported, got %d", written)
+	}
+}
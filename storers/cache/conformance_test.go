@@ -0,0 +1,13 @@
+package cache
+
+import (
+	"testing"
+
+	"lockbox.dev/scopes/storertest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storertest.RunConformance(t, Factory{})
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+// Factory is a generator of Storers for testing purposes. The Storers it
+// generates cache the results of new, isolated, in-memory Storers.
+type Factory struct{}
+
+// NewStorer creates a new caching Storer wrapping a new, isolated, in-memory
+// Storer for tests.
+func (Factory) NewStorer(_ context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling returns an interface here
+	storer, err := memory.NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	return NewStorer(storer, Options{}), nil
+}
+
+// TeardownStorers does nothing and is only included to fill an interface.
+func (Factory) TeardownStorers() error {
+	return nil
+}
@@ -0,0 +1,333 @@
+// Package file provides a read-only implementation of the scopes.Storer
+// interface that serves Scopes declared in a YAML or JSON file, reloading
+// them when the file changes.
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"yall.in"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// DefaultPollInterval is how often Run checks the file for changes, if
+	// no other value is set.
+	DefaultPollInterval = 5 * time.Second
+)
+
+// ReadOnlyError is returned when attempting to change Scopes through the
+// Storer. Scopes are changed by editing the file instead.
+type ReadOnlyError struct {
+	Method string
+}
+
+func (e ReadOnlyError) Error() string {
+	return "can't " + e.Method + ": scopes are read from a file and can't be changed through the Storer"
+}
+
+// InvalidScopeError is returned when a Scope in the file isn't valid. Index
+// is the position of the Scope in the file, starting from 0, and Field is
+// the name of the invalid field in the file.
+type InvalidScopeError struct {
+	Path  string
+	Index int
+	ID    string
+	Field string
+	Err   error
+}
+
+func (e InvalidScopeError) Error() string {
+	return fmt.Sprintf("%s: scope %d (%q): invalid %s: %s", e.Path, e.Index, e.ID, e.Field, e.Err)
+}
+
+func (e InvalidScopeError) Unwrap() error {
+	return e.Err
+}
+
+// Document is the format of the file Scopes are read from.
+type Document struct {
+	Scopes []Scope `json:"scopes" yaml:"scopes"`
+}
+
+// Scope is the representation of a scopes.Scope in the file.
+type Scope struct {
+	ID               string   `json:"id" yaml:"id"`
+	UserPolicy       string   `json:"userPolicy" yaml:"userPolicy"`
+	UserExceptions   []string `json:"userExceptions,omitempty" yaml:"userExceptions,omitempty"`
+	ClientPolicy     string   `json:"clientPolicy" yaml:"clientPolicy"`
+	ClientExceptions []string `json:"clientExceptions,omitempty" yaml:"clientExceptions,omitempty"`
+	IsDefault        bool     `json:"isDefault,omitempty" yaml:"isDefault,omitempty"`
+}
+
+func coreScope(scope Scope) scopes.Scope {
+	return scopes.Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+// snapshot is the set of Scopes loaded from one version of the file. It is
+// never modified after it's loaded, so reads can use it without locking.
+type snapshot struct {
+	byID   map[string]scopes.Scope
+	sorted []scopes.Scope
+	// sum is the SHA-256 hash of the version of the file the snapshot was
+	// loaded from. Modification times and sizes can't be relied on to
+	// change when the contents do, so the contents are compared instead.
+	sum [sha256.Size]byte
+}
+
+// Storer is a read-only implementation of the Storer interface that serves
+// Scopes loaded from a YAML or JSON file.
+type Storer struct {
+	path string
+
+	// PollInterval is how often Run checks the file for changes. If not
+	// set, DefaultPollInterval is used.
+	PollInterval time.Duration
+
+	// current holds the *snapshot reads are served from. Reloading
+	// replaces it, so reads in progress finish using the snapshot they
+	// started with.
+	current atomic.Value
+	// reloadLock keeps reloads from racing each other.
+	reloadLock sync.Mutex
+}
+
+// NewStorer returns a Storer instance that serves the Scopes in the file at
+// `path`, which is parsed as JSON if it has a .json extension and as YAML
+// otherwise. The file is loaded and validated before NewStorer returns, and
+// an error is returned if it can't be. The returned Storer instance is ready
+// to be used as a Storer.
+func NewStorer(ctx context.Context, path string) (*Storer, error) {
+	storer := &Storer{path: path}
+	_, err := storer.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storer, nil
+}
+
+func (s *Storer) snapshot() *snapshot {
+	return s.current.Load().(*snapshot) //nolint:forcetypeassert // we control what gets stored
+}
+
+// Reload reads the file again if it has changed since it was last loaded,
+// and returns whether it was reloaded. If the new version of the file can't
+// be read or isn't valid, an error is returned and the Storer keeps serving
+// the Scopes it had already loaded.
+func (s *Storer) Reload(ctx context.Context) (bool, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	contents, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %w", s.path, err)
+	}
+	sum := sha256.Sum256(contents)
+	if prev, ok := s.current.Load().(*snapshot); ok && prev.sum == sum {
+		return false, nil
+	}
+	loaded, err := s.parse(contents)
+	if err != nil {
+		return false, err
+	}
+	loaded.sum = sum
+	s.current.Store(loaded)
+	yall.FromContext(ctx).WithField("path", s.path).WithField("scopes", len(loaded.sorted)).Debug("loaded scopes")
+	return true, nil
+}
+
+// parse decodes and validates `contents`, returning a snapshot of the Scopes
+// it declares.
+func (s *Storer) parse(contents []byte) (*snapshot, error) {
+	var doc Document
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(contents))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", s.path, err)
+		}
+	default:
+		err := yaml.UnmarshalStrict(contents, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", s.path, err)
+		}
+	}
+	loaded := &snapshot{
+		byID:   make(map[string]scopes.Scope, len(doc.Scopes)),
+		sorted: make([]scopes.Scope, 0, len(doc.Scopes)),
+	}
+	for pos, scope := range doc.Scopes {
+		invalid := InvalidScopeError{Path: s.path, Index: pos, ID: scope.ID}
+		invalid.Field, invalid.Err = scopes.Validate(coreScope(scope))
+		if _, ok := loaded.byID[scope.ID]; ok && invalid.Err == nil {
+			invalid.Field, invalid.Err = "id", scopes.ErrScopeAlreadyExists
+		}
+		if invalid.Err != nil {
+			return nil, invalid
+		}
+		loaded.byID[scope.ID] = coreScope(scope)
+		loaded.sorted = append(loaded.sorted, coreScope(scope))
+	}
+	scopes.ByID(loaded.sorted)
+	return loaded, nil
+}
+
+// Run checks the file for changes every PollInterval, reloading it when it
+// changes, until `ctx` is canceled. Errors reloading the file are logged, and
+// the Storer keeps serving the Scopes it had already loaded.
+func (s *Storer) Run(ctx context.Context) {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := s.Reload(ctx)
+		if err != nil {
+			yall.FromContext(ctx).WithError(err).WithField("path", s.path).Error("error reloading scopes")
+		}
+	}
+}
+
+// copyScope returns a copy of `scope` that doesn't share exception lists
+// with it, so callers can't modify the snapshot.
+func copyScope(scope scopes.Scope) scopes.Scope {
+	if scope.UserExceptions != nil {
+		scope.UserExceptions = append([]string{}, scope.UserExceptions...)
+	}
+	if scope.ClientExceptions != nil {
+		scope.ClientExceptions = append([]string{}, scope.ClientExceptions...)
+	}
+	return scope
+}
+
+// Create always returns a ReadOnlyError.
+func (*Storer) Create(_ context.Context, _ scopes.Scope) error {
+	return ReadOnlyError{Method: "create"}
+}
+
+// Put always returns a ReadOnlyError.
+func (*Storer) Put(_ context.Context, _ scopes.Scope) (bool, error) {
+	return false, ReadOnlyError{Method: "put"}
+}
+
+// GetMulti retrieves the Scopes specified by the passed IDs from the file,
+// returning an empty map if no matching Scopes are found. If a Scope is not
+// found, no error will be returned, it will just be omitted from the map.
+func (s *Storer) GetMulti(_ context.Context, ids []string) (map[string]scopes.Scope, error) {
+	loaded := s.snapshot()
+	results := map[string]scopes.Scope{}
+	for _, id := range ids {
+		scope, ok := loaded.byID[id]
+		if !ok {
+			continue
+		}
+		results[id] = copyScope(scope)
+	}
+	return results, nil
+}
+
+// Update always returns a ReadOnlyError.
+func (*Storer) Update(_ context.Context, _ string, _ scopes.Change) (scopes.Scope, error) {
+	return scopes.Scope{}, ReadOnlyError{Method: "update"}
+}
+
+// Delete always returns a ReadOnlyError.
+func (*Storer) Delete(_ context.Context, _ string) (scopes.Scope, error) {
+	return scopes.Scope{}, ReadOnlyError{Method: "delete"}
+}
+
+// filter returns copies of the loaded Scopes that `include` returns true
+// for, sorted lexicographically by their ID.
+func (s *Storer) filter(include func(scopes.Scope) bool) []scopes.Scope {
+	var results []scopes.Scope
+	for _, scope := range s.snapshot().sorted {
+		if !include(scope) {
+			continue
+		}
+		results = append(results, copyScope(scope))
+	}
+	return results
+}
+
+// ListDefault returns all the Scopes with IsDefault set to true, sorted
+// lexicographically by their ID.
+func (s *Storer) ListDefault(_ context.Context) ([]scopes.Scope, error) {
+	return s.filter(func(scope scopes.Scope) bool {
+		return scope.IsDefault
+	}), nil
+}
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`.
+func (s *Storer) List(_ context.Context, after string, limit int) ([]scopes.Scope, error) {
+	sorted := s.snapshot().sorted
+	start := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i].ID > after
+	})
+	end := len(sorted)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	var results []scopes.Scope
+	for _, scope := range sorted[start:end] {
+		results = append(results, copyScope(scope))
+	}
+	return results, nil
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByUserException(_ context.Context, userID string) ([]scopes.Scope, error) {
+	return s.filter(func(scope scopes.Scope) bool {
+		_, listed := scopes.WithoutException(scope.UserExceptions, userID)
+		return listed
+	}), nil
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByClientException(_ context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.filter(func(scope scopes.Scope) bool {
+		_, listed := scopes.WithoutException(scope.ClientExceptions, clientID)
+		return listed
+	}), nil
+}
+
+// RemoveUserException always returns a ReadOnlyError.
+func (*Storer) RemoveUserException(_ context.Context, _ string) ([]scopes.Scope, error) {
+	return nil, ReadOnlyError{Method: "remove user exception"}
+}
+
+// RemoveClientException always returns a ReadOnlyError.
+func (*Storer) RemoveClientException(_ context.Context, _ string) ([]scopes.Scope, error) {
+	return nil, ReadOnlyError{Method: "remove client exception"}
+}
@@ -0,0 +1,306 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+)
+
+const yamlScopes = `scopes:
+  - id: https://scopes.impractical.co/file/b
+    userPolicy: DEFAULT_DENY
+    userExceptions: [user-1]
+    clientPolicy: ALLOW_ALL
+    isDefault: true
+  - id: https://scopes.impractical.co/file/a
+    userPolicy: DEFAULT_ALLOW
+    clientPolicy: DEFAULT_DENY
+    clientExceptions: [client-1, client-2]
+`
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := ioutil.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", path, err)
+	}
+}
+
+func TestLoadsYAMLAndJSON(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	expected := []scopes.Scope{
+		{
+			ID:               "https://scopes.impractical.co/file/a",
+			UserPolicy:       scopes.PolicyDefaultAllow,
+			ClientPolicy:     scopes.PolicyDefaultDeny,
+			ClientExceptions: []string{"client-1", "client-2"},
+		},
+		{
+			ID:             "https://scopes.impractical.co/file/b",
+			UserPolicy:     scopes.PolicyDefaultDeny,
+			UserExceptions: []string{"user-1"},
+			ClientPolicy:   scopes.PolicyAllowAll,
+			IsDefault:      true,
+		},
+	}
+
+	files := map[string]string{
+		"scopes.yaml": yamlScopes,
+		"scopes.json": `{"scopes": [
+			{"id": "https://scopes.impractical.co/file/b", "userPolicy": "DEFAULT_DENY", "userExceptions": ["user-1"], "clientPolicy": "ALLOW_ALL", "isDefault": true},
+			{"id": "https://scopes.impractical.co/file/a", "userPolicy": "DEFAULT_ALLOW", "clientPolicy": "DEFAULT_DENY", "clientExceptions": ["client-1", "client-2"]}
+		]}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, contents)
+		storer, err := NewStorer(ctx, path)
+		if err != nil {
+			t.Fatalf("Unexpected error loading %s: %s", name, err)
+		}
+		all, err := storer.List(ctx, "", 0)
+		if err != nil {
+			t.Fatalf("Unexpected error listing scopes from %s: %s", name, err)
+		}
+		if diff := cmp.Diff(expected, all); diff != "" {
+			t.Errorf("Unexpected diff listing scopes from %s (-wanted, +got): %s", name, diff)
+		}
+		defaults, err := storer.ListDefault(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error listing default scopes from %s: %s", name, err)
+		}
+		if diff := cmp.Diff(expected[1:], defaults); diff != "" {
+			t.Errorf("Unexpected diff listing default scopes from %s (-wanted, +got): %s", name, diff)
+		}
+		byClient, err := storer.ListByClientException(ctx, "client-2")
+		if err != nil {
+			t.Fatalf("Unexpected error listing scopes by client exception from %s: %s", name, err)
+		}
+		if diff := cmp.Diff(expected[:1], byClient); diff != "" {
+			t.Errorf("Unexpected diff listing scopes by client exception from %s (-wanted, +got): %s", name, diff)
+		}
+	}
+}
+
+func TestRejectsInvalidScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	tests := map[string]struct {
+		contents string
+		index    int
+		field    string
+	}{
+		"missing-id": {
+			contents: "scopes:\n  - userPolicy: ALLOW_ALL\n    clientPolicy: ALLOW_ALL\n",
+			index:    0,
+			field:    "id",
+		},
+		"bad-user-policy": {
+			contents: "scopes:\n  - id: a\n    userPolicy: ALLOW_ALL\n    clientPolicy: ALLOW_ALL\n  - id: b\n    userPolicy: ALLOW_SOME\n    clientPolicy: ALLOW_ALL\n",
+			index:    1,
+			field:    "userPolicy",
+		},
+		"missing-client-policy": {
+			contents: "scopes:\n  - id: a\n    userPolicy: ALLOW_ALL\n",
+			index:    0,
+			field:    "clientPolicy",
+		},
+		"empty-client-exception": {
+			contents: "scopes:\n  - id: a\n    userPolicy: ALLOW_ALL\n    clientPolicy: DEFAULT_DENY\n    clientExceptions: [client-1, '']\n",
+			index:    0,
+			field:    "clientExceptions",
+		},
+		"duplicate-id": {
+			contents: "scopes:\n  - id: a\n    userPolicy: ALLOW_ALL\n    clientPolicy: ALLOW_ALL\n  - id: a\n    userPolicy: DENY_ALL\n    clientPolicy: DENY_ALL\n",
+			index:    1,
+			field:    "id",
+		},
+	}
+	for name, test := range tests {
+		path := filepath.Join(dir, name+".yaml")
+		writeFile(t, path, test.contents)
+		_, err := NewStorer(ctx, path)
+		var invalid InvalidScopeError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: expected InvalidScopeError, got %v", name, err)
+			continue
+		}
+		if invalid.Index != test.index || invalid.Field != test.field {
+			t.Errorf("%s: expected scope %d field %s to be invalid, got %+v", name, test.index, test.field, invalid)
+		}
+	}
+
+	// unknown fields are usually typos, so they're rejected too
+	path := filepath.Join(dir, "typo.yaml")
+	writeFile(t, path, "scopes:\n  - id: a\n    userPolicy: ALLOW_ALL\n    clientPolicy: ALLOW_ALL\n    isDefualt: true\n")
+	_, err := NewStorer(ctx, path)
+	if err == nil {
+		t.Error("Expected error loading file with unknown field, got nil")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "scopes.yml")
+	writeFile(t, path, yamlScopes)
+	storer, err := NewStorer(ctx, path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading scopes: %s", err)
+	}
+
+	reloaded, err := storer.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error reloading unchanged file: %s", err)
+	}
+	if reloaded {
+		t.Error("Expected unchanged file not to be reloaded")
+	}
+
+	// an invalid change is rejected, and the old scopes are still served
+	writeFile(t, path, "scopes:\n  - id: a\n    userPolicy: NOPE\n    clientPolicy: ALLOW_ALL\n")
+	_, err = storer.Reload(ctx)
+	if err == nil {
+		t.Error("Expected error reloading invalid file, got nil")
+	}
+	all, err := storer.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected the 2 previously loaded scopes to still be served, got %+v", all)
+	}
+
+	writeFile(t, path, "scopes:\n  - id: https://scopes.impractical.co/file/c\n    userPolicy: DENY_ALL\n    clientPolicy: DENY_ALL\n")
+	storer.PollInterval = 10 * time.Millisecond
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		storer.Run(runCtx)
+		close(done)
+	}()
+	expected := map[string]scopes.Scope{
+		"https://scopes.impractical.co/file/c": {
+			ID:           "https://scopes.impractical.co/file/c",
+			UserPolicy:   scopes.PolicyDenyAll,
+			ClientPolicy: scopes.PolicyDenyAll,
+		},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := storer.GetMulti(ctx, []string{"https://scopes.impractical.co/file/a", "https://scopes.impractical.co/file/c"})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving scopes: %s", err)
+		}
+		if cmp.Equal(expected, got) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for reload, last saw %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestReloadSameSizeAndModTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "scopes.yaml")
+	writeFile(t, path, yamlScopes)
+	storer, err := NewStorer(ctx, path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading scopes: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error reading %s: %s", path, err)
+	}
+
+	// an edit that doesn't change the size of the file, made quickly enough
+	// that its modification time doesn't change either, is still a change
+	edited := strings.Replace(yamlScopes, "user-1", "user-2", 1)
+	writeFile(t, path, edited)
+	err = os.Chtimes(path, info.ModTime(), info.ModTime())
+	if err != nil {
+		t.Fatalf("Unexpected error setting modification time of %s: %s", path, err)
+	}
+	reloaded, err := storer.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error reloading scopes: %s", err)
+	}
+	if !reloaded {
+		t.Error("Expected edited file to be reloaded")
+	}
+	byUser, err := storer.ListByUserException(ctx, "user-2")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by user exception: %s", err)
+	}
+	if len(byUser) != 1 {
+		t.Errorf("Expected 1 scope listing user-2 as an exception, got %+v", byUser)
+	}
+}
+
+func TestRejectsWrites(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "scopes.yaml")
+	writeFile(t, path, yamlScopes)
+	storer, err := NewStorer(ctx, path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading scopes: %s", err)
+	}
+
+	allow := scopes.PolicyAllowAll
+	errs := map[string]error{
+		"create": storer.Create(ctx, scopes.Scope{ID: "new", UserPolicy: allow, ClientPolicy: allow}),
+	}
+	_, errs["update"] = storer.Update(ctx, "https://scopes.impractical.co/file/a", scopes.Change{UserPolicy: &allow})
+	_, errs["delete"] = storer.Delete(ctx, "https://scopes.impractical.co/file/a")
+	_, errs["put"] = storer.Put(ctx, scopes.Scope{ID: "new", UserPolicy: allow, ClientPolicy: allow})
+	_, errs["remove user exception"] = storer.RemoveUserException(ctx, "user-1")
+	_, errs["remove client exception"] = storer.RemoveClientException(ctx, "client-1")
+	for method, err := range errs {
+		var readOnly ReadOnlyError
+		if !errors.As(err, &readOnly) {
+			t.Errorf("%s: expected ReadOnlyError, got %v", method, err)
+			continue
+		}
+		if readOnly.Method != method {
+			t.Errorf("Expected ReadOnlyError for %s, got %s", method, readOnly.Method)
+		}
+	}
+
+	// modifying results doesn't modify what the Storer serves
+	results, err := storer.GetMulti(ctx, []string{"https://scopes.impractical.co/file/b"})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err)
+	}
+	results["https://scopes.impractical.co/file/b"].UserExceptions[0] = "someone-else"
+	byUser, err := storer.ListByUserException(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by user exception: %s", err)
+	}
+	if len(byUser) != 1 {
+		t.Errorf("Expected 1 scope listing user-1 as an exception, got %+v", byUser)
+	}
+}
@@ -0,0 +1,148 @@
+// Package migrations applies and reports on the SQL migrations the SQL Storers
+// embed, so each Storer only has to supply its migrations and its dialect.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"lockbox.dev/scopes"
+)
+
+// Direction describes whether migrations should be applied or rolled back.
+type Direction int
+
+const (
+	// Up applies migrations.
+	Up Direction = iota
+	// Down rolls migrations back.
+	Down
+)
+
+func (d Direction) migrate() migrate.MigrationDirection {
+	if d == Down {
+		return migrate.Down
+	}
+	return migrate.Up
+}
+
+// Status describes a single migration and whether it has been applied to a
+// database.
+type Status struct {
+	ID        string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Set is the migrations for a single SQL dialect. Files holds the migrations
+// in the directory named by Dir, and Dialect is the sql-migrate dialect they
+// are written for.
+type Set struct {
+	Files   fs.FS
+	Dir     string
+	Dialect string
+}
+
+func (s Set) source() (migrate.MigrationSource, error) { //nolint:ireturn // sql-migrate only accepts the interface
+	sub, err := fs.Sub(s.Files, s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening migrations: %w", err)
+	}
+	return migrate.HttpFileSystemMigrationSource{FileSystem: http.FS(sub)}, nil
+}
+
+// Migrate applies all the migrations that haven't been applied to `db` yet if
+// `direction` is Up, or rolls back all the migrations that have been applied
+// to `db` if `direction` is Down. It returns the number of migrations applied
+// or rolled back. Each migration runs in its own transaction; `ctx` is only
+// checked before migrations start, as a migration is not safe to interrupt.
+func (s Set) Migrate(ctx context.Context, db *sql.DB, direction Direction) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("error migrating: %w", err)
+	}
+	source, err := s.source()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := migrate.Exec(db, s.Dialect, source, direction.migrate())
+	if err != nil {
+		return applied, fmt.Errorf("error migrating: %w", err)
+	}
+	return applied, nil
+}
+
+// Migrations returns the status of every migration in the Set, sorted in the
+// order they are applied in, noting which have been applied to `db`.
+func (s Set) Migrations(ctx context.Context, db *sql.DB) ([]Status, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving migrations: %w", err)
+	}
+	source, err := s.source()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := source.FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("error finding migrations: %w", err)
+	}
+	records, err := migrate.GetMigrationRecords(db, s.Dialect)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving applied migrations: %w", err)
+	}
+	applied := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		applied[record.Id] = record.AppliedAt
+	}
+	results := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		at, ok := applied[migration.Id]
+		results = append(results, Status{
+			ID:        migration.Id,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return results, nil
+}
+
+// Pending returns the IDs of the migrations that have not been applied to
+// `db` yet, in the order they will be applied in.
+func (s Set) Pending(ctx context.Context, db *sql.DB) ([]string, error) {
+	migrations, err := s.Migrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, migration := range migrations {
+		if migration.Applied {
+			continue
+		}
+		pending = append(pending, migration.ID)
+	}
+	return pending, nil
+}
+
+// CheckHealth returns an error if `db` can't be reached or has migrations
+// that haven't been applied yet, in which case the error wraps
+// scopes.ErrPendingMigrations.
+func (s Set) CheckHealth(ctx context.Context, db *sql.DB) error {
+	err := db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	pending, err := s.Pending(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", scopes.ErrPendingMigrations, strings.Join(pending, ", "))
+	}
+	return nil
+}
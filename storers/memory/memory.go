@@ -3,12 +3,21 @@ package memory
 import (
 	"context"
 	"fmt"
+	"time"
 
 	memdb "github.com/hashicorp/go-memdb"
+	uuid "github.com/hashicorp/go-uuid"
+	"yall.in"
 
 	"lockbox.dev/scopes"
 )
 
+const (
+	// maxEvents is the number of Events the Storer retains for Watch
+	// callers to catch up on.
+	maxEvents = 1024
+)
+
 var (
 	schema = &memdb.DBSchema{
 		Tables: map[string]*memdb.TableSchema{
@@ -20,16 +29,57 @@ var (
 						Unique:  true,
 						Indexer: &memdb.StringFieldIndex{Field: "ID", Lowercase: true},
 					},
+					"user_exception": {
+						Name:         "user_exception",
+						AllowMissing: true,
+						Indexer:      &memdb.StringSliceFieldIndex{Field: "UserExceptions"},
+					},
+					"client_exception": {
+						Name:         "client_exception",
+						AllowMissing: true,
+						Indexer:      &memdb.StringSliceFieldIndex{Field: "ClientExceptions"},
+					},
+				},
+			},
+			"audit": {
+				Name: "audit",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.UintFieldIndex{Field: "Sequence"},
+					},
+				},
+			},
+			"event": {
+				Name: "event",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.UintFieldIndex{Field: "Revision"},
+					},
 				},
 			},
 		},
 	}
 )
 
+// auditRecord is an AuditEntry as stored in the Storer, with a Sequence to
+// keep entries in the order they were recorded in.
+type auditRecord struct {
+	Sequence uint64
+	Entry    scopes.AuditEntry
+}
+
 // Storer is an in-memory implementation of the Storer
 // interface.
 type Storer struct {
 	db *memdb.MemDB
+
+	// tx is the transaction all operations happen in, for Storers
+	// passed to WithTx callbacks.
+	tx *memdb.Txn
 }
 
 // NewStorer returns a Storer instance that is ready
@@ -44,12 +94,57 @@ func NewStorer() (*Storer, error) {
 	}, nil
 }
 
+// txn returns the transaction an operation should happen in. Operations on
+// Storers passed to WithTx callbacks happen in the callback's transaction,
+// and must not commit or abort it.
+func (s *Storer) txn(write bool) *memdb.Txn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db.Txn(write)
+}
+
+// commit commits `txn` unless it belongs to a WithTx callback.
+func (s *Storer) commit(txn *memdb.Txn) {
+	if s.tx == nil {
+		txn.Commit()
+	}
+}
+
+// abort aborts `txn` unless it belongs to a WithTx callback.
+func (s *Storer) abort(txn *memdb.Txn) {
+	if s.tx == nil {
+		txn.Abort()
+	}
+}
+
+// WithTx calls `fn` with a Storer whose operations all happen in a single
+// memdb write transaction, committing it only if `fn` returns nil. Only one
+// write transaction can be open at a time, so `fn` must not make changes
+// through the Storer WithTx was called on, or it will deadlock.
+func (s *Storer) WithTx(ctx context.Context, fn func(ctx context.Context, tx scopes.Storer) error) error {
+	if s.tx != nil {
+		return fn(ctx, s)
+	}
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	err := fn(ctx, &Storer{db: s.db, tx: txn})
+	if err != nil {
+		return err
+	}
+	txn.Commit()
+	return nil
+}
+
 // Create inserts the passed Scope into the Storer,
 // returning an ErrScopeAlreadyExists error if a Scope
 // with the same ID already exists in the Storer.
 func (s *Storer) Create(_ context.Context, scope scopes.Scope) error {
-	txn := s.db.Txn(true)
-	defer txn.Abort()
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return err
+	}
+	txn := s.txn(true)
+	defer s.abort(txn)
 	exists, err := txn.First("scope", "id", scope.ID)
 	if err != nil {
 		return fmt.Errorf("error retrieving scope: %w", err)
@@ -61,18 +156,55 @@ func (s *Storer) Create(_ context.Context, scope scopes.Scope) error {
 	if err != nil {
 		return fmt.Errorf("error inserting scope: %w", err)
 	}
-	txn.Commit()
+	err = recordEvent(txn, scopes.Event{Type: scopes.EventCreated, Scope: scope})
+	if err != nil {
+		return err
+	}
+	s.commit(txn)
 	return nil
 }
 
+// Put inserts the passed Scope into the Storer, replacing any Scope with the
+// same ID, and returns true if no Scope with that ID existed.
+func (s *Storer) Put(_ context.Context, scope scopes.Scope) (bool, error) {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return false, err
+	}
+	txn := s.txn(true)
+	defer s.abort(txn)
+	exists, err := txn.First("scope", "id", scope.ID)
+	if err != nil {
+		return false, fmt.Errorf("error retrieving scope: %w", err)
+	}
+	event := scopes.Event{Type: scopes.EventCreated, Scope: scope}
+	if exists != nil {
+		previous, ok := exists.(*scopes.Scope)
+		if !ok || previous == nil {
+			return false, fmt.Errorf("unexpected response type %T (%v)", exists, exists) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		event.Type = scopes.EventUpdated
+		event.Previous = previous
+	}
+	err = txn.Insert("scope", &scope)
+	if err != nil {
+		return false, fmt.Errorf("error inserting scope: %w", err)
+	}
+	err = recordEvent(txn, event)
+	if err != nil {
+		return false, err
+	}
+	s.commit(txn)
+	return exists == nil, nil
+}
+
 // GetMulti retrieves the Scopes specified by the passed IDs
 // from the Storer, returning an empty map if no matching
 // Scopes are found. If a Scope is not found, no error will
 // be returned, it will just be omitted from the map.
 func (s *Storer) GetMulti(_ context.Context, ids []string) (map[string]scopes.Scope, error) {
 	results := map[string]scopes.Scope{}
+	txn := s.txn(false)
 	for _, id := range ids {
-		txn := s.db.Txn(false)
 		res, err := txn.First("scope", "id", id)
 		if err != nil {
 			return results, fmt.Errorf("error retrieving scope %s: %w", id, err)
@@ -89,57 +221,76 @@ func (s *Storer) GetMulti(_ context.Context, ids []string) (map[string]scopes.Sc
 	return results, nil
 }
 
-// Update applies the passed Change to the Scope that matches
-// the specified ID in the Storer, if any Scope matches the
-// specified ID in the Storer.
-func (s *Storer) Update(_ context.Context, id string, change scopes.Change) error {
-	txn := s.db.Txn(true)
-	defer txn.Abort()
+// Update applies the passed Change to the Scope that matches the specified ID
+// in the Storer and returns the updated Scope. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Update(_ context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	if err := scopes.ValidateChangeIDs(change); err != nil {
+		return scopes.Scope{}, err
+	}
+	txn := s.txn(true)
+	defer s.abort(txn)
 	scope, err := txn.First("scope", "id", id)
 	if err != nil {
-		return fmt.Errorf("error retrieving scope: %w", err)
+		return scopes.Scope{}, fmt.Errorf("error retrieving scope: %w", err)
 	}
 	if scope == nil {
-		return nil
+		return scopes.Scope{}, scopes.ErrScopeNotFound
 	}
 	newScope, ok := scope.(*scopes.Scope)
 	if !ok || newScope == nil {
-		return fmt.Errorf("unexpected response type %T (%v)", scope, scope) //nolint:goerr113 // not going to be handled, for debug only
+		return scopes.Scope{}, fmt.Errorf("unexpected response type %T (%v)", scope, scope) //nolint:goerr113 // not going to be handled, for debug only
 	}
 	updated := scopes.Apply(change, *newScope)
+	if change.IsEmpty() {
+		return updated, nil
+	}
 	err = txn.Insert("scope", &updated)
 	if err != nil {
-		return fmt.Errorf("error writing scope: %w", err)
+		return scopes.Scope{}, fmt.Errorf("error writing scope: %w", err)
 	}
-	txn.Commit()
-	return nil
+	previous := *newScope
+	err = recordEvent(txn, scopes.Event{Type: scopes.EventUpdated, Scope: updated, Previous: &previous})
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	s.commit(txn)
+	return updated, nil
 }
 
-// Delete removes the Scope that matches the specified ID from
-// the Storer, if any Scope matches the specified ID in the
-// Storer.
-func (s *Storer) Delete(_ context.Context, id string) error {
-	txn := s.db.Txn(true)
-	defer txn.Abort()
+// Delete removes the Scope that matches the specified ID from the Storer and
+// returns the Scope as it was before it was deleted. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Delete(_ context.Context, id string) (scopes.Scope, error) {
+	txn := s.txn(true)
+	defer s.abort(txn)
 	exists, err := txn.First("scope", "id", id)
 	if err != nil {
-		return fmt.Errorf("error retrieving scope: %w", err)
+		return scopes.Scope{}, fmt.Errorf("error retrieving scope: %w", err)
 	}
 	if exists == nil {
-		return nil
+		return scopes.Scope{}, scopes.ErrScopeNotFound
 	}
 	err = txn.Delete("scope", exists)
 	if err != nil {
-		return fmt.Errorf("error deleting scope: %w", err)
+		return scopes.Scope{}, fmt.Errorf("error deleting scope: %w", err)
 	}
-	txn.Commit()
-	return nil
+	deleted, ok := exists.(*scopes.Scope)
+	if !ok || deleted == nil {
+		return scopes.Scope{}, fmt.Errorf("unexpected response type %T (%v)", exists, exists) //nolint:goerr113 // not going to be handled, for debug only
+	}
+	err = recordEvent(txn, scopes.Event{Type: scopes.EventDeleted, Scope: *deleted})
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	s.commit(txn)
+	return *deleted, nil
 }
 
 // ListDefault returns all the Scopes with IsDefault set to true.
 // sorted lexicographically by their ID.
 func (s *Storer) ListDefault(_ context.Context) ([]scopes.Scope, error) {
-	txn := s.db.Txn(false)
+	txn := s.txn(false)
 	var results []scopes.Scope
 	acctIter, err := txn.Get("scope", "id")
 	if err != nil {
@@ -162,3 +313,305 @@ func (s *Storer) ListDefault(_ context.Context) ([]scopes.Scope, error) {
 	scopes.ByID(results)
 	return results, nil
 }
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`.
+func (s *Storer) List(_ context.Context, after string, limit int) ([]scopes.Scope, error) {
+	txn := s.txn(false)
+	iter, err := txn.Get("scope", "id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes: %w", err)
+	}
+	var results []scopes.Scope
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		scope, ok := next.(*scopes.Scope)
+		if !ok || scope == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		// the index is case-insensitive, so we can't rely on its
+		// order to match the order of the IDs
+		if after != "" && scope.ID <= after {
+			continue
+		}
+		results = append(results, *scope)
+	}
+	scopes.ByID(results)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// listByIndex returns all the Scopes that have `value` in the index named
+// `index`, sorted lexicographically by their ID.
+func (s *Storer) listByIndex(index, value string) ([]scopes.Scope, error) {
+	txn := s.txn(false)
+	iter, err := txn.Get("scope", index, value)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes: %w", err)
+	}
+	var results []scopes.Scope
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		scope, ok := next.(*scopes.Scope)
+		if !ok || scope == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		results = append(results, *scope)
+	}
+	scopes.ByID(results)
+	return results, nil
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByUserException(_ context.Context, userID string) ([]scopes.Scope, error) {
+	return s.listByIndex("user_exception", userID)
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByClientException(_ context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.listByIndex("client_exception", clientID)
+}
+
+// removeException removes `principalID` from the exceptions of every Scope
+// that lists it in the index named `index`, recording an AuditEntry with
+// `action`.
+func (s *Storer) removeException(index, action, principalID string) ([]scopes.Scope, error) {
+	txn := s.txn(true)
+	defer s.abort(txn)
+	iter, err := txn.Get("scope", index, principalID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scopes: %w", err)
+	}
+	var affected []*scopes.Scope
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		scope, ok := next.(*scopes.Scope)
+		if !ok || scope == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		affected = append(affected, scope)
+	}
+	results := make([]scopes.Scope, 0, len(affected))
+	for _, previous := range affected {
+		updated := *previous
+		if index == "user_exception" {
+			updated.UserExceptions, _ = scopes.WithoutException(previous.UserExceptions, principalID)
+		} else {
+			updated.ClientExceptions, _ = scopes.WithoutException(previous.ClientExceptions, principalID)
+		}
+		err = txn.Insert("scope", &updated)
+		if err != nil {
+			return nil, fmt.Errorf("error writing scope: %w", err)
+		}
+		prev := *previous
+		err = recordEvent(txn, scopes.Event{Type: scopes.EventUpdated, Scope: updated, Previous: &prev})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, updated)
+	}
+	scopes.ByID(results)
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating audit entry ID: %w", err)
+	}
+	entry := scopes.AuditEntry{
+		ID:          id,
+		Action:      action,
+		PrincipalID: principalID,
+		ScopeIDs:    make([]string, 0, len(results)),
+		CreatedAt:   time.Now(),
+	}
+	for _, scope := range results {
+		entry.ScopeIDs = append(entry.ScopeIDs, scope.ID)
+	}
+	var sequence uint64 = 1
+	last, err := txn.Last("audit", "id")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving last audit entry: %w", err)
+	}
+	if record, ok := last.(*auditRecord); ok && record != nil {
+		sequence = record.Sequence + 1
+	}
+	err = txn.Insert("audit", &auditRecord{Sequence: sequence, Entry: entry})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting audit entry: %w", err)
+	}
+	s.commit(txn)
+	return results, nil
+}
+
+// RemoveUserException removes `userID` from the UserExceptions of every
+// Scope in the Storer, recording a single AuditEntry, and returns the Scopes
+// that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveUserException(_ context.Context, userID string) ([]scopes.Scope, error) {
+	return s.removeException("user_exception", scopes.AuditRemoveUserException, userID)
+}
+
+// RemoveClientException removes `clientID` from the ClientExceptions of
+// every Scope in the Storer, recording a single AuditEntry, and returns the
+// Scopes that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveClientException(_ context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.removeException("client_exception", scopes.AuditRemoveClientException, clientID)
+}
+
+// ListAuditEntries returns every AuditEntry the Storer has recorded, oldest
+// first.
+func (s *Storer) ListAuditEntries(_ context.Context) ([]scopes.AuditEntry, error) {
+	txn := s.txn(false)
+	iter, err := txn.Get("audit", "id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing audit entries: %w", err)
+	}
+	var results []scopes.AuditEntry
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		record, ok := next.(*auditRecord)
+		if !ok || record == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		results = append(results, record.Entry)
+	}
+	return results, nil
+}
+
+// lastEvent returns the most recent Event recorded in `txn`, or nil if no
+// Events have been recorded.
+func lastEvent(txn *memdb.Txn) (*scopes.Event, error) {
+	last, err := txn.Last("event", "id")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving last event: %w", err)
+	}
+	if last == nil {
+		return nil, nil
+	}
+	event, ok := last.(*scopes.Event)
+	if !ok || event == nil {
+		return nil, fmt.Errorf("unexpected response type %T (%v)", last, last) //nolint:goerr113 // not going to be handled, for debug only
+	}
+	return event, nil
+}
+
+// recordEvent assigns `event` the next Revision and records it as part of
+// `txn`, discarding the oldest Event if more than maxEvents are retained.
+func recordEvent(txn *memdb.Txn, event scopes.Event) error {
+	last, err := lastEvent(txn)
+	if err != nil {
+		return err
+	}
+	var revision uint64 = 1
+	if last != nil {
+		revision = last.Revision + 1
+	}
+	event.Revision = revision
+	err = txn.Insert("event", &event)
+	if err != nil {
+		return fmt.Errorf("error inserting event: %w", err)
+	}
+	if revision <= maxEvents {
+		return nil
+	}
+	_, err = txn.DeleteAll("event", "id", revision-maxEvents)
+	if err != nil {
+		return fmt.Errorf("error compacting events: %w", err)
+	}
+	return nil
+}
+
+// CurrentRevision returns the Revision of the most recent Event recorded by
+// the Storer, or 0 if no Events have been recorded.
+func (s *Storer) CurrentRevision(_ context.Context) (uint64, error) {
+	txn := s.db.Txn(false)
+	last, err := lastEvent(txn)
+	if err != nil {
+		return 0, err
+	}
+	if last == nil {
+		return 0, nil
+	}
+	return last.Revision, nil
+}
+
+// oldestRevision returns the Revision of the oldest Event retained by the
+// Storer, or 0 if no Events are retained.
+func oldestRevision(txn *memdb.Txn) (uint64, error) {
+	first, err := txn.First("event", "id")
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving first event: %w", err)
+	}
+	if first == nil {
+		return 0, nil
+	}
+	event, ok := first.(*scopes.Event)
+	if !ok || event == nil {
+		return 0, fmt.Errorf("unexpected response type %T (%v)", first, first) //nolint:goerr113 // not going to be handled, for debug only
+	}
+	return event.Revision, nil
+}
+
+// Watch returns a channel that receives every Event recorded by the Storer
+// with a Revision greater than `fromRevision`, followed by new Events as they
+// are recorded. The channel is closed when `ctx` is canceled. Only the most
+// recent Events are retained; if Events after `fromRevision` have been
+// discarded, ErrRevisionCompacted is returned.
+func (s *Storer) Watch(ctx context.Context, fromRevision uint64) (<-chan scopes.Event, error) {
+	oldest, err := oldestRevision(s.db.Txn(false))
+	if err != nil {
+		return nil, err
+	}
+	if oldest > fromRevision+1 {
+		return nil, scopes.ErrRevisionCompacted
+	}
+	events := make(chan scopes.Event)
+	go s.watch(ctx, fromRevision, events)
+	return events, nil
+}
+
+func (s *Storer) watch(ctx context.Context, last uint64, events chan<- scopes.Event) {
+	defer close(events)
+	log := yall.FromContext(ctx)
+	for {
+		txn := s.db.Txn(false)
+		oldest, err := oldestRevision(txn)
+		if err != nil {
+			log.WithError(err).Error("error watching events")
+			return
+		}
+		if oldest > last+1 {
+			log.WithField("revision", last).Warn("watcher fell behind retained events")
+			return
+		}
+		// watch the entire table, because new events will always
+		// be after our lower bound
+		all, err := txn.Get("event", "id")
+		if err != nil {
+			log.WithError(err).Error("error watching events")
+			return
+		}
+		ws := memdb.NewWatchSet()
+		ws.Add(all.WatchCh())
+
+		iter, err := txn.LowerBound("event", "id", last+1)
+		if err != nil {
+			log.WithError(err).Error("error listing events")
+			return
+		}
+		for next := iter.Next(); next != nil; next = iter.Next() {
+			event, ok := next.(*scopes.Event)
+			if !ok || event == nil {
+				log.WithField("type", fmt.Sprintf("%T", next)).Error("unexpected response type")
+				return
+			}
+			select {
+			case events <- *event:
+				last = event.Revision
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := ws.WatchCtx(ctx); err != nil {
+			return
+		}
+	}
+}
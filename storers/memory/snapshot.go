@@ -0,0 +1,284 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot format written by
+	// WriteSnapshot. NewStorerFromSnapshot reads snapshots of this version
+	// and earlier.
+	SnapshotVersion = 1
+)
+
+// UnsupportedSnapshotVersionError is returned when reading a snapshot written
+// in a format this version of the package doesn't understand.
+type UnsupportedSnapshotVersionError struct {
+	Version int
+}
+
+func (e UnsupportedSnapshotVersionError) Error() string {
+	return fmt.Sprintf("unsupported snapshot version %d, expected %d or earlier", e.Version, SnapshotVersion)
+}
+
+// snapshot is the serialized form of everything in a Storer. Its types are
+// converted to and from the ones stored in memdb field by field, so fields
+// added to those don't change the format until they're added here.
+type snapshot struct {
+	Version      int                  `json:"version"`
+	Scopes       []snapshotScope      `json:"scopes"`
+	Events       []snapshotEvent      `json:"events"`
+	AuditEntries []snapshotAuditEntry `json:"auditEntries"`
+}
+
+type snapshotScope struct {
+	ID               string   `json:"id"`
+	UserPolicy       string   `json:"userPolicy"`
+	UserExceptions   []string `json:"userExceptions"`
+	ClientPolicy     string   `json:"clientPolicy"`
+	ClientExceptions []string `json:"clientExceptions"`
+	IsDefault        bool     `json:"isDefault"`
+}
+
+type snapshotEvent struct {
+	Revision uint64         `json:"revision"`
+	Type     string         `json:"type"`
+	Scope    snapshotScope  `json:"scope"`
+	Previous *snapshotScope `json:"previous,omitempty"`
+}
+
+type snapshotAuditEntry struct {
+	ID          string    `json:"id"`
+	Action      string    `json:"action"`
+	PrincipalID string    `json:"principalID"`
+	ScopeIDs    []string  `json:"scopeIDs"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func toSnapshotScope(scope scopes.Scope) snapshotScope {
+	return snapshotScope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func fromSnapshotScope(scope snapshotScope) scopes.Scope {
+	return scopes.Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func toSnapshotAuditEntry(entry scopes.AuditEntry) snapshotAuditEntry {
+	return snapshotAuditEntry{
+		ID:          entry.ID,
+		Action:      entry.Action,
+		PrincipalID: entry.PrincipalID,
+		ScopeIDs:    entry.ScopeIDs,
+		CreatedAt:   entry.CreatedAt,
+	}
+}
+
+func fromSnapshotAuditEntry(entry snapshotAuditEntry) scopes.AuditEntry {
+	return scopes.AuditEntry{
+		ID:          entry.ID,
+		Action:      entry.Action,
+		PrincipalID: entry.PrincipalID,
+		ScopeIDs:    entry.ScopeIDs,
+		CreatedAt:   entry.CreatedAt,
+	}
+}
+
+// WriteSnapshot writes everything in the Storer, including the Events
+// retained for Watch callers and the recorded AuditEntries, to `w` as a
+// versioned JSON document that NewStorerFromSnapshot can load. The snapshot
+// is consistent, as it's taken in a single read transaction.
+func (s *Storer) WriteSnapshot(_ context.Context, w io.Writer) error {
+	txn := s.txn(false)
+	snap := snapshot{
+		Version:      SnapshotVersion,
+		Scopes:       []snapshotScope{},
+		Events:       []snapshotEvent{},
+		AuditEntries: []snapshotAuditEntry{},
+	}
+
+	iter, err := txn.Get("scope", "id")
+	if err != nil {
+		return fmt.Errorf("error listing scopes: %w", err)
+	}
+	var all []scopes.Scope
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		scope, ok := next.(*scopes.Scope)
+		if !ok || scope == nil {
+			return fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		all = append(all, *scope)
+	}
+	scopes.ByID(all)
+	for _, scope := range all {
+		snap.Scopes = append(snap.Scopes, toSnapshotScope(scope))
+	}
+
+	iter, err = txn.Get("event", "id")
+	if err != nil {
+		return fmt.Errorf("error listing events: %w", err)
+	}
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		event, ok := next.(*scopes.Event)
+		if !ok || event == nil {
+			return fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		snapEvent := snapshotEvent{
+			Revision: event.Revision,
+			Type:     string(event.Type),
+			Scope:    toSnapshotScope(event.Scope),
+		}
+		if event.Previous != nil {
+			previous := toSnapshotScope(*event.Previous)
+			snapEvent.Previous = &previous
+		}
+		snap.Events = append(snap.Events, snapEvent)
+	}
+
+	iter, err = txn.Get("audit", "id")
+	if err != nil {
+		return fmt.Errorf("error listing audit entries: %w", err)
+	}
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		record, ok := next.(*auditRecord)
+		if !ok || record == nil {
+			return fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		snap.AuditEntries = append(snap.AuditEntries, toSnapshotAuditEntry(record.Entry))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(snap)
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	return nil
+}
+
+// WriteSnapshotFile writes a snapshot of the Storer to the file at `path`,
+// using WriteSnapshot. The snapshot is written to a temporary file in the
+// same directory first, and moved into place once it's complete, so `path`
+// always holds a complete snapshot even if writing one fails.
+func (s *Storer) WriteSnapshotFile(ctx context.Context, path string) (retErr error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary snapshot file: %w", err)
+	}
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		tmp.Close()           //nolint:errcheck,gosec // already failing, nothing else to do
+		os.Remove(tmp.Name()) //nolint:errcheck,gosec // already failing, nothing else to do
+	}()
+	err = s.WriteSnapshot(ctx, tmp)
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return fmt.Errorf("error syncing snapshot file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("error closing snapshot file: %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error moving snapshot file into place: %w", err)
+	}
+	return nil
+}
+
+// NewStorerFromSnapshot returns a Storer instance loaded with the contents of
+// the snapshot read from `r`, which must have been written by WriteSnapshot.
+// Events keep their Revisions, so Watch callers can resume from where they
+// left off. The returned Storer instance is ready to be used as a Storer.
+func NewStorerFromSnapshot(_ context.Context, r io.Reader) (*Storer, error) {
+	var snap snapshot
+	err := json.NewDecoder(r).Decode(&snap)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding snapshot: %w", err)
+	}
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return nil, UnsupportedSnapshotVersionError{Version: snap.Version}
+	}
+
+	storer, err := NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	txn := storer.db.Txn(true)
+	defer txn.Abort()
+	err = restore(txn, snap)
+	if err != nil {
+		return nil, err
+	}
+	txn.Commit()
+	return storer, nil
+}
+
+// restore inserts everything in `snap` as part of `txn`.
+func restore(txn *memdb.Txn, snap snapshot) error {
+	for _, snapScope := range snap.Scopes {
+		scope := fromSnapshotScope(snapScope)
+		exists, err := txn.First("scope", "id", scope.ID)
+		if err != nil {
+			return fmt.Errorf("error retrieving scope: %w", err)
+		}
+		if exists != nil {
+			return fmt.Errorf("error restoring scope %s: %w", scope.ID, scopes.ErrScopeAlreadyExists)
+		}
+		err = txn.Insert("scope", &scope)
+		if err != nil {
+			return fmt.Errorf("error inserting scope: %w", err)
+		}
+	}
+	for _, snapEvent := range snap.Events {
+		event := scopes.Event{
+			Revision: snapEvent.Revision,
+			Type:     scopes.EventType(snapEvent.Type),
+			Scope:    fromSnapshotScope(snapEvent.Scope),
+		}
+		if snapEvent.Previous != nil {
+			previous := fromSnapshotScope(*snapEvent.Previous)
+			event.Previous = &previous
+		}
+		err := txn.Insert("event", &event)
+		if err != nil {
+			return fmt.Errorf("error inserting event: %w", err)
+		}
+	}
+	for pos, entry := range snap.AuditEntries {
+		err := txn.Insert("audit", &auditRecord{Sequence: uint64(pos + 1), Entry: fromSnapshotAuditEntry(entry)})
+		if err != nil {
+			return fmt.Errorf("error inserting audit entry: %w", err)
+		}
+	}
+	return nil
+}
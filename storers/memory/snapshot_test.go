@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storer, err := NewStorer()
+	if err != nil {
+		t.Fatalf("Unexpected error creating storer: %s", err)
+	}
+	created := []scopes.Scope{
+		{
+			ID:               "https://scopes.impractical.co/snapshot/b",
+			UserPolicy:       scopes.PolicyDefaultDeny,
+			UserExceptions:   []string{"user-1", "user-2"},
+			ClientPolicy:     scopes.PolicyAllowAll,
+			ClientExceptions: []string{},
+			IsDefault:        true,
+		},
+		{
+			ID:           "https://scopes.impractical.co/snapshot/a",
+			UserPolicy:   scopes.PolicyAllowAll,
+			ClientPolicy: scopes.PolicyDefaultAllow,
+		},
+	}
+	for _, scope := range created {
+		err = storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err)
+		}
+	}
+	_, err = storer.RemoveUserException(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("Unexpected error removing user exception: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	err = storer.WriteSnapshotFile(ctx, path)
+	if err != nil {
+		t.Fatalf("Unexpected error writing snapshot: %s", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening snapshot: %s", err)
+	}
+	defer file.Close()
+	restored, err := NewStorerFromSnapshot(ctx, file)
+	if err != nil {
+		t.Fatalf("Unexpected error restoring snapshot: %s", err)
+	}
+
+	wantScopes, err := storer.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err)
+	}
+	gotScopes, err := restored.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error listing restored scopes: %s", err)
+	}
+	if diff := cmp.Diff(wantScopes, gotScopes); diff != "" {
+		t.Errorf("Unexpected diff in restored scopes (-wanted, +got): %s", diff)
+	}
+
+	wantAudit, err := storer.ListAuditEntries(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing audit entries: %s", err)
+	}
+	gotAudit, err := restored.ListAuditEntries(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing restored audit entries: %s", err)
+	}
+	if diff := cmp.Diff(wantAudit, gotAudit); diff != "" {
+		t.Errorf("Unexpected diff in restored audit entries (-wanted, +got): %s", diff)
+	}
+
+	// revisions carry on from where the snapshot left off
+	wantRevision, err := storer.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving revision: %s", err)
+	}
+	gotRevision, err := restored.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving restored revision: %s", err)
+	}
+	if gotRevision != wantRevision {
+		t.Errorf("Expected restored revision to be %d, got %d", wantRevision, gotRevision)
+	}
+	_, err = restored.Delete(ctx, created[1].ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err)
+	}
+	events, err := restored.Watch(ctx, wantRevision)
+	if err != nil {
+		t.Fatalf("Unexpected error watching restored storer: %s", err)
+	}
+	event := <-events
+	if event.Revision != wantRevision+1 || event.Type != scopes.EventDeleted || event.Scope.ID != created[1].ID {
+		t.Errorf("Unexpected event after restoring: %+v", event)
+	}
+}
+
+func TestSnapshotRejectsUnknownVersion(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewStorerFromSnapshot(context.Background(), strings.NewReader(`{"version": 99, "scopes": []}`))
+	var unsupported UnsupportedSnapshotVersionError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected UnsupportedSnapshotVersionError, got %v", err)
+	}
+	if unsupported.Version != 99 {
+		t.Errorf("Expected unsupported version 99, got %d", unsupported.Version)
+	}
+}
+
+func TestSnapshotFixture(t *testing.T) {
+	t.Parallel()
+
+	// fixtures can be written by hand, leaving out events and audit
+	// entries
+	fixture := `{
+		"version": 1,
+		"scopes": [
+			{"id": "https://scopes.impractical.co/fixture", "userPolicy": "ALLOW_ALL", "clientPolicy": "DEFAULT_DENY", "clientExceptions": ["client-1"], "isDefault": true}
+		]
+	}`
+	ctx := context.Background()
+	storer, err := NewStorerFromSnapshot(ctx, strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("Unexpected error loading fixture: %s", err)
+	}
+	defaults, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing default scopes: %s", err)
+	}
+	expected := []scopes.Scope{{
+		ID:               "https://scopes.impractical.co/fixture",
+		UserPolicy:       scopes.PolicyAllowAll,
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{"client-1"},
+		IsDefault:        true,
+	}}
+	if diff := cmp.Diff(expected, defaults); diff != "" {
+		t.Errorf("Unexpected diff in default scopes (-wanted, +got): %s", diff)
+	}
+
+	var buf bytes.Buffer
+	err = storer.WriteSnapshot(ctx, &buf)
+	if err != nil {
+		t.Fatalf("Unexpected error writing snapshot: %s", err)
+	}
+	if !strings.Contains(buf.String(), `"version": 1`) {
+		t.Errorf("Expected snapshot to record its version, got %s", buf.String())
+	}
+}
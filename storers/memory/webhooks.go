@@ -0,0 +1,319 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+	uuid "github.com/hashicorp/go-uuid"
+
+	"lockbox.dev/scopes/webhooks"
+)
+
+var (
+	webhookSchema = &memdb.DBSchema{
+		Tables: map[string]*memdb.TableSchema{
+			"webhook": {
+				Name: "webhook",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+			"delivery": {
+				Name: "delivery",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+					"webhook": {
+						Name:    "webhook",
+						Indexer: &memdb.StringFieldIndex{Field: "WebhookID"},
+					},
+					"state": {
+						Name:    "state",
+						Indexer: &memdb.StringFieldIndex{Field: "State"},
+					},
+					"webhook_revision": {
+						Name:   "webhook_revision",
+						Unique: true,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "WebhookID"},
+								&memdb.UintFieldIndex{Field: "Revision"},
+							},
+						},
+					},
+				},
+			},
+			"cursor": {
+				Name: "cursor",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+		},
+	}
+)
+
+type cursor struct {
+	ID       string
+	Revision uint64
+}
+
+// WebhookStorer is an in-memory implementation of the webhooks.Storer
+// interface.
+type WebhookStorer struct {
+	db *memdb.MemDB
+}
+
+// NewWebhookStorer returns a WebhookStorer instance that is ready to be used
+// as a webhooks.Storer.
+func NewWebhookStorer() (*WebhookStorer, error) {
+	db, err := memdb.NewMemDB(webhookSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookStorer{
+		db: db,
+	}, nil
+}
+
+// CreateWebhook inserts the passed Webhook into the WebhookStorer, returning
+// an ErrWebhookAlreadyExists error if a Webhook with the same ID already
+// exists in the WebhookStorer.
+func (s *WebhookStorer) CreateWebhook(_ context.Context, webhook webhooks.Webhook) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	exists, err := txn.First("webhook", "id", webhook.ID)
+	if err != nil {
+		return fmt.Errorf("error retrieving webhook: %w", err)
+	}
+	if exists != nil {
+		return webhooks.ErrWebhookAlreadyExists
+	}
+	err = txn.Insert("webhook", &webhook)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook: %w", err)
+	}
+	txn.Commit()
+	return nil
+}
+
+// ListWebhooks returns all the Webhooks in the WebhookStorer, sorted by ID.
+func (s *WebhookStorer) ListWebhooks(_ context.Context) ([]webhooks.Webhook, error) {
+	txn := s.db.Txn(false)
+	iter, err := txn.Get("webhook", "id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+	var results []webhooks.Webhook
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		webhook, ok := next.(*webhooks.Webhook)
+		if !ok || webhook == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		results = append(results, *webhook)
+	}
+	return results, nil
+}
+
+// DeleteWebhook removes the Webhook that matches the specified ID, and all
+// its Deliveries, from the WebhookStorer, returning an ErrWebhookNotFound
+// error if no Webhook matches the specified ID.
+func (s *WebhookStorer) DeleteWebhook(_ context.Context, id string) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	exists, err := txn.First("webhook", "id", id)
+	if err != nil {
+		return fmt.Errorf("error retrieving webhook: %w", err)
+	}
+	if exists == nil {
+		return webhooks.ErrWebhookNotFound
+	}
+	err = txn.Delete("webhook", exists)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	_, err = txn.DeleteAll("delivery", "webhook", id)
+	if err != nil {
+		return fmt.Errorf("error deleting deliveries: %w", err)
+	}
+	txn.Commit()
+	return nil
+}
+
+func setCursor(txn *memdb.Txn, revision uint64) error {
+	err := txn.Insert("cursor", &cursor{ID: "cursor", Revision: revision})
+	if err != nil {
+		return fmt.Errorf("error setting cursor: %w", err)
+	}
+	return nil
+}
+
+// Enqueue atomically creates a pending Delivery of `body` to every Webhook in
+// the WebhookStorer and records `revision` as the cursor.
+func (s *WebhookStorer) Enqueue(_ context.Context, revision uint64, body []byte, at time.Time) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	iter, err := txn.Get("webhook", "id")
+	if err != nil {
+		return fmt.Errorf("error listing webhooks: %w", err)
+	}
+	var hooks []*webhooks.Webhook
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		webhook, ok := next.(*webhooks.Webhook)
+		if !ok || webhook == nil {
+			return fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		hooks = append(hooks, webhook)
+	}
+	for _, webhook := range hooks {
+		exists, err := txn.First("delivery", "webhook_revision", webhook.ID, revision)
+		if err != nil {
+			return fmt.Errorf("error retrieving delivery: %w", err)
+		}
+		if exists != nil {
+			continue
+		}
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return fmt.Errorf("error generating delivery ID: %w", err)
+		}
+		err = txn.Insert("delivery", &webhooks.Delivery{
+			ID:            id,
+			WebhookID:     webhook.ID,
+			Revision:      revision,
+			Body:          append([]byte(nil), body...),
+			State:         webhooks.DeliveryPending,
+			NextAttemptAt: at,
+			CreatedAt:     at,
+		})
+		if err != nil {
+			return fmt.Errorf("error inserting delivery: %w", err)
+		}
+	}
+	err = setCursor(txn, revision)
+	if err != nil {
+		return err
+	}
+	txn.Commit()
+	return nil
+}
+
+// Cursor returns the revision last recorded by Enqueue or SetCursor, or 0 if
+// none has been recorded.
+func (s *WebhookStorer) Cursor(_ context.Context) (uint64, error) {
+	txn := s.db.Txn(false)
+	res, err := txn.First("cursor", "id", "cursor")
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving cursor: %w", err)
+	}
+	if res == nil {
+		return 0, nil
+	}
+	cur, ok := res.(*cursor)
+	if !ok || cur == nil {
+		return 0, fmt.Errorf("unexpected response type %T (%v)", res, res) //nolint:goerr113 // not going to be handled, for debug only
+	}
+	return cur.Revision, nil
+}
+
+// SetCursor records `revision` as the cursor.
+func (s *WebhookStorer) SetCursor(_ context.Context, revision uint64) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	err := setCursor(txn, revision)
+	if err != nil {
+		return err
+	}
+	txn.Commit()
+	return nil
+}
+
+func (s *WebhookStorer) deliveriesInState(state string) ([]webhooks.Delivery, error) {
+	txn := s.db.Txn(false)
+	iter, err := txn.Get("delivery", "state", state)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deliveries: %w", err)
+	}
+	var results []webhooks.Delivery
+	for next := iter.Next(); next != nil; next = iter.Next() {
+		delivery, ok := next.(*webhooks.Delivery)
+		if !ok || delivery == nil {
+			return nil, fmt.Errorf("unexpected response type %T (%v)", next, next) //nolint:goerr113 // not going to be handled, for debug only
+		}
+		results = append(results, *delivery)
+	}
+	return results, nil
+}
+
+// DueDeliveries returns up to `limit` pending Deliveries that should be
+// attempted at or before `now`, oldest first.
+func (s *WebhookStorer) DueDeliveries(_ context.Context, now time.Time, limit int) ([]webhooks.Delivery, error) {
+	pending, err := s.deliveriesInState(webhooks.DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]webhooks.Delivery, 0, len(pending))
+	for _, delivery := range pending {
+		if delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		results = append(results, delivery)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].NextAttemptAt.Equal(results[j].NextAttemptAt) {
+			return results[i].NextAttemptAt.Before(results[j].NextAttemptAt)
+		}
+		return results[i].Revision < results[j].Revision
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// UpdateDelivery replaces the stored Delivery with the same ID as `delivery`,
+// if it still exists.
+func (s *WebhookStorer) UpdateDelivery(_ context.Context, delivery webhooks.Delivery) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	exists, err := txn.First("delivery", "id", delivery.ID)
+	if err != nil {
+		return fmt.Errorf("error retrieving delivery: %w", err)
+	}
+	if exists == nil {
+		return nil
+	}
+	err = txn.Insert("delivery", &delivery)
+	if err != nil {
+		return fmt.Errorf("error writing delivery: %w", err)
+	}
+	txn.Commit()
+	return nil
+}
+
+// ListDeadDeliveries returns all the Deliveries that will not be attempted
+// again, newest first.
+func (s *WebhookStorer) ListDeadDeliveries(_ context.Context) ([]webhooks.Delivery, error) {
+	results, err := s.deliveriesInState(webhooks.DeliveryDead)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Revision > results[j].Revision
+	})
+	return results, nil
+}
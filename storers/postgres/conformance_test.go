@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"lockbox.dev/scopes/storertest"
+)
+
+func TestConformance(t *testing.T) {
+	if os.Getenv(TestConnStringEnvVar) == "" {
+		t.Skip(TestConnStringEnvVar + " not set, skipping PostgreSQL tests")
+	}
+	t.Parallel()
+
+	control, err := sql.Open("postgres", os.Getenv(TestConnStringEnvVar))
+	if err != nil {
+		t.Fatalf("Error connecting to database: %s", err)
+	}
+	storertest.RunConformance(t, NewFactory(control))
+}
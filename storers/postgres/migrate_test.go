@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrationFilesHaveUpAndDown(t *testing.T) {
+	t.Parallel()
+
+	files, err := fs.Glob(migrationFiles, "sql/*.sql")
+	if err != nil {
+		t.Fatalf("Error listing migrations: %s", err)
+	}
+	if len(files) < 1 {
+		t.Fatal("Expected migrations to be embedded, found none")
+	}
+	for _, file := range files {
+		contents, err := fs.ReadFile(migrationFiles, file)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", file, err)
+		}
+		if !strings.Contains(string(contents), "-- +migrate Up") {
+			t.Errorf("%s has no Up section", file)
+		}
+		if !strings.Contains(string(contents), "-- +migrate Down") {
+			t.Errorf("%s has no Down section", file)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	if os.Getenv(TestConnStringEnvVar) == "" {
+		t.Skip(TestConnStringEnvVar + " not set, skipping PostgreSQL tests")
+	}
+	ctx := context.Background()
+
+	control, err := sql.Open("postgres", os.Getenv(TestConnStringEnvVar))
+	if err != nil {
+		t.Fatalf("Error connecting to database: %s", err)
+	}
+	factory := NewFactory(control)
+	defer func() {
+		if err := factory.TeardownStorers(); err != nil {
+			t.Errorf("Error cleaning up databases: %s", err)
+		}
+	}()
+	db, _, err := factory.newDatabase(ctx)
+	if err != nil {
+		t.Fatalf("Error creating database: %s", err)
+	}
+
+	all, err := Migrations(ctx, db)
+	if err != nil {
+		t.Fatalf("Error retrieving migrations: %s", err)
+	}
+	pending, err := PendingMigrations(ctx, db)
+	if err != nil {
+		t.Fatalf("Error retrieving pending migrations: %s", err)
+	}
+	if len(pending) != len(all) {
+		t.Fatalf("Expected all %d migrations to be pending on a new database, got %d: %v", len(all), len(pending), pending)
+	}
+
+	applied, err := Migrate(ctx, db, MigrateUp)
+	if err != nil {
+		t.Fatalf("Error migrating up: %s", err)
+	}
+	if applied != len(all) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(all), applied)
+	}
+	statuses, err := Migrations(ctx, db)
+	if err != nil {
+		t.Fatalf("Error retrieving migrations: %s", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.IsZero() {
+			t.Errorf("Expected %s to be applied, got %+v", status.ID, status)
+		}
+	}
+	pending, err = PendingMigrations(ctx, db)
+	if err != nil {
+		t.Fatalf("Error retrieving pending migrations: %s", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending migrations, got %v", pending)
+	}
+
+	// migrating up again is a no-op
+	applied, err = Migrate(ctx, db, MigrateUp)
+	if err != nil {
+		t.Fatalf("Error migrating up again: %s", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied, got %d", applied)
+	}
+
+	rolledBack, err := Migrate(ctx, db, MigrateDown)
+	if err != nil {
+		t.Fatalf("Error migrating down: %s", err)
+	}
+	if rolledBack != len(all) {
+		t.Errorf("Expected %d migrations to be rolled back, got %d", len(all), rolledBack)
+	}
+	pending, err = PendingMigrations(ctx, db)
+	if err != nil {
+		t.Fatalf("Error retrieving pending migrations: %s", err)
+	}
+	if len(pending) != len(all) {
+		t.Errorf("Expected all %d migrations to be pending after rolling back, got %v", len(all), pending)
+	}
+
+	// the down migrations must leave the database clean enough to
+	// migrate up again
+	applied, err = Migrate(ctx, db, MigrateUp)
+	if err != nil {
+		t.Fatalf("Error migrating up after rolling back: %s", err)
+	}
+	if applied != len(all) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(all), applied)
+	}
+}
+
+func TestMigrateCanceledContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Migrate(ctx, nil, MigrateUp)
+	if err == nil {
+		t.Error("Expected error migrating with a canceled context, got nil")
+	}
+}
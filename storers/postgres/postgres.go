@@ -5,29 +5,55 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
 	"darlinggo.co/pan"
-	"github.com/lib/pq"
 	"impractical.co/pqarrays"
 	"yall.in"
 
 	"lockbox.dev/scopes"
 )
 
-//go:generate go-bindata -pkg migrations -o migrations/generated.go sql/
-
 const (
 	// TestConnStringEnvVar is the environment variable to use when
 	// specifying a connection string for the database to run tests
 	// against. Tests will run in their own isolated databases, not in the
 	// default database the connection string is for.
 	TestConnStringEnvVar = "PG_TEST_DB"
+
+	// maxEvents is the number of Events the database retains for Watch
+	// callers to catch up on. It matches the number the
+	// scopes_record_event trigger keeps.
+	maxEvents = 1024
 )
 
 // Storer is an implementation of the Storer interface
 // that stores data in a PostgreSQL database.
+//
+// Every change to a Scope records an Event, using triggers
+// in the database, and takes a database-wide advisory lock
+// from the moment it does until its transaction ends, so
+// Revisions become visible in the order they're assigned and
+// Watch never skips an Event. As a result, changes to Scopes
+// are serialized across every instance using the database,
+// while reads are unaffected. A Scope's row must be written
+// before its exceptions, so its Event can record the
+// exceptions it had before the change.
 type Storer struct {
-	db *sql.DB
+	db         *sql.DB
+	connString string
+
+	// tx is the transaction all queries run in, for Storers passed to
+	// WithTx callbacks.
+	tx *sql.Tx
+}
+
+// querier is the subset of methods *sql.DB and *sql.Tx have in common that
+// the Storer uses to run queries.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // NewStorer returns a Storer instance that is backed by the specified
@@ -36,33 +62,351 @@ func NewStorer(_ context.Context, conn *sql.DB) *Storer {
 	return &Storer{db: conn}
 }
 
+// NewWatchingStorer returns a Storer instance that is backed by the specified
+// *sql.DB, and that supports Watch by opening dedicated connections to the
+// database specified by `connString` to LISTEN for changes. The returned
+// Storer instance is ready to be used as a Storer.
+func NewWatchingStorer(_ context.Context, conn *sql.DB, connString string) *Storer {
+	return &Storer{db: conn, connString: connString}
+}
+
+// conn returns the transaction queries should run in, if the Storer was
+// passed to a WithTx callback, or the database otherwise.
+func (s *Storer) conn() querier { //nolint:ireturn // returns one of two concrete types
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// Tx returns the transaction the Storer's queries run in, if the Storer was
+// passed to a WithTx callback, or nil otherwise. It can be used to make other
+// changes, like writing audit records, in the same transaction as changes to
+// Scopes.
+func (s *Storer) Tx() *sql.Tx {
+	return s.tx
+}
+
+// WithTx calls `fn` with a Storer whose queries all run in a single database
+// transaction, committing it only if `fn` returns nil. If `ctx` is canceled
+// before the transaction is committed, it is rolled back.
+func (s *Storer) WithTx(ctx context.Context, fn func(ctx context.Context, tx scopes.Storer) error) error {
+	return s.withTx(ctx, func(tx *Storer) error {
+		return fn(ctx, tx)
+	})
+}
+
+// withTx calls `fn` with a Storer whose queries all run in a single database
+// transaction, joining the Storer's transaction if it's already in one.
+func (s *Storer) withTx(ctx context.Context, fn func(tx *Storer) error) (retErr error) {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			yall.FromContext(ctx).WithError(err).Error("error rolling back transaction")
+		}
+	}()
+	err = fn(&Storer{db: s.db, connString: s.connString, tx: tx})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// stringArray converts `in` to a pqarrays.StringArray, making sure nil
+// slices are stored as empty arrays instead of NULL.
+func stringArray(in []string) pqarrays.StringArray {
+	if in == nil {
+		return pqarrays.StringArray{}
+	}
+	return pqarrays.StringArray(in)
+}
+
 func createSQL(_ context.Context, scope Scope) *pan.Query {
 	return pan.Insert(scope)
 }
 
-// Create inserts the passed Scope into the database,
-// returning an ErrScopeAlreadyExists error if a Scope
-// with the same ID already exists in the database.
-func (s *Storer) Create(ctx context.Context, scope scopes.Scope) error {
-	query := createSQL(ctx, toPostgres(scope))
+func insertExceptionsSQL(_ context.Context, scopeID, kind string, principals []string, position int64) *pan.Query {
+	var exception Exception
+	query := pan.New("INSERT INTO " + pan.Table(exception) + " (" + pan.Columns(exception).String() + ")")
+	query.Expression("SELECT ?, ?, e.position + ?::BIGINT, e.principal_id FROM unnest(?::VARCHAR[]) WITH ORDINALITY AS e (principal_id, position)",
+		scopeID, kind, position-1, stringArray(principals))
+	return query.Flush(" ")
+}
+
+func deleteExceptionsSQL(_ context.Context, scopeID, kind string, principals []string) *pan.Query {
+	var exception Exception
+	query := pan.New("DELETE FROM " + pan.Table(exception))
+	query.Where()
+	query.Comparison(exception, "ScopeID", "=", scopeID)
+	query.Comparison(exception, "Kind", "=", kind)
+	if principals != nil {
+		query.Expression(pan.Column(exception, "PrincipalID")+" = ANY(?::VARCHAR[])", stringArray(principals))
+	}
+	return query.Flush(" AND ")
+}
+
+func exceptionsOfSQL(_ context.Context, scopeID, kind string) *pan.Query {
+	var exception Exception
+	query := pan.New("SELECT " + pan.Column(exception, "PrincipalID") + ", " + pan.Column(exception, "Position") + " FROM " + pan.Table(exception))
+	query.Where()
+	query.Comparison(exception, "ScopeID", "=", scopeID)
+	query.Comparison(exception, "Kind", "=", kind)
+	query.Flush(" AND ")
+	query.OrderBy(pan.Column(exception, "Position"))
+	return query.Flush(" ")
+}
+
+// insertExceptions adds `principals` to the end of the `kind` exceptions of
+// the Scope specified by `scopeID`, starting at `position`.
+func (s *Storer) insertExceptions(ctx context.Context, scopeID, kind string, principals []string, position int64) error {
+	if len(principals) < 1 {
+		return nil
+	}
+	query := insertExceptionsSQL(ctx, scopeID, kind, principals, position)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return fmt.Errorf("error generating insert SQL: %w", err)
 	}
-	_, err = s.db.Exec(queryStr, query.Args()...)
-	var pqErr *pq.Error
-	if errors.As(err, &pqErr) && pqErr.Constraint == "scopes_pkey" {
-		return scopes.ErrScopeAlreadyExists
+	_, err = s.conn().ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error inserting %s exceptions: %w", kind, err)
 	}
+	return nil
+}
+
+// deleteExceptions removes `principals` from the `kind` exceptions of the
+// Scope specified by `scopeID`. If `principals` is nil, every exception of
+// that kind is removed.
+func (s *Storer) deleteExceptions(ctx context.Context, scopeID, kind string, principals []string) error {
+	query := deleteExceptionsSQL(ctx, scopeID, kind, principals)
+	queryStr, err := query.PostgreSQLString()
 	if err != nil {
-		return fmt.Errorf("error inserting scope: %w", err)
+		return fmt.Errorf("error generating delete SQL: %w", err)
+	}
+	_, err = s.conn().ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error deleting %s exceptions: %w", kind, err)
 	}
 	return nil
 }
 
+// exceptionsOf returns the `kind` exceptions of the Scope specified by
+// `scopeID` in order, and the highest Position they use.
+func (s *Storer) exceptionsOf(ctx context.Context, scopeID, kind string) ([]string, int64, error) {
+	query := exceptionsOfSQL(ctx, scopeID, kind)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying %s exceptions: %w", kind, err)
+	}
+	defer closeRows(ctx, rows)
+	var principals []string
+	var last int64
+	for rows.Next() {
+		var principal string
+		err = rows.Scan(&principal, &last)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning %s exception: %w", kind, err)
+		}
+		principals = append(principals, principal)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error querying %s exceptions: %w", kind, err)
+	}
+	return principals, last, nil
+}
+
+// replaceExceptions makes `principals` the `kind` exceptions of the Scope
+// specified by `scopeID`. When `principals` is the existing list with some
+// principals removed and others appended, which covers adding and removing
+// individual exceptions, only those principals are written; otherwise the
+// order changed, and the whole list is rewritten.
+func (s *Storer) replaceExceptions(ctx context.Context, scopeID, kind string, principals []string) error {
+	current, last, err := s.exceptionsOf(ctx, scopeID, kind)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]struct{}, len(principals))
+	for _, principal := range principals {
+		wanted[principal] = struct{}{}
+	}
+	var kept, removed []string
+	for _, principal := range current {
+		if _, ok := wanted[principal]; ok {
+			kept = append(kept, principal)
+			continue
+		}
+		removed = append(removed, principal)
+	}
+	if !hasPrefix(principals, kept) {
+		err = s.deleteExceptions(ctx, scopeID, kind, nil)
+		if err != nil {
+			return err
+		}
+		return s.insertExceptions(ctx, scopeID, kind, principals, 1)
+	}
+	if len(removed) > 0 {
+		err = s.deleteExceptions(ctx, scopeID, kind, removed)
+		if err != nil {
+			return err
+		}
+	}
+	return s.insertExceptions(ctx, scopeID, kind, principals[len(kept):], last+1)
+}
+
+// hasPrefix returns true if `list` starts with every entry in `prefix`, in
+// order.
+func hasPrefix(list, prefix []string) bool {
+	if len(prefix) > len(list) {
+		return false
+	}
+	for pos, entry := range prefix {
+		if list[pos] != entry {
+			return false
+		}
+	}
+	return true
+}
+
+// Create inserts the passed Scope into the database,
+// returning an ErrScopeAlreadyExists error if a Scope
+// with the same ID already exists in the database.
+func (s *Storer) Create(ctx context.Context, scope scopes.Scope) error {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *Storer) error {
+		query := createSQL(ctx, toPostgres(scope))
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return fmt.Errorf("error generating insert SQL: %w", err)
+		}
+		res, err := tx.conn().ExecContext(ctx, insertMissingSQL(queryStr), query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error inserting scope: %w", err)
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error checking if scope was inserted: %w", err)
+		}
+		if inserted < 1 {
+			return scopes.ErrScopeAlreadyExists
+		}
+		err = tx.insertExceptions(ctx, scope.ID, exceptionKindUser, scope.UserExceptions, 1)
+		if err != nil {
+			return err
+		}
+		return tx.insertExceptions(ctx, scope.ID, exceptionKindClient, scope.ClientExceptions, 1)
+	})
+}
+
+// insertMissingSQL turns `insert`, an INSERT statement generated by
+// createSQL, into one that inserts nothing if the Scope already exists.
+// A unique violation would abort the transaction the statement is part of,
+// so callers like scopes.Import couldn't carry on after a conflict.
+func insertMissingSQL(insert string) string {
+	var scope Scope
+	return strings.TrimSuffix(insert, ";") + " ON CONFLICT (" + pan.Column(scope, "ID") + ") DO NOTHING"
+}
+
+// upsertSQL turns `insert`, an INSERT statement generated by createSQL, into
+// one that replaces the existing Scope if there's a conflict. The statement
+// returns whether the row was inserted, rather than updated.
+func upsertSQL(insert string) string {
+	var scope Scope
+	sets := make([]string, 0, 3) //nolint:gomnd // the number of columns below
+	for _, field := range []string{"UserPolicy", "ClientPolicy", "IsDefault"} {
+		column := pan.Column(scope, field)
+		sets = append(sets, column+" = EXCLUDED."+column)
+	}
+	return strings.TrimSuffix(insert, ";") + " ON CONFLICT (" + pan.Column(scope, "ID") + ") DO UPDATE SET " + strings.Join(sets, ", ") +
+		" RETURNING (xmax = 0)"
+}
+
+// Put inserts the passed Scope into the database, replacing any Scope with the
+// same ID, and returns true if no Scope with that ID existed.
+func (s *Storer) Put(ctx context.Context, scope scopes.Scope) (bool, error) {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return false, err
+	}
+	var created bool
+	err := s.withTx(ctx, func(tx *Storer) error {
+		query := createSQL(ctx, toPostgres(scope))
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return fmt.Errorf("error generating insert SQL: %w", err)
+		}
+		err = tx.conn().QueryRowContext(ctx, upsertSQL(queryStr), query.Args()...).Scan(&created)
+		if err != nil {
+			return fmt.Errorf("error storing scope: %w", err)
+		}
+		if created {
+			err = tx.insertExceptions(ctx, scope.ID, exceptionKindUser, scope.UserExceptions, 1)
+			if err != nil {
+				return err
+			}
+			return tx.insertExceptions(ctx, scope.ID, exceptionKindClient, scope.ClientExceptions, 1)
+		}
+		err = tx.replaceExceptions(ctx, scope.ID, exceptionKindUser, scope.UserExceptions)
+		if err != nil {
+			return err
+		}
+		return tx.replaceExceptions(ctx, scope.ID, exceptionKindClient, scope.ClientExceptions)
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// exceptionsSQL returns a subquery that selects the `kind` exceptions of each
+// Scope selected by the query it's embedded in, as an array in order.
+func exceptionsSQL(kind string) string {
+	var scope Scope
+	var exception Exception
+	return "COALESCE((SELECT array_agg(" + pan.Column(exception, "PrincipalID") + " ORDER BY " + pan.Column(exception, "Position") + ")" +
+		" FROM " + pan.Table(exception) +
+		" WHERE " + pan.Table(exception) + "." + pan.Column(exception, "ScopeID") + " = " + pan.Table(scope) + "." + pan.Column(scope, "ID") +
+		" AND " + pan.Column(exception, "Kind") + " = '" + kind + "'), '{}')"
+}
+
+// selectScopesSQL returns the start of a query that selects Scopes and their
+// exceptions, to be unmarshaled with unmarshalScope.
+func selectScopesSQL() string {
+	var scope Scope
+	return "SELECT " + pan.Columns(scope).String() + ", " + exceptionsSQL(exceptionKindUser) + ", " +
+		exceptionsSQL(exceptionKindClient) + " FROM " + pan.Table(scope)
+}
+
+func unmarshalScope(rows *sql.Rows) (scopes.Scope, error) {
+	var scope Scope
+	var userExceptions, clientExceptions pqarrays.StringArray
+	err := pan.Unmarshal(rows, &scope, &userExceptions, &clientExceptions)
+	if err != nil {
+		return scopes.Scope{}, fmt.Errorf("error unmarshaling scope: %w", err)
+	}
+	return fromPostgres(scope, userExceptions, clientExceptions), nil
+}
+
 func getMultiSQL(_ context.Context, ids []string) *pan.Query {
 	var scope Scope
-	query := pan.New("SELECT " + pan.Columns(scope).String() + " FROM " + pan.Table(scope))
+	query := pan.New(selectScopesSQL())
 	query.Where()
 	intIDs := make([]interface{}, 0, len(ids))
 	for _, id := range ids {
@@ -77,24 +421,26 @@ func getMultiSQL(_ context.Context, ids []string) *pan.Query {
 // Scopes are found. If a Scope is not found, no error will
 // be returned, it will just be omitted from the map.
 func (s *Storer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.Scope, error) {
+	if len(ids) < 1 {
+		return map[string]scopes.Scope{}, nil
+	}
 	query := getMultiSQL(ctx, ids)
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return nil, fmt.Errorf("error generating SQL: %w", err)
 	}
-	rows, err := s.db.Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
 	if err != nil {
 		return nil, fmt.Errorf("error querying scopes: %w", err)
 	}
 	defer closeRows(ctx, rows)
 	results := map[string]scopes.Scope{}
 	for rows.Next() {
-		var scope Scope
-		err = pan.Unmarshal(rows, &scope)
+		scope, err := unmarshalScope(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling scope: %w", err)
+			return nil, err
 		}
-		results[scope.ID] = fromPostgres(scope)
+		results[scope.ID] = scope
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error querying scopes: %w", err)
@@ -102,45 +448,136 @@ func (s *Storer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.
 	return results, nil
 }
 
+func lockSQL(_ context.Context, id string) *pan.Query {
+	var scope Scope
+	query := pan.New("SELECT " + pan.Column(scope, "ID") + " FROM " + pan.Table(scope))
+	query.Where()
+	query.Comparison(scope, "ID", "=", id)
+	query.Expression("FOR UPDATE")
+	return query.Flush(" ")
+}
+
+// getForUpdate locks the Scope specified by `id` until the end of the
+// transaction and returns it, or nil if no Scope matches `id`. It must be
+// called in a transaction.
+func (s *Storer) getForUpdate(ctx context.Context, id string) (*scopes.Scope, error) {
+	query := lockSQL(ctx, id)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating lock SQL: %w", err)
+	}
+	var lockedID string
+	err = s.conn().QueryRowContext(ctx, queryStr, query.Args()...).Scan(&lockedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error locking scope: %w", err)
+	}
+	results, err := s.GetMulti(ctx, []string{lockedID})
+	if err != nil {
+		return nil, err
+	}
+	scope, ok := results[lockedID]
+	if !ok {
+		return nil, nil
+	}
+	return &scope, nil
+}
+
+// updateSQL returns a query that applies `change` to the Scope specified by
+// `id`. The row is updated even if only the Scope's exceptions change, so
+// the change is recorded as an Event, and it has to be updated before its
+// exceptions are, so the Event records the exceptions it had.
 func updateSQL(_ context.Context, id string, change scopes.Change) *pan.Query {
 	var scope Scope
 	query := pan.New("UPDATE " + pan.Table(scope) + " SET ")
 	if change.UserPolicy != nil {
 		query.Comparison(scope, "UserPolicy", "=", *change.UserPolicy)
 	}
-	if change.UserExceptions != nil {
-		query.Comparison(scope, "UserExceptions", "=", pqarrays.StringArray(*change.UserExceptions))
-	}
 	if change.ClientPolicy != nil {
 		query.Comparison(scope, "ClientPolicy", "=", *change.ClientPolicy)
 	}
-	if change.ClientExceptions != nil {
-		query.Comparison(scope, "ClientExceptions", "=", pqarrays.StringArray(*change.ClientExceptions))
-	}
 	if change.IsDefault != nil {
 		query.Comparison(scope, "IsDefault", "=", *change.IsDefault)
 	}
+	if change.UserPolicy == nil && change.ClientPolicy == nil && change.IsDefault == nil {
+		query.Expression(pan.Column(scope, "IsDefault") + " = " + pan.Column(scope, "IsDefault"))
+	}
 	query.Flush(", ")
 	query.Where()
 	query.Comparison(scope, "ID", "=", id)
 	return query.Flush(" ")
 }
 
-// Update applies the passed Change to the Scope that matches
-// the specified ID in the Memstore, if any Scope matches the
-// specified ID in the Memstore.
-func (s *Storer) Update(ctx context.Context, id string, change scopes.Change) error {
+// Update applies the passed Change to the Scope that matches the specified ID
+// in the Storer and returns the updated Scope. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned. Only the exceptions the
+// Change adds or removes are written.
+func (s *Storer) Update(ctx context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	if err := scopes.ValidateChangeIDs(change); err != nil {
+		return scopes.Scope{}, err
+	}
 	if change.IsEmpty() {
-		return nil
+		results, err := s.GetMulti(ctx, []string{id})
+		if err != nil {
+			return scopes.Scope{}, err
+		}
+		scope, ok := results[id]
+		if !ok {
+			return scopes.Scope{}, scopes.ErrScopeNotFound
+		}
+		return scope, nil
 	}
-	query := updateSQL(ctx, id, change)
-	queryStr, err := query.PostgreSQLString()
+	var updated scopes.Scope
+	err := s.withTx(ctx, func(tx *Storer) error {
+		query := updateSQL(ctx, id, change)
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return fmt.Errorf("error generating update SQL: %w", err)
+		}
+		res, err := tx.conn().ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error updating scope: %w", err)
+		}
+		err = expectAffected(res)
+		if err != nil {
+			return err
+		}
+		if change.UserExceptions != nil {
+			err = tx.replaceExceptions(ctx, id, exceptionKindUser, *change.UserExceptions)
+			if err != nil {
+				return err
+			}
+		}
+		if change.ClientExceptions != nil {
+			err = tx.replaceExceptions(ctx, id, exceptionKindClient, *change.ClientExceptions)
+			if err != nil {
+				return err
+			}
+		}
+		results, err := tx.GetMulti(ctx, []string{id})
+		if err != nil {
+			return err
+		}
+		updated = results[id]
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error generating update SQL: %w", err)
+		return scopes.Scope{}, err
 	}
-	_, err = s.db.Exec(queryStr, query.Args()...)
+	return updated, nil
+}
+
+// expectAffected returns scopes.ErrScopeNotFound if `res` didn't affect any
+// rows.
+func expectAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("error updating scope: %w", err)
+		return fmt.Errorf("error checking affected rows: %w", err)
+	}
+	if affected < 1 {
+		return scopes.ErrScopeNotFound
 	}
 	return nil
 }
@@ -153,52 +590,122 @@ func deleteSQL(_ context.Context, id string) *pan.Query {
 	return q.Flush(" ")
 }
 
-// Delete removes the Scope that matches the specified ID from
-// the Memstore, if any Scope matches the specified ID in the
-// Memstore.
-func (s *Storer) Delete(ctx context.Context, id string) error {
-	query := deleteSQL(ctx, id)
-	queryStr, err := query.PostgreSQLString()
-	if err != nil {
-		return fmt.Errorf("error generating delete SQL: %w", err)
-	}
-	_, err = s.db.Exec(queryStr, query.Args()...)
+// Delete removes the Scope that matches the specified ID from the Storer and
+// returns the Scope as it was before it was deleted. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Delete(ctx context.Context, id string) (scopes.Scope, error) {
+	var deleted *scopes.Scope
+	err := s.withTx(ctx, func(tx *Storer) error {
+		var err error
+		deleted, err = tx.getForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return scopes.ErrScopeNotFound
+		}
+		query := deleteSQL(ctx, id)
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return fmt.Errorf("error generating delete SQL: %w", err)
+		}
+		// the Scope's exceptions are deleted along with it
+		res, err := tx.conn().ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error deleting scope: %w", err)
+		}
+		return expectAffected(res)
+	})
 	if err != nil {
-		return fmt.Errorf("error deleting scope: %w", err)
+		return scopes.Scope{}, err
 	}
-	return nil
+	return *deleted, nil
+}
+
+// byteOrder returns `column` with the "C" collation, so it's compared byte by
+// byte, like the other Storers and scopes.ByID compare IDs, rather than
+// following the database's collation.
+func byteOrder(column string) string {
+	return column + ` COLLATE "C"`
 }
 
 func listDefaultSQL(_ context.Context) *pan.Query {
 	var scope Scope
-	q := pan.New("SELECT " + pan.Columns(scope).String() + " FROM " + pan.Table(scope))
+	q := pan.New(selectScopesSQL())
 	q.Where()
 	q.Comparison(scope, "IsDefault", "=", true)
-	q.OrderBy(pan.Column(scope, "ID"))
+	q.OrderBy(byteOrder(pan.Column(scope, "ID")))
 	return q.Flush(" ")
 }
 
 // ListDefault returns all the Scopes with IsDefault set to true.
 // sorted lexicographically by their ID.
 func (s *Storer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
-	query := listDefaultSQL(ctx)
+	return s.listScopes(ctx, listDefaultSQL(ctx))
+}
+
+func listSQL(_ context.Context, after string, limit int) *pan.Query {
+	var scope Scope
+	q := pan.New(selectScopesSQL())
+	if after != "" {
+		q.Where()
+		q.Expression(byteOrder(pan.Column(scope, "ID"))+" > ?", after)
+	}
+	q.OrderBy(byteOrder(pan.Column(scope, "ID")))
+	if limit > 0 {
+		q.Limit(int64(limit))
+	}
+	return q.Flush(" ")
+}
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`.
+func (s *Storer) List(ctx context.Context, after string, limit int) ([]scopes.Scope, error) {
+	return s.listScopes(ctx, listSQL(ctx, after, limit))
+}
+
+func listByExceptionSQL(_ context.Context, kind, principalID string) *pan.Query {
+	var scope Scope
+	var exception Exception
+	q := pan.New(selectScopesSQL())
+	q.Where()
+	q.Expression(pan.Column(scope, "ID")+" IN (SELECT "+pan.Column(exception, "ScopeID")+" FROM "+pan.Table(exception)+
+		" WHERE "+pan.Column(exception, "Kind")+" = ? AND "+pan.Column(exception, "PrincipalID")+" = ?)", kind, principalID)
+	q.OrderBy(byteOrder(pan.Column(scope, "ID")))
+	return q.Flush(" ")
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	return s.listScopes(ctx, listByExceptionSQL(ctx, exceptionKindUser, userID))
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.listScopes(ctx, listByExceptionSQL(ctx, exceptionKindClient, clientID))
+}
+
+// listScopes runs `query`, which must select Scopes using selectScopesSQL,
+// and returns the Scopes it selects in order.
+func (s *Storer) listScopes(ctx context.Context, query *pan.Query) ([]scopes.Scope, error) {
 	queryStr, err := query.PostgreSQLString()
 	if err != nil {
 		return nil, fmt.Errorf("error generating SQL: %w", err)
 	}
-	rows, err := s.db.Query(queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
 	if err != nil {
 		return nil, fmt.Errorf("error querying scopes: %w", err)
 	}
 	defer closeRows(ctx, rows)
 	var results []scopes.Scope
 	for rows.Next() {
-		var scope Scope
-		err = pan.Unmarshal(rows, &scope)
+		scope, err := unmarshalScope(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling scope: %w", err)
+			return nil, err
 		}
-		results = append(results, fromPostgres(scope))
+		results = append(results, scope)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error querying scopes: %w", err)
@@ -206,6 +713,98 @@ func (s *Storer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
 	return results, nil
 }
 
+// usableSQL returns an expression that's true if the principal bound to both
+// of its placeholders can use the Scope selected by the query it's embedded
+// in, following the Scope's `policyField` policy and `kind` exceptions.
+func usableSQL(kind, policyField string) string {
+	var scope Scope
+	var exception Exception
+	excepted := "EXISTS (SELECT 1 FROM " + pan.Table(exception) + " WHERE " +
+		pan.Column(exception, "ScopeID") + " = " + pan.Table(scope) + "." + pan.Column(scope, "ID") + " AND " +
+		pan.Column(exception, "Kind") + " = '" + kind + "' AND " +
+		pan.Column(exception, "PrincipalID") + " = ?)"
+	return "CASE " + pan.Table(scope) + "." + pan.Column(scope, policyField) +
+		" WHEN '" + scopes.PolicyAllowAll + "' THEN TRUE" +
+		" WHEN '" + scopes.PolicyDefaultAllow + "' THEN NOT " + excepted +
+		" WHEN '" + scopes.PolicyDefaultDeny + "' THEN " + excepted +
+		" ELSE FALSE END"
+}
+
+func canUseSQL(_ context.Context, scopeID, kind, policyField, principalID string) *pan.Query {
+	var scope Scope
+	query := pan.New("SELECT")
+	query.Expression(usableSQL(kind, policyField), principalID, principalID)
+	query.Expression("FROM " + pan.Table(scope))
+	query.Where()
+	query.Comparison(scope, "ID", "=", scopeID)
+	return query.Flush(" ")
+}
+
+func (s *Storer) canUse(ctx context.Context, scopeID, kind, policyField, principalID string) (bool, error) {
+	query := canUseSQL(ctx, scopeID, kind, policyField, principalID)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return false, fmt.Errorf("error generating SQL: %w", err)
+	}
+	var canUse bool
+	err = s.conn().QueryRowContext(ctx, queryStr, query.Args()...).Scan(&canUse)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking %s access: %w", kind, err)
+	}
+	return canUse, nil
+}
+
+// UserCanUse returns true if the user specified by `userID` can use the Scope
+// specified by `scopeID`, following the same rules as scopes.UserCanUseScope.
+// The check is made by the database, using an index, so the Scope's
+// exceptions are never loaded.
+func (s *Storer) UserCanUse(ctx context.Context, scopeID, userID string) (bool, error) {
+	return s.canUse(ctx, scopeID, exceptionKindUser, "UserPolicy", userID)
+}
+
+// ClientCanUse returns true if the client specified by `clientID` can use the
+// Scope specified by `scopeID`, following the same rules as
+// scopes.ClientCanUseScope. The check is made by the database, using an
+// index, so the Scope's exceptions are never loaded.
+func (s *Storer) ClientCanUse(ctx context.Context, scopeID, clientID string) (bool, error) {
+	return s.canUse(ctx, scopeID, exceptionKindClient, "ClientPolicy", clientID)
+}
+
+func listUsableSQL(_ context.Context, access scopes.AccessQuery, after string, limit int) *pan.Query {
+	var scope Scope
+	q := pan.New(selectScopesSQL())
+	q.Where()
+	if after != "" {
+		q.Expression(byteOrder(pan.Column(scope, "ID"))+" > ?", after)
+	}
+	if access.UserID != "" {
+		q.Expression(usableSQL(exceptionKindUser, "UserPolicy"), access.UserID, access.UserID)
+	}
+	if access.ClientID != "" {
+		q.Expression(usableSQL(exceptionKindClient, "ClientPolicy"), access.ClientID, access.ClientID)
+	}
+	q.Flush(" AND ")
+	q.OrderBy(byteOrder(pan.Column(scope, "ID")))
+	if limit > 0 {
+		q.Limit(int64(limit))
+	}
+	return q.Flush(" ")
+}
+
+// ListUsable returns up to `limit` of the Scopes the principals described by
+// `access` can use, sorted lexicographically by their ID, starting with the
+// first Scope whose ID sorts after `after`. The Scopes are filtered by the
+// database, using an index, so only the Scopes returned are loaded.
+func (s *Storer) ListUsable(ctx context.Context, access scopes.AccessQuery, after string, limit int) ([]scopes.Scope, error) {
+	if access.UserID == "" && access.ClientID == "" {
+		return nil, scopes.ErrNoPrincipal
+	}
+	return s.listScopes(ctx, listUsableSQL(ctx, access, after, limit))
+}
+
 func closeRows(ctx context.Context, rows *sql.Rows) {
 	if err := rows.Close(); err != nil {
 		yall.FromContext(ctx).WithError(err).Error("failed to close rows")
@@ -6,15 +6,23 @@ import (
 	"lockbox.dev/scopes"
 )
 
+const (
+	// exceptionKindUser is the Kind of Exceptions that are part of a
+	// Scope's UserExceptions.
+	exceptionKindUser = "user"
+	// exceptionKindClient is the Kind of Exceptions that are part of a
+	// Scope's ClientExceptions.
+	exceptionKindClient = "client"
+)
+
 // Scope is a representation of the scopes.Scope type that is suitable to be
-// stored in a PostgreSQL database.
+// stored in a PostgreSQL database. A Scope's exceptions are stored separately,
+// as Exceptions.
 type Scope struct {
-	ID               string               `sql_column:"id"`
-	UserPolicy       string               `sql_column:"user_policy"`
-	UserExceptions   pqarrays.StringArray `sql_column:"user_exceptions"`
-	ClientPolicy     string               `sql_column:"client_policy"`
-	ClientExceptions pqarrays.StringArray `sql_column:"client_exceptions"`
-	IsDefault        bool                 `sql_column:"is_default"`
+	ID           string `sql_column:"id"`
+	UserPolicy   string `sql_column:"user_policy"`
+	ClientPolicy string `sql_column:"client_policy"`
+	IsDefault    bool   `sql_column:"is_default"`
 }
 
 // GetSQLTableName returns the name of the SQL table that the data for this
@@ -23,24 +31,100 @@ func (Scope) GetSQLTableName() string {
 	return "scopes"
 }
 
-func fromPostgres(scope Scope) scopes.Scope {
+// Exception is a representation of a single entry in a scopes.Scope's
+// UserExceptions or ClientExceptions that is suitable to be stored in a
+// PostgreSQL database. Kind is either "user" or "client", and Position is the
+// entry's 1-based index in the list it belongs to.
+type Exception struct {
+	ScopeID     string `sql_column:"scope_id"`
+	Kind        string `sql_column:"kind"`
+	Position    int64  `sql_column:"position"`
+	PrincipalID string `sql_column:"principal_id"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Exception) GetSQLTableName() string {
+	return "scope_exceptions"
+}
+
+func fromPostgres(scope Scope, userExceptions, clientExceptions pqarrays.StringArray) scopes.Scope {
 	return scopes.Scope{
 		ID:               scope.ID,
 		UserPolicy:       scope.UserPolicy,
-		UserExceptions:   []string(scope.UserExceptions),
+		UserExceptions:   []string(userExceptions),
 		ClientPolicy:     scope.ClientPolicy,
-		ClientExceptions: []string(scope.ClientExceptions),
+		ClientExceptions: []string(clientExceptions),
 		IsDefault:        scope.IsDefault,
 	}
 }
 
 func toPostgres(scope scopes.Scope) Scope {
 	return Scope{
-		ID:               scope.ID,
-		UserPolicy:       scope.UserPolicy,
-		UserExceptions:   pqarrays.StringArray(scope.UserExceptions),
-		ClientPolicy:     scope.ClientPolicy,
-		ClientExceptions: pqarrays.StringArray(scope.ClientExceptions),
-		IsDefault:        scope.IsDefault,
+		ID:           scope.ID,
+		UserPolicy:   scope.UserPolicy,
+		ClientPolicy: scope.ClientPolicy,
+		IsDefault:    scope.IsDefault,
+	}
+}
+
+// Event is a representation of the scopes.Event type that is suitable to be
+// stored in a PostgreSQL database.
+type Event struct {
+	Revision         int64                `sql_column:"revision"`
+	Type             string               `sql_column:"event_type"`
+	ScopeID          string               `sql_column:"scope_id"`
+	UserPolicy       string               `sql_column:"user_policy"`
+	UserExceptions   pqarrays.StringArray `sql_column:"user_exceptions"`
+	ClientPolicy     string               `sql_column:"client_policy"`
+	ClientExceptions pqarrays.StringArray `sql_column:"client_exceptions"`
+	IsDefault        bool                 `sql_column:"is_default"`
+
+	// the Previous fields are only set for update events
+	PreviousUserPolicy       *string               `sql_column:"previous_user_policy"`
+	PreviousUserExceptions   *pqarrays.StringArray `sql_column:"previous_user_exceptions"`
+	PreviousClientPolicy     *string               `sql_column:"previous_client_policy"`
+	PreviousClientExceptions *pqarrays.StringArray `sql_column:"previous_client_exceptions"`
+	PreviousIsDefault        *bool                 `sql_column:"previous_is_default"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Event) GetSQLTableName() string {
+	return "scope_events"
+}
+
+func eventFromPostgres(event Event) scopes.Event {
+	res := scopes.Event{
+		Revision: uint64(event.Revision),
+		Type:     scopes.EventType(event.Type),
+		Scope: scopes.Scope{
+			ID:               event.ScopeID,
+			UserPolicy:       event.UserPolicy,
+			UserExceptions:   []string(event.UserExceptions),
+			ClientPolicy:     event.ClientPolicy,
+			ClientExceptions: []string(event.ClientExceptions),
+			IsDefault:        event.IsDefault,
+		},
+	}
+	if event.PreviousUserPolicy != nil {
+		previous := scopes.Scope{
+			ID:         event.ScopeID,
+			UserPolicy: *event.PreviousUserPolicy,
+		}
+		if event.PreviousUserExceptions != nil {
+			previous.UserExceptions = []string(*event.PreviousUserExceptions)
+		}
+		if event.PreviousClientPolicy != nil {
+			previous.ClientPolicy = *event.PreviousClientPolicy
+		}
+		if event.PreviousClientExceptions != nil {
+			previous.ClientExceptions = []string(*event.PreviousClientExceptions)
+		}
+		if event.PreviousIsDefault != nil {
+			previous.IsDefault = *event.PreviousIsDefault
+		}
+		res.Previous = &previous
 	}
+	return res
 }
@@ -0,0 +1,46 @@
+-- +migrate Up
+CREATE TABLE scope_events (
+	revision BIGSERIAL PRIMARY KEY,
+	event_type VARCHAR NOT NULL,
+	scope_id VARCHAR NOT NULL,
+	user_policy VARCHAR NOT NULL DEFAULT '',
+	user_exceptions VARCHAR[] NOT NULL,
+	client_policy VARCHAR NOT NULL DEFAULT '',
+	client_exceptions VARCHAR[] NOT NULL,
+	is_default BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +migrate StatementBegin
+CREATE FUNCTION scopes_record_event() RETURNS trigger AS $$
+DECLARE
+	rev BIGINT;
+BEGIN
+	-- serialize writers, so revisions become visible in order
+	PERFORM pg_advisory_xact_lock(hashtext('scope_events'));
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('deleted', OLD.id, OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSIF (TG_OP = 'UPDATE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('updated', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	ELSE
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('created', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	END IF;
+	PERFORM pg_notify('scope_events', rev::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER scopes_record_event AFTER INSERT OR UPDATE OR DELETE ON scopes
+	FOR EACH ROW EXECUTE PROCEDURE scopes_record_event();
+
+-- +migrate Down
+DROP TRIGGER scopes_record_event ON scopes;
+DROP FUNCTION scopes_record_event();
+DROP TABLE scope_events;
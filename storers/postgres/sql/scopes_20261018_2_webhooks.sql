@@ -0,0 +1,101 @@
+-- +migrate Up
+ALTER TABLE scope_events
+	ADD COLUMN previous_user_policy VARCHAR,
+	ADD COLUMN previous_user_exceptions VARCHAR[],
+	ADD COLUMN previous_client_policy VARCHAR,
+	ADD COLUMN previous_client_exceptions VARCHAR[],
+	ADD COLUMN previous_is_default BOOLEAN;
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION scopes_record_event() RETURNS trigger AS $$
+DECLARE
+	rev BIGINT;
+BEGIN
+	-- serialize writers, so revisions become visible in order
+	PERFORM pg_advisory_xact_lock(hashtext('scope_events'));
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('deleted', OLD.id, OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSIF (TG_OP = 'UPDATE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default,
+				previous_user_policy, previous_user_exceptions, previous_client_policy, previous_client_exceptions, previous_is_default)
+			VALUES ('updated', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default,
+				OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSE
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('created', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	END IF;
+	PERFORM pg_notify('scope_events', rev::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TABLE webhooks (
+	id VARCHAR PRIMARY KEY,
+	url VARCHAR NOT NULL,
+	secret VARCHAR NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id VARCHAR PRIMARY KEY,
+	webhook_id VARCHAR NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	revision BIGINT NOT NULL,
+	body BYTEA NOT NULL,
+	state VARCHAR NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error VARCHAR NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (webhook_id, revision)
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX webhook_deliveries_dead ON webhook_deliveries (revision) WHERE state = 'dead';
+
+CREATE TABLE webhook_cursor (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	revision BIGINT NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE webhook_cursor;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION scopes_record_event() RETURNS trigger AS $$
+DECLARE
+	rev BIGINT;
+BEGIN
+	-- serialize writers, so revisions become visible in order
+	PERFORM pg_advisory_xact_lock(hashtext('scope_events'));
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('deleted', OLD.id, OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSIF (TG_OP = 'UPDATE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('updated', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	ELSE
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('created', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	END IF;
+	PERFORM pg_notify('scope_events', rev::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+ALTER TABLE scope_events
+	DROP COLUMN previous_user_policy,
+	DROP COLUMN previous_user_exceptions,
+	DROP COLUMN previous_client_policy,
+	DROP COLUMN previous_client_exceptions,
+	DROP COLUMN previous_is_default;
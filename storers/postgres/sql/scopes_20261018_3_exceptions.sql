@@ -0,0 +1,74 @@
+-- +migrate Up
+CREATE TABLE scope_exceptions (
+	scope_id VARCHAR NOT NULL REFERENCES scopes (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	kind VARCHAR NOT NULL CHECK (kind IN ('user', 'client')),
+	position BIGINT NOT NULL,
+	principal_id VARCHAR NOT NULL,
+	PRIMARY KEY (scope_id, kind, position)
+);
+
+CREATE INDEX scope_exceptions_membership ON scope_exceptions (scope_id, kind, principal_id);
+
+INSERT INTO scope_exceptions (scope_id, kind, position, principal_id)
+	SELECT scopes.id, 'user', e.position, e.principal_id
+	FROM scopes, unnest(scopes.user_exceptions) WITH ORDINALITY AS e (principal_id, position);
+INSERT INTO scope_exceptions (scope_id, kind, position, principal_id)
+	SELECT scopes.id, 'client', e.position, e.principal_id
+	FROM scopes, unnest(scopes.client_exceptions) WITH ORDINALITY AS e (principal_id, position);
+
+-- a row trigger on scopes can't see the exceptions written after the scope
+-- row, so the Storer records events itself from now on
+DROP TRIGGER scopes_record_event ON scopes;
+DROP FUNCTION scopes_record_event();
+
+ALTER TABLE scopes
+	DROP COLUMN user_exceptions,
+	DROP COLUMN client_exceptions;
+
+-- +migrate Down
+ALTER TABLE scopes
+	ADD COLUMN user_exceptions VARCHAR[] NOT NULL DEFAULT '{}',
+	ADD COLUMN client_exceptions VARCHAR[] NOT NULL DEFAULT '{}';
+
+UPDATE scopes SET
+	user_exceptions = COALESCE((SELECT array_agg(principal_id ORDER BY position) FROM scope_exceptions
+		WHERE scope_exceptions.scope_id = scopes.id AND kind = 'user'), '{}'),
+	client_exceptions = COALESCE((SELECT array_agg(principal_id ORDER BY position) FROM scope_exceptions
+		WHERE scope_exceptions.scope_id = scopes.id AND kind = 'client'), '{}');
+
+ALTER TABLE scopes
+	ALTER COLUMN user_exceptions DROP DEFAULT,
+	ALTER COLUMN client_exceptions DROP DEFAULT;
+
+DROP TABLE scope_exceptions;
+
+-- +migrate StatementBegin
+CREATE FUNCTION scopes_record_event() RETURNS trigger AS $$
+DECLARE
+	rev BIGINT;
+BEGIN
+	-- serialize writers, so revisions become visible in order
+	PERFORM pg_advisory_xact_lock(hashtext('scope_events'));
+	IF (TG_OP = 'DELETE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('deleted', OLD.id, OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSIF (TG_OP = 'UPDATE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default,
+				previous_user_policy, previous_user_exceptions, previous_client_policy, previous_client_exceptions, previous_is_default)
+			VALUES ('updated', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default,
+				OLD.user_policy, OLD.user_exceptions, OLD.client_policy, OLD.client_exceptions, OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSE
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('created', NEW.id, NEW.user_policy, NEW.user_exceptions, NEW.client_policy, NEW.client_exceptions, NEW.is_default)
+			RETURNING revision INTO rev;
+	END IF;
+	PERFORM pg_notify('scope_events', rev::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER scopes_record_event AFTER INSERT OR UPDATE OR DELETE ON scopes
+	FOR EACH ROW EXECUTE PROCEDURE scopes_record_event();
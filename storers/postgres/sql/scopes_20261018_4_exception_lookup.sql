@@ -0,0 +1,8 @@
+-- +migrate Up
+-- exceptions live in their own table rather than in arrays, so a btree index
+-- on the principal serves reverse lookups the way a GIN index on the arrays
+-- would have
+CREATE INDEX scope_exceptions_principal ON scope_exceptions (kind, principal_id);
+
+-- +migrate Down
+DROP INDEX scope_exceptions_principal;
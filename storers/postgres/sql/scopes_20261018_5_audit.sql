@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE scope_audit (
+	id VARCHAR PRIMARY KEY,
+	action VARCHAR NOT NULL,
+	principal_id VARCHAR NOT NULL,
+	scope_ids VARCHAR[] NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX scope_audit_created_at ON scope_audit (created_at);
+
+-- +migrate Down
+DROP TABLE scope_audit;
@@ -0,0 +1,12 @@
+-- +migrate Up
+-- scope_events only keeps the most recent events; compacted_through is the
+-- most recent revision that has been discarded, so watchers resuming from
+-- before it can be told they missed events
+CREATE TABLE scope_event_retention (
+	compacted_through BIGINT NOT NULL
+);
+
+INSERT INTO scope_event_retention (compacted_through) VALUES (0);
+
+-- +migrate Down
+DROP TABLE scope_event_retention;
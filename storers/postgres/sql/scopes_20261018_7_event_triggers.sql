@@ -0,0 +1,93 @@
+-- +migrate Up
+-- events are recorded by triggers on scopes again. A Scope's exceptions are
+-- written after its row, so scopes_record_event records the exceptions a
+-- Scope had before the change, and scopes_fill_event_exceptions fills in the
+-- ones it was left with when the transaction commits. Events are only
+-- missing their exceptions until then.
+ALTER TABLE scope_events
+	ALTER COLUMN user_exceptions DROP NOT NULL,
+	ALTER COLUMN client_exceptions DROP NOT NULL;
+
+-- +migrate StatementBegin
+CREATE FUNCTION scope_exceptions_of(VARCHAR, VARCHAR) RETURNS VARCHAR[] AS $$
+	SELECT COALESCE(array_agg(principal_id ORDER BY position), '{}') FROM scope_exceptions
+		WHERE scope_id = $1 AND kind = $2;
+$$ LANGUAGE sql STABLE;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE FUNCTION scopes_record_event() RETURNS trigger AS $$
+DECLARE
+	rev BIGINT;
+BEGIN
+	-- serialize writers, so revisions become visible in order
+	PERFORM pg_advisory_xact_lock(hashtext('scope_events'));
+	IF (TG_OP = 'DELETE') THEN
+		-- this runs before the row is deleted, while its exceptions
+		-- haven't been deleted along with it
+		INSERT INTO scope_events (event_type, scope_id, user_policy, user_exceptions, client_policy, client_exceptions, is_default)
+			VALUES ('deleted', OLD.id, OLD.user_policy, scope_exceptions_of(OLD.id, 'user'),
+				OLD.client_policy, scope_exceptions_of(OLD.id, 'client'), OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSIF (TG_OP = 'UPDATE') THEN
+		INSERT INTO scope_events (event_type, scope_id, user_policy, client_policy, is_default,
+				previous_user_policy, previous_user_exceptions, previous_client_policy, previous_client_exceptions, previous_is_default)
+			VALUES ('updated', NEW.id, NEW.user_policy, NEW.client_policy, NEW.is_default,
+				OLD.user_policy, scope_exceptions_of(OLD.id, 'user'), OLD.client_policy, scope_exceptions_of(OLD.id, 'client'), OLD.is_default)
+			RETURNING revision INTO rev;
+	ELSE
+		INSERT INTO scope_events (event_type, scope_id, user_policy, client_policy, is_default)
+			VALUES ('created', NEW.id, NEW.user_policy, NEW.client_policy, NEW.is_default)
+			RETURNING revision INTO rev;
+	END IF;
+	-- only the most recent 1024 events are kept
+	IF (rev > 1024) THEN
+		DELETE FROM scope_events WHERE revision <= rev - 1024;
+		UPDATE scope_event_retention SET compacted_through = rev - 1024;
+	END IF;
+	PERFORM pg_notify('scope_events', rev::text);
+	IF (TG_OP = 'DELETE') THEN
+		RETURN OLD;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE FUNCTION scopes_fill_event_exceptions() RETURNS trigger AS $$
+BEGIN
+	-- only this transaction's events can be missing exceptions, as it
+	-- holds the lock scopes_record_event takes
+	UPDATE scope_events SET
+		user_exceptions = scope_exceptions_of(NEW.id, 'user'),
+		client_exceptions = scope_exceptions_of(NEW.id, 'client')
+		WHERE scope_id = NEW.id AND user_exceptions IS NULL;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER scopes_record_event AFTER INSERT OR UPDATE ON scopes
+	FOR EACH ROW EXECUTE PROCEDURE scopes_record_event();
+
+-- the delete's cascade to scope_exceptions runs before any AFTER trigger
+-- we could add, so deletes are recorded before they happen
+CREATE TRIGGER scopes_record_delete_event BEFORE DELETE ON scopes
+	FOR EACH ROW EXECUTE PROCEDURE scopes_record_event();
+
+CREATE CONSTRAINT TRIGGER scopes_fill_event_exceptions AFTER INSERT OR UPDATE ON scopes
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE PROCEDURE scopes_fill_event_exceptions();
+
+-- +migrate Down
+DROP TRIGGER scopes_fill_event_exceptions ON scopes;
+DROP TRIGGER scopes_record_delete_event ON scopes;
+DROP TRIGGER scopes_record_event ON scopes;
+DROP FUNCTION scopes_fill_event_exceptions();
+DROP FUNCTION scopes_record_event();
+DROP FUNCTION scope_exceptions_of(VARCHAR, VARCHAR);
+
+ALTER TABLE scope_events
+	ALTER COLUMN user_exceptions SET NOT NULL,
+	ALTER COLUMN client_exceptions SET NOT NULL;
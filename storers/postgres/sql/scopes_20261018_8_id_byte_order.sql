@@ -0,0 +1,7 @@
+-- +migrate Up
+-- Scopes are listed and paged through in byte order, whatever the database's
+-- collation is, so they need an index in that order
+CREATE INDEX scopes_id_byte_order ON scopes (id COLLATE "C");
+
+-- +migrate Down
+DROP INDEX scopes_id_byte_order;
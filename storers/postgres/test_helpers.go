@@ -11,10 +11,8 @@ import (
 	"sync"
 
 	uuid "github.com/hashicorp/go-uuid"
-	migrate "github.com/rubenv/sql-migrate"
 
 	"lockbox.dev/scopes"
-	"lockbox.dev/scopes/storers/postgres/migrations"
 )
 
 // Factory is a generator of Storers for testing purposes. It knows how to
@@ -36,57 +34,63 @@ func NewFactory(db *sql.DB) *Factory {
 	}
 }
 
-// NewStorer retrieves the connection string from the environment (using
+// newDatabase retrieves the connection string from the environment (using
 // TestConnStringEnvVar), parses it, and injects a new database name into it.
-// The new database name is a random name prefixed with scopes_test_, and it
-// will be automatically created in NewStorer. NewStorer also runs migrations,
-// and keeps track of these test databases so they can be deleted automatically
-// later.
-func (f *Factory) NewStorer(ctx context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling wants an interface returned
+// The new database name is a random name prefixed with accounts_test_, and it
+// will be automatically created. newDatabase keeps track of these test
+// databases so they can be deleted automatically later. It returns a
+// connection to the new database and the connection string for it.
+func (f *Factory) newDatabase(_ context.Context) (*sql.DB, string, error) {
 	connString, err := url.Parse(os.Getenv(TestConnStringEnvVar))
 	if err != nil {
 		log.Printf("Error parsing "+TestConnStringEnvVar+" as a URL: %+v\n", err)
-		return nil, err
+		return nil, "", err
 	}
 	if connString.Scheme != "postgres" {
-		return nil, errors.New(TestConnStringEnvVar + " must begin with postgres://") //nolint:goerr113 // not going to be handled, for logging purposes only
+		return nil, "", errors.New(TestConnStringEnvVar + " must begin with postgres://") //nolint:goerr113 // not going to be handled, for logging purposes only
 	}
 
 	tableSuffix, err := uuid.GenerateRandomBytes(6) //nolint:gomnd // not magic, just arbitrary
 	if err != nil {
 		log.Printf("Error generating table suffix: %+v\n", err)
-		return nil, err
+		return nil, "", err
 	}
 	table := "accounts_test_" + hex.EncodeToString(tableSuffix)
 
 	_, err = f.db.Exec("CREATE DATABASE " + table + ";")
 	if err != nil {
 		log.Printf("Error creating database %s: %+v\n", table, err)
-		return nil, err
+		return nil, "", err
 	}
 
 	connString.Path = "/" + table
 	newConn, err := sql.Open("postgres", connString.String())
 	if err != nil {
 		log.Println("Accidentally orphaned", table, "it will need to be cleaned up manually")
-		return nil, err
+		return nil, "", err
 	}
 
 	f.lock.Lock()
 	f.databases[table] = newConn
 	f.lock.Unlock()
 
-	migs := &migrate.AssetMigrationSource{
-		Asset:    migrations.Asset,
-		AssetDir: migrations.AssetDir,
-		Dir:      "sql",
+	return newConn, connString.String(), nil
+}
+
+// NewStorer creates a new test database using newDatabase, runs migrations
+// against it, and returns a Storer backed by it.
+func (f *Factory) NewStorer(ctx context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling wants an interface returned
+	newConn, connString, err := f.newDatabase(ctx)
+	if err != nil {
+		return nil, err
 	}
-	_, err = migrate.Exec(newConn, "postgres", migs, migrate.Up)
+
+	_, err = Migrate(ctx, newConn, MigrateUp)
 	if err != nil {
 		return nil, err
 	}
 
-	storer := NewStorer(ctx, newConn)
+	storer := NewWatchingStorer(ctx, newConn, connString)
 
 	return storer, nil
 }
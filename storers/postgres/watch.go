@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"darlinggo.co/pan"
+	"github.com/lib/pq"
+	"yall.in"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// eventsChannel is the channel the database sends notifications on
+	// when a Scope changes.
+	eventsChannel = "scope_events"
+
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	// listenerPingInterval is how often a listener with no notifications
+	// checks its connection is still alive and catches up on any Events
+	// it may have missed.
+	listenerPingInterval = 90 * time.Second
+
+	// eventRetentionTable holds the Revision of the most recent Event
+	// that was discarded to keep the number of retained Events bounded.
+	eventRetentionTable = "scope_event_retention"
+)
+
+var (
+	// ErrWatchUnavailable is returned when Watch is called on a Storer
+	// that wasn't created with NewWatchingStorer.
+	// It wraps scopes.ErrWatchUnsupported.
+	ErrWatchUnavailable = fmt.Errorf("%w: storer was not created with a connection string to watch with", scopes.ErrWatchUnsupported)
+)
+
+func eventsAfterSQL(_ context.Context, revision uint64) *pan.Query {
+	var event Event
+	q := pan.New("SELECT " + pan.Columns(event).String() + " FROM " + pan.Table(event))
+	q.Where()
+	q.Comparison(event, "Revision", ">", int64(revision))
+	q.OrderBy(pan.Column(event, "Revision"))
+	return q.Flush(" ")
+}
+
+func (s *Storer) eventsAfter(ctx context.Context, revision uint64) ([]scopes.Event, error) {
+	query := eventsAfterSQL(ctx, revision)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, fmt.Errorf("error querying events: %w", err)
+	}
+	defer closeRows(ctx, rows)
+	var results []scopes.Event
+	for rows.Next() {
+		var event Event
+		err = pan.Unmarshal(rows, &event)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling event: %w", err)
+		}
+		results = append(results, eventFromPostgres(event))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying events: %w", err)
+	}
+	return results, nil
+}
+
+func currentRevisionSQL(_ context.Context) *pan.Query {
+	var event Event
+	return pan.New("SELECT COALESCE(MAX(" + pan.Column(event, "Revision") + "), 0) FROM " + pan.Table(event))
+}
+
+// CurrentRevision returns the Revision of the most recent Event recorded in
+// the database, or 0 if no Events have been recorded.
+func (s *Storer) CurrentRevision(ctx context.Context) (uint64, error) {
+	query := currentRevisionSQL(ctx)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, fmt.Errorf("error generating SQL: %w", err)
+	}
+	var revision int64
+	err = s.conn().QueryRowContext(ctx, queryStr, query.Args()...).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("error querying revision: %w", err)
+	}
+	return uint64(revision), nil
+}
+
+func compactedThroughSQL(_ context.Context) *pan.Query {
+	return pan.New("SELECT compacted_through FROM " + eventRetentionTable)
+}
+
+// compactedThrough returns the Revision of the most recent Event that has
+// been discarded, or 0 if none have been.
+func (s *Storer) compactedThrough(ctx context.Context) (uint64, error) {
+	query := compactedThroughSQL(ctx)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return 0, fmt.Errorf("error generating SQL: %w", err)
+	}
+	var revision int64
+	err = s.conn().QueryRowContext(ctx, queryStr, query.Args()...).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("error querying compacted revision: %w", err)
+	}
+	return uint64(revision), nil
+}
+
+// Watch returns a channel that receives every Event recorded in the database
+// with a Revision greater than `fromRevision`, followed by new Events as they
+// are recorded. New Events are detected using LISTEN/NOTIFY, so the Storer
+// must have been created using NewWatchingStorer. The channel is closed when
+// `ctx` is canceled, or if the watcher falls so far behind that Events it
+// hasn't received are discarded. Storers passed to WithTx callbacks watch for
+// committed Events outside of their transaction. Only the most recent
+// maxEvents Events are retained; if Events after `fromRevision` have been
+// discarded, scopes.ErrRevisionCompacted is returned.
+func (s *Storer) Watch(ctx context.Context, fromRevision uint64) (<-chan scopes.Event, error) {
+	if s.connString == "" {
+		return nil, ErrWatchUnavailable
+	}
+	// the watch outlives any transaction we're in
+	s = &Storer{db: s.db, connString: s.connString}
+	compacted, err := s.compactedThrough(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if compacted > fromRevision {
+		return nil, scopes.ErrRevisionCompacted
+	}
+	log := yall.FromContext(ctx)
+	listener := pq.NewListener(s.connString, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.WithError(err).WithField("listener_event", ev).Warn("scope event listener error")
+		}
+	})
+	// start listening before we catch up, so no Events are missed
+	// between catching up and listening
+	err = listener.Listen(eventsChannel)
+	if err != nil {
+		if closeErr := listener.Close(); closeErr != nil {
+			log.WithError(closeErr).Error("error closing listener")
+		}
+		return nil, fmt.Errorf("error listening for events: %w", err)
+	}
+	events := make(chan scopes.Event)
+	go s.watch(ctx, listener, fromRevision, events)
+	return events, nil
+}
+
+func (s *Storer) watch(ctx context.Context, listener *pq.Listener, last uint64, events chan<- scopes.Event) {
+	log := yall.FromContext(ctx)
+	defer close(events)
+	defer func() {
+		if err := listener.Close(); err != nil {
+			log.WithError(err).Error("error closing listener")
+		}
+	}()
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+	for {
+		results, err := s.eventsAfter(ctx, last)
+		if err != nil {
+			log.WithError(err).Error("error retrieving events")
+			return
+		}
+		// check after retrieving, so Events discarded while we
+		// were retrieving them can't be skipped unnoticed
+		compacted, err := s.compactedThrough(ctx)
+		if err != nil {
+			log.WithError(err).Error("error checking retained events")
+			return
+		}
+		if compacted > last {
+			log.WithField("revision", last).Warn("watcher fell behind retained events")
+			return
+		}
+		for _, event := range results {
+			select {
+			case events <- event:
+				last = event.Revision
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// either a new Event or a reconnection, which
+			// may have missed Events; catch up either way
+		case <-ticker.C:
+			if err := listener.Ping(); err != nil {
+				log.WithError(err).Warn("error pinging listener")
+			}
+		}
+	}
+}
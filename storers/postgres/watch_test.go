@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+func TestWatchCompacted(t *testing.T) {
+	if os.Getenv(TestConnStringEnvVar) == "" {
+		t.Skip(TestConnStringEnvVar + " not set, skipping PostgreSQL tests")
+	}
+	t.Parallel()
+	ctx := context.Background()
+
+	control, err := sql.Open("postgres", os.Getenv(TestConnStringEnvVar))
+	if err != nil {
+		t.Fatalf("Error connecting to database: %s", err)
+	}
+	factory := NewFactory(control)
+	defer func() {
+		if err := factory.TeardownStorers(); err != nil {
+			t.Errorf("Error cleaning up databases: %s", err)
+		}
+	}()
+	storer, err := factory.NewStorer(ctx)
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	pgStorer := storer.(*Storer) //nolint:forcetypeassert // the Factory only returns *Storer
+
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/compacted",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+	for i := 0; i < maxEvents+5; i++ {
+		isDefault := i%2 == 0
+		_, err = storer.Update(ctx, scope.ID, scopes.Change{IsDefault: &isDefault})
+		if err != nil {
+			t.Fatalf("Error updating scope: %s", err)
+		}
+	}
+
+	var retained int
+	err = pgStorer.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM scope_events").Scan(&retained)
+	if err != nil {
+		t.Fatalf("Error counting events: %s", err)
+	}
+	if retained > maxEvents {
+		t.Errorf("Expected at most %d events to be retained, got %d", maxEvents, retained)
+	}
+
+	_, err = pgStorer.Watch(ctx, 0)
+	if !errors.Is(err, scopes.ErrRevisionCompacted) {
+		t.Errorf("Expected %v watching from the start, got %v", scopes.ErrRevisionCompacted, err)
+	}
+	current, err := pgStorer.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("Error retrieving current revision: %s", err)
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	_, err = pgStorer.Watch(watchCtx, current-1)
+	if err != nil {
+		t.Errorf("Unexpected error watching from a retained revision: %s", err)
+	}
+}
+
+func TestEventExceptions(t *testing.T) {
+	if os.Getenv(TestConnStringEnvVar) == "" {
+		t.Skip(TestConnStringEnvVar + " not set, skipping PostgreSQL tests")
+	}
+	t.Parallel()
+	ctx := context.Background()
+
+	control, err := sql.Open("postgres", os.Getenv(TestConnStringEnvVar))
+	if err != nil {
+		t.Fatalf("Error connecting to database: %s", err)
+	}
+	factory := NewFactory(control)
+	defer func() {
+		if err := factory.TeardownStorers(); err != nil {
+			t.Errorf("Error cleaning up databases: %s", err)
+		}
+	}()
+	storer, err := factory.NewStorer(ctx)
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	pgStorer := storer.(*Storer) //nolint:forcetypeassert // the Factory only returns *Storer
+
+	created := scopes.Scope{
+		ID:               "https://scopes.impractical.co/events",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"a", "b", "c"},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{"x"},
+	}
+	err = storer.Create(ctx, created)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+
+	// only the changed principals are written
+	userExceptions := []string{"a", "c", "d"}
+	changed, err := storer.Update(ctx, created.ID, scopes.Change{UserExceptions: &userExceptions})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+
+	// a new order rewrites the list
+	userExceptions = []string{"d", "a"}
+	reordered, err := storer.Update(ctx, created.ID, scopes.Change{UserExceptions: &userExceptions})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+
+	policy := scopes.PolicyDefaultAllow
+	policyChanged, err := storer.Update(ctx, created.ID, scopes.Change{ClientPolicy: &policy})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+
+	removed, err := storer.RemoveUserException(ctx, "d")
+	if err != nil {
+		t.Fatalf("Error removing exception: %s", err)
+	}
+	if len(removed) != 1 {
+		t.Fatalf("Expected 1 scope to be changed removing an exception, got %v", removed)
+	}
+
+	_, err = storer.Delete(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Error deleting scope: %s", err)
+	}
+
+	events, err := pgStorer.eventsAfter(ctx, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving events: %s", err)
+	}
+	expected := []scopes.Event{
+		{Type: scopes.EventCreated, Scope: created},
+		{Type: scopes.EventUpdated, Scope: changed, Previous: &created},
+		{Type: scopes.EventUpdated, Scope: reordered, Previous: &changed},
+		{Type: scopes.EventUpdated, Scope: policyChanged, Previous: &reordered},
+		{Type: scopes.EventUpdated, Scope: removed[0], Previous: &policyChanged},
+		{Type: scopes.EventDeleted, Scope: removed[0]},
+	}
+	if diff := cmp.Diff(expected, events, cmpopts.IgnoreFields(scopes.Event{}, "Revision"), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff in events (-wanted, +got): %s", diff)
+	}
+}
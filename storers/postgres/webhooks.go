@@ -0,0 +1,360 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"darlinggo.co/pan"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/lib/pq"
+	"yall.in"
+
+	"lockbox.dev/scopes/webhooks"
+)
+
+// Webhook is a representation of the webhooks.Webhook type that is suitable
+// to be stored in a PostgreSQL database.
+type Webhook struct {
+	ID        string    `sql_column:"id"`
+	URL       string    `sql_column:"url"`
+	Secret    string    `sql_column:"secret"`
+	CreatedAt time.Time `sql_column:"created_at"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Webhook) GetSQLTableName() string {
+	return "webhooks"
+}
+
+// Delivery is a representation of the webhooks.Delivery type that is
+// suitable to be stored in a PostgreSQL database.
+type Delivery struct {
+	ID            string    `sql_column:"id"`
+	WebhookID     string    `sql_column:"webhook_id"`
+	Revision      int64     `sql_column:"revision"`
+	Body          []byte    `sql_column:"body"`
+	State         string    `sql_column:"state"`
+	Attempts      int       `sql_column:"attempts"`
+	NextAttemptAt time.Time `sql_column:"next_attempt_at"`
+	LastError     string    `sql_column:"last_error"`
+	CreatedAt     time.Time `sql_column:"created_at"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Delivery) GetSQLTableName() string {
+	return "webhook_deliveries"
+}
+
+func webhookFromPostgres(webhook Webhook) webhooks.Webhook {
+	return webhooks.Webhook(webhook)
+}
+
+func webhookToPostgres(webhook webhooks.Webhook) Webhook {
+	return Webhook(webhook)
+}
+
+func deliveryFromPostgres(delivery Delivery) webhooks.Delivery {
+	return webhooks.Delivery{
+		ID:            delivery.ID,
+		WebhookID:     delivery.WebhookID,
+		Revision:      uint64(delivery.Revision),
+		Body:          delivery.Body,
+		State:         delivery.State,
+		Attempts:      delivery.Attempts,
+		NextAttemptAt: delivery.NextAttemptAt,
+		LastError:     delivery.LastError,
+		CreatedAt:     delivery.CreatedAt,
+	}
+}
+
+// WebhookStorer is an implementation of the webhooks.Storer interface that
+// stores data in a PostgreSQL database, acting as a durable outbox for
+// Deliveries.
+type WebhookStorer struct {
+	db *sql.DB
+}
+
+// NewWebhookStorer returns a WebhookStorer instance that is backed by the
+// specified *sql.DB. The returned WebhookStorer instance is ready to be used
+// as a webhooks.Storer.
+func NewWebhookStorer(_ context.Context, conn *sql.DB) *WebhookStorer {
+	return &WebhookStorer{db: conn}
+}
+
+// CreateWebhook inserts the passed Webhook into the database, returning an
+// ErrWebhookAlreadyExists error if a Webhook with the same ID already exists
+// in the database.
+func (s *WebhookStorer) CreateWebhook(ctx context.Context, webhook webhooks.Webhook) error {
+	query := pan.Insert(webhookToPostgres(webhook))
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating insert SQL: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, queryStr, query.Args()...)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == "webhooks_pkey" {
+		return webhooks.ErrWebhookAlreadyExists
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting webhook: %w", err)
+	}
+	return nil
+}
+
+func listWebhooksSQL(_ context.Context) *pan.Query {
+	var webhook Webhook
+	query := pan.New("SELECT " + pan.Columns(webhook).String() + " FROM " + pan.Table(webhook))
+	query.OrderBy(pan.Column(webhook, "ID"))
+	return query.Flush(" ")
+}
+
+func scanWebhooks(rows *sql.Rows) ([]webhooks.Webhook, error) {
+	var results []webhooks.Webhook
+	for rows.Next() {
+		var hook Webhook
+		err := pan.Unmarshal(rows, &hook)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling webhook: %w", err)
+		}
+		results = append(results, webhookFromPostgres(hook))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying webhooks: %w", err)
+	}
+	return results, nil
+}
+
+// ListWebhooks returns all the Webhooks in the database, sorted by ID.
+func (s *WebhookStorer) ListWebhooks(ctx context.Context) ([]webhooks.Webhook, error) {
+	query := listWebhooksSQL(ctx)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhooks: %w", err)
+	}
+	defer closeRows(ctx, rows)
+	return scanWebhooks(rows)
+}
+
+// DeleteWebhook removes the Webhook that matches the specified ID, and all
+// its Deliveries, from the database, returning an ErrWebhookNotFound error if
+// no Webhook matches the specified ID.
+func (s *WebhookStorer) DeleteWebhook(ctx context.Context, id string) error {
+	var webhook Webhook
+	query := pan.New("DELETE FROM " + pan.Table(webhook))
+	query.Where()
+	query.Comparison(webhook, "ID", "=", id)
+	query.Flush(" ")
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating delete SQL: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error counting deleted webhooks: %w", err)
+	}
+	if affected < 1 {
+		return webhooks.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func setCursorSQL(_ context.Context, revision uint64) *pan.Query {
+	query := pan.New("INSERT INTO webhook_cursor (revision)")
+	query.Expression("VALUES (?)", int64(revision))
+	query.Expression("ON CONFLICT (id) DO UPDATE SET revision = EXCLUDED.revision")
+	return query.Flush(" ")
+}
+
+// Enqueue atomically creates a pending Delivery of `body` to every Webhook in
+// the database and records `revision` as the cursor.
+func (s *WebhookStorer) Enqueue(ctx context.Context, revision uint64, body []byte, at time.Time) (retErr error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if err := tx.Rollback(); err != nil {
+			yall.FromContext(ctx).WithError(err).Error("error rolling back transaction")
+		}
+	}()
+
+	// lock the webhooks so none can be deleted before we've
+	// created their deliveries
+	listQuery := listWebhooksSQL(ctx)
+	listQueryStr, err := listQuery.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating SQL: %w", err)
+	}
+	listQueryStr = strings.TrimSuffix(listQueryStr, ";") + " FOR SHARE"
+	rows, err := tx.QueryContext(ctx, listQueryStr, listQuery.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return fmt.Errorf("error querying webhooks: %w", err)
+	}
+	hooks, err := scanWebhooks(rows)
+	closeRows(ctx, rows)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return fmt.Errorf("error generating delivery ID: %w", err)
+		}
+		query := pan.Insert(Delivery{
+			ID:            id,
+			WebhookID:     hook.ID,
+			Revision:      int64(revision),
+			Body:          body,
+			State:         webhooks.DeliveryPending,
+			NextAttemptAt: at,
+			CreatedAt:     at,
+		})
+		queryStr, err := query.PostgreSQLString()
+		if err != nil {
+			return fmt.Errorf("error generating insert SQL: %w", err)
+		}
+		// a Delivery for this revision may already exist if the
+		// cursor wasn't recorded after a previous Enqueue
+		queryStr = strings.TrimSuffix(queryStr, ";") + " ON CONFLICT (webhook_id, revision) DO NOTHING"
+		_, err = tx.ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error inserting delivery: %w", err)
+		}
+	}
+	query := setCursorSQL(ctx, revision)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating cursor SQL: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error setting cursor: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// Cursor returns the revision last recorded by Enqueue or SetCursor, or 0 if
+// none has been recorded.
+func (s *WebhookStorer) Cursor(ctx context.Context) (uint64, error) {
+	var revision int64
+	err := s.db.QueryRowContext(ctx, "SELECT revision FROM webhook_cursor").Scan(&revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error querying cursor: %w", err)
+	}
+	return uint64(revision), nil
+}
+
+// SetCursor records `revision` as the cursor.
+func (s *WebhookStorer) SetCursor(ctx context.Context, revision uint64) error {
+	query := setCursorSQL(ctx, revision)
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating cursor SQL: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error setting cursor: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStorer) listDeliveries(ctx context.Context, query *pan.Query) ([]webhooks.Delivery, error) {
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, fmt.Errorf("error querying deliveries: %w", err)
+	}
+	defer closeRows(ctx, rows)
+	var results []webhooks.Delivery
+	for rows.Next() {
+		var delivery Delivery
+		err = pan.Unmarshal(rows, &delivery)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling delivery: %w", err)
+		}
+		results = append(results, deliveryFromPostgres(delivery))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying deliveries: %w", err)
+	}
+	return results, nil
+}
+
+// DueDeliveries returns up to `limit` pending Deliveries that should be
+// attempted at or before `now`, oldest first.
+func (s *WebhookStorer) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]webhooks.Delivery, error) {
+	var delivery Delivery
+	query := pan.New("SELECT " + pan.Columns(delivery).String() + " FROM " + pan.Table(delivery))
+	query.Where()
+	query.Comparison(delivery, "State", "=", webhooks.DeliveryPending)
+	query.Comparison(delivery, "NextAttemptAt", "<=", now)
+	query.Flush(" AND ")
+	query.OrderBy(pan.Column(delivery, "NextAttemptAt") + ", " + pan.Column(delivery, "Revision"))
+	query.Limit(int64(limit))
+	query.Flush(" ")
+	return s.listDeliveries(ctx, query)
+}
+
+// UpdateDelivery records the state, attempts, next attempt time, and last
+// error of `delivery`.
+func (s *WebhookStorer) UpdateDelivery(ctx context.Context, delivery webhooks.Delivery) error {
+	var pgDelivery Delivery
+	query := pan.New("UPDATE " + pan.Table(pgDelivery) + " SET ")
+	query.Comparison(pgDelivery, "State", "=", delivery.State)
+	query.Comparison(pgDelivery, "Attempts", "=", delivery.Attempts)
+	query.Comparison(pgDelivery, "NextAttemptAt", "=", delivery.NextAttemptAt)
+	query.Comparison(pgDelivery, "LastError", "=", delivery.LastError)
+	query.Flush(", ")
+	query.Where()
+	query.Comparison(pgDelivery, "ID", "=", delivery.ID)
+	query.Flush(" ")
+	queryStr, err := query.PostgreSQLString()
+	if err != nil {
+		return fmt.Errorf("error generating update SQL: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, queryStr, query.Args()...)
+	if err != nil {
+		return fmt.Errorf("error updating delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeadDeliveries returns all the Deliveries that will not be attempted
+// again, newest first.
+func (s *WebhookStorer) ListDeadDeliveries(ctx context.Context) ([]webhooks.Delivery, error) {
+	var delivery Delivery
+	query := pan.New("SELECT " + pan.Columns(delivery).String() + " FROM " + pan.Table(delivery))
+	query.Where()
+	query.Comparison(delivery, "State", "=", webhooks.DeliveryDead)
+	query.OrderByDesc(pan.Column(delivery, "Revision"))
+	query.Flush(" ")
+	return s.listDeliveries(ctx, query)
+}
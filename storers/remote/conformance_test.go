@@ -0,0 +1,13 @@
+package remote
+
+import (
+	"testing"
+
+	"lockbox.dev/scopes/storertest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storertest.RunConformance(t, NewFactory())
+}
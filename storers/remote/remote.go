@@ -0,0 +1,419 @@
+// Package remote provides an implementation of the scopes.Storer interface
+// that stores data by making requests to a server running v1 of the scopes
+// API.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"darlinggo.co/api"
+	"yall.in"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+)
+
+const (
+	// listPageSize is the number of Scopes requested at a time when
+	// listing every Scope.
+	listPageSize = 100
+	// maxListPageSize is the largest number of Scopes the server will
+	// return in a single page.
+	maxListPageSize = 1000
+)
+
+// UnexpectedResponseError is returned when the server responds to a request
+// in a way the Storer doesn't know how to handle.
+type UnexpectedResponseError struct {
+	Status int
+	Errors []api.RequestError
+}
+
+func (e UnexpectedResponseError) Error() string {
+	return fmt.Sprintf("unexpected response from server: status %d, errors %+v", e.Status, e.Errors)
+}
+
+// Storer is an implementation of the Storer interface
+// that stores data by making HTTP requests to v1 of the
+// scopes API.
+type Storer struct {
+	client  *http.Client
+	baseURL string
+	signer  hmac.Signer
+}
+
+// NewStorer returns a Storer instance that will make requests to the server
+// at `baseURL`, the root path of v1 of the API, using `client`. Every request
+// will be signed using `signer`. If `client` is nil, http.DefaultClient will
+// be used. The returned Storer instance is ready to be used as a Storer.
+func NewStorer(_ context.Context, client *http.Client, baseURL string, signer hmac.Signer) *Storer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Storer{
+		client:  client,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		signer:  signer,
+	}
+}
+
+// do builds a request for `method` and `path`, signs it using `payload` as
+// the signed content, and sends it to the server. If `body` is true,
+// `payload` is also sent as the request body. The decoded response is
+// returned, with its Status set to the status code of the response.
+func (s *Storer) do(ctx context.Context, method, path, payload string, body bool) (apiv1.Response, error) {
+	var reqBody string
+	if body {
+		reqBody = payload
+	}
+	resp, err := s.send(ctx, method, path, payload, reqBody, "application/json")
+	if err != nil {
+		return apiv1.Response{}, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			yall.FromContext(ctx).WithError(err).Error("failed to close response body")
+		}
+	}()
+	return decodeResponse(resp)
+}
+
+// send builds a request for `method` and `path`, signs it using `payload` as
+// the signed content, and sends it to the server, returning the response for
+// the caller to read and close. If `body` isn't empty, it's sent as the
+// request body with `contentType` as its Content-Type.
+func (s *Storer) send(ctx context.Context, method, path, payload, body, contentType string) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = bytes.NewBufferString(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error building request: %w", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Authorization", s.signer.Sign(req, apiv1.ContentHash(payload)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	return resp, nil
+}
+
+// decodeResponse reads the body of `resp` as an apiv1.Response, with its
+// Status set to the status code of the response.
+func decodeResponse(resp *http.Response) (apiv1.Response, error) {
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return apiv1.Response{Status: resp.StatusCode}, fmt.Errorf("error reading response: %w", err)
+	}
+	var result apiv1.Response
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		// validation errors are returned as a bare list of
+		// errors, not wrapped in a Response
+		var reqErrs []api.RequestError
+		if json.Unmarshal(respBody, &reqErrs) != nil {
+			return apiv1.Response{Status: resp.StatusCode}, UnexpectedResponseError{Status: resp.StatusCode}
+		}
+		result.Errors = reqErrs
+	}
+	result.Status = resp.StatusCode
+	return result, nil
+}
+
+func hasError(errs []api.RequestError, match api.RequestError) bool {
+	for _, err := range errs {
+		if err == match {
+			return true
+		}
+	}
+	return false
+}
+
+func scopePath(id string) string {
+	return "/" + url.PathEscape(id)
+}
+
+// Create inserts the passed Scope into the server,
+// returning an ErrScopeAlreadyExists error if a Scope
+// with the same ID already exists on the server.
+func (s *Storer) Create(ctx context.Context, scope scopes.Scope) error {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(apiScope(scope))
+	if err != nil {
+		return fmt.Errorf("error encoding scope: %w", err)
+	}
+	resp, err := s.do(ctx, http.MethodPost, "/", string(payload), true)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.Status == http.StatusCreated:
+		return nil
+	case resp.Status == http.StatusBadRequest && hasError(resp.Errors, api.RequestError{Field: "/id", Slug: api.RequestErrConflict}):
+		return scopes.ErrScopeAlreadyExists
+	default:
+		return UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+}
+
+// Put stores the passed Scope on the server, creating it or replacing the
+// existing Scope with the same ID, and returns true if it was created.
+func (s *Storer) Put(ctx context.Context, scope scopes.Scope) (bool, error) {
+	if err := scopes.ValidateIDs(scope); err != nil {
+		return false, err
+	}
+	payload, err := json.Marshal(apiScope(scope))
+	if err != nil {
+		return false, fmt.Errorf("error encoding scope: %w", err)
+	}
+	resp, err := s.do(ctx, http.MethodPut, scopePath(scope.ID), string(payload), true)
+	if err != nil {
+		return false, err
+	}
+	switch resp.Status {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	default:
+		return false, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+}
+
+// ApplyBatch applies `ops` on the server in a single request, following the
+// rules of scopes.ApplyBatch. If an Operation fails because its Scope already
+// exists, doesn't exist, or doesn't match its Expected Scope, a
+// scopes.BatchError is returned. If the server's Storer can't apply batches,
+// scopes.ErrBatchUnsupported is returned.
+func (s *Storer) ApplyBatch(ctx context.Context, ops []scopes.Operation) ([]scopes.Scope, error) {
+	batch := apiv1.Batch{Operations: make([]apiv1.Operation, 0, len(ops))}
+	for _, op := range ops {
+		batch.Operations = append(batch.Operations, apiOperation(op))
+	}
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding batch: %w", err)
+	}
+	resp, err := s.do(ctx, http.MethodPost, "/batch", string(payload), true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == http.StatusOK {
+		results := make([]scopes.Scope, 0, len(resp.Scopes))
+		for _, scope := range resp.Scopes {
+			results = append(results, coreScope(scope))
+		}
+		return results, nil
+	}
+	if resp.Status == http.StatusNotImplemented && len(resp.Errors) == 1 && resp.Errors[0].Slug == apiv1.RequestErrUnsupported {
+		return nil, scopes.ErrBatchUnsupported
+	}
+	if resp.Status != http.StatusBadRequest || len(resp.Errors) != 1 {
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	var index int
+	var field string
+	_, err = fmt.Sscanf(resp.Errors[0].Field, "/operations/%d/%s", &index, &field)
+	if err != nil {
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	switch {
+	case field == "id" && resp.Errors[0].Slug == api.RequestErrConflict:
+		return nil, scopes.BatchError{Index: index, Err: scopes.ErrScopeAlreadyExists}
+	case field == "id" && resp.Errors[0].Slug == api.RequestErrNotFound:
+		return nil, scopes.BatchError{Index: index, Err: scopes.ErrScopeNotFound}
+	case field == "expected" && resp.Errors[0].Slug == api.RequestErrConflict:
+		return nil, scopes.BatchError{Index: index, Err: scopes.ErrScopeChanged}
+	default:
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+}
+
+// GetMulti retrieves the Scopes specified by the passed IDs
+// from the server, returning an empty map if no matching
+// Scopes are found. If a Scope is not found, no error will
+// be returned, it will just be omitted from the map.
+func (s *Storer) GetMulti(ctx context.Context, ids []string) (map[string]scopes.Scope, error) {
+	results := map[string]scopes.Scope{}
+	if len(ids) < 1 {
+		return results, nil
+	}
+	query := url.Values{"id": ids}.Encode()
+	resp, err := s.do(ctx, http.MethodGet, "/?"+query, "LIST,"+query, false)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != http.StatusOK {
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	for _, scope := range resp.Scopes {
+		results[scope.ID] = coreScope(scope)
+	}
+	return results, nil
+}
+
+// Update applies the passed Change to the Scope that matches the specified ID
+// on the server and returns the updated Scope. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Update(ctx context.Context, id string, change scopes.Change) (scopes.Scope, error) {
+	if err := scopes.ValidateChangeIDs(change); err != nil {
+		return scopes.Scope{}, err
+	}
+	payload, err := json.Marshal(apiChange(change))
+	if err != nil {
+		return scopes.Scope{}, fmt.Errorf("error encoding change: %w", err)
+	}
+	resp, err := s.do(ctx, http.MethodPatch, scopePath(id), string(payload), true)
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	return singleScope(resp)
+}
+
+// Delete removes the Scope that matches the specified ID from the server and
+// returns the Scope as it was before it was deleted. If no Scope matches the
+// specified ID, scopes.ErrScopeNotFound is returned.
+func (s *Storer) Delete(ctx context.Context, id string) (scopes.Scope, error) {
+	resp, err := s.do(ctx, http.MethodDelete, scopePath(id), "DELETE,"+id, false)
+	if err != nil {
+		return scopes.Scope{}, err
+	}
+	return singleScope(resp)
+}
+
+// singleScope returns the only Scope in a successful `resp`, translating a
+// 404 into scopes.ErrScopeNotFound.
+func singleScope(resp apiv1.Response) (scopes.Scope, error) {
+	switch {
+	case resp.Status == http.StatusNotFound:
+		return scopes.Scope{}, scopes.ErrScopeNotFound
+	case resp.Status != http.StatusOK || len(resp.Scopes) != 1:
+		return scopes.Scope{}, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	return coreScope(resp.Scopes[0]), nil
+}
+
+// ListDefault returns all the Scopes with IsDefault set to true.
+// sorted lexicographically by their ID.
+func (s *Storer) ListDefault(ctx context.Context) ([]scopes.Scope, error) {
+	return s.list(ctx, url.Values{"default": []string{"true"}})
+}
+
+// List returns up to `limit` Scopes, sorted lexicographically by their ID,
+// starting with the first Scope whose ID sorts after `after`. If `limit` is
+// not positive, every Scope after `after` is returned. Scopes are retrieved
+// from the server a page at a time, so limits larger than the server allows
+// in one request work too.
+func (s *Storer) List(ctx context.Context, after string, limit int) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	for {
+		size := listPageSize
+		if limit > 0 {
+			size = limit - len(results)
+			if size > maxListPageSize {
+				size = maxListPageSize
+			}
+		}
+		page, err := s.listPage(ctx, after, size)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page...)
+		if len(page) < size || (limit > 0 && len(results) >= limit) {
+			return results, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+func (s *Storer) listPage(ctx context.Context, after string, limit int) ([]scopes.Scope, error) {
+	params := url.Values{
+		"all":   []string{"true"},
+		"limit": []string{strconv.Itoa(limit)},
+	}
+	if after != "" {
+		params.Set("after", after)
+	}
+	return s.list(ctx, params)
+}
+
+// ListByUserException returns all the Scopes that list `userID` in their
+// UserExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	return s.list(ctx, url.Values{"userException": []string{userID}})
+}
+
+// ListByClientException returns all the Scopes that list `clientID` in their
+// ClientExceptions, sorted lexicographically by their ID.
+func (s *Storer) ListByClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.list(ctx, url.Values{"clientException": []string{clientID}})
+}
+
+// RemoveUserException removes `userID` from the UserExceptions of every
+// Scope on the server, and returns the Scopes that were changed, sorted
+// lexicographically by their ID.
+func (s *Storer) RemoveUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	return s.removeException(ctx, "/exceptions/users/"+url.PathEscape(userID), "REMOVE_USER_EXCEPTION,"+userID)
+}
+
+// RemoveClientException removes `clientID` from the ClientExceptions of
+// every Scope on the server, and returns the Scopes that were changed, sorted
+// lexicographically by their ID.
+func (s *Storer) RemoveClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.removeException(ctx, "/exceptions/clients/"+url.PathEscape(clientID), "REMOVE_CLIENT_EXCEPTION,"+clientID)
+}
+
+func (s *Storer) removeException(ctx context.Context, path, payload string) ([]scopes.Scope, error) {
+	resp, err := s.do(ctx, http.MethodDelete, path, payload, false)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != http.StatusOK {
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	results := make([]scopes.Scope, 0, len(resp.Scopes))
+	for _, scope := range resp.Scopes {
+		results = append(results, coreScope(scope))
+	}
+	scopes.ByID(results)
+	return results, nil
+}
+
+// list returns the Scopes the server lists for the filter in `params`,
+// sorted lexicographically by their ID.
+func (s *Storer) list(ctx context.Context, params url.Values) ([]scopes.Scope, error) {
+	query := params.Encode()
+	resp, err := s.do(ctx, http.MethodGet, "/?"+query, "LIST,"+query, false)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != http.StatusOK {
+		return nil, UnexpectedResponseError{Status: resp.Status, Errors: resp.Errors}
+	}
+	var results []scopes.Scope
+	for _, scope := range resp.Scopes {
+		results = append(results, coreScope(scope))
+	}
+	scopes.ByID(results)
+	return results, nil
+}
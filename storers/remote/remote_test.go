@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"lockbox.dev/scopes"
+)
+
+func TestListPagesPastServerLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	factory := NewFactory()
+	t.Cleanup(func() {
+		if err := factory.TeardownStorers(); err != nil {
+			t.Errorf("Error tearing down servers: %s", err)
+		}
+	})
+	storer, err := factory.NewStorer(ctx)
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	const created = maxListPageSize + 50
+	for i := 0; i < created; i++ {
+		err = storer.Create(ctx, scopes.Scope{
+			ID:           fmt.Sprintf("https://scopes.impractical.co/list/%04d", i),
+			UserPolicy:   scopes.PolicyAllowAll,
+			ClientPolicy: scopes.PolicyAllowAll,
+		})
+		if err != nil {
+			t.Fatalf("Error creating scope %d: %s", i, err)
+		}
+	}
+
+	for limit, expected := range map[int]int{
+		maxListPageSize + 20: maxListPageSize + 20,
+		5000:                 created,
+		0:                    created,
+	} {
+		results, err := storer.List(ctx, "", limit)
+		if err != nil {
+			t.Fatalf("Error listing %d scopes: %s", limit, err)
+		}
+		if len(results) != expected {
+			t.Errorf("Expected %d scopes listing %d, got %d", expected, limit, len(results))
+			continue
+		}
+		for pos, scope := range results {
+			if want := fmt.Sprintf("https://scopes.impractical.co/list/%04d", pos); scope.ID != want {
+				t.Errorf("Expected scope %d listing %d to be %q, got %q", pos, limit, want, scope.ID)
+				break
+			}
+		}
+	}
+}
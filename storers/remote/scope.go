@@ -0,0 +1,67 @@
+package remote
+
+import (
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+)
+
+func coreScope(scope apiv1.Scope) scopes.Scope {
+	return scopes.Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func apiScope(scope scopes.Scope) apiv1.Scope {
+	return apiv1.Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func apiChange(change scopes.Change) apiv1.Change {
+	return apiv1.Change{
+		UserPolicy:       change.UserPolicy,
+		UserExceptions:   change.UserExceptions,
+		ClientPolicy:     change.ClientPolicy,
+		ClientExceptions: change.ClientExceptions,
+		IsDefault:        change.IsDefault,
+	}
+}
+
+func apiOperation(op scopes.Operation) apiv1.Operation {
+	res := apiv1.Operation{
+		Type: string(op.Type),
+		ID:   op.ID,
+	}
+	if op.Expected != nil {
+		expected := apiScope(*op.Expected)
+		res.Expected = &expected
+	}
+	switch op.Type {
+	case scopes.OperationCreate:
+		scope := op.Scope
+		res.ID = scope.ID
+		res.UserPolicy = &scope.UserPolicy
+		res.UserExceptions = &scope.UserExceptions
+		res.ClientPolicy = &scope.ClientPolicy
+		res.ClientExceptions = &scope.ClientExceptions
+		res.IsDefault = &scope.IsDefault
+	case scopes.OperationUpdate:
+		change := apiChange(op.Change)
+		res.UserPolicy = change.UserPolicy
+		res.UserExceptions = change.UserExceptions
+		res.ClientPolicy = change.ClientPolicy
+		res.ClientExceptions = change.ClientExceptions
+		res.IsDefault = change.IsDefault
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package remote
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"yall.in"
+	"yall.in/colour"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/apiv1"
+	"lockbox.dev/scopes/storers/memory"
+)
+
+// Factory is a generator of Storers for testing purposes. It knows how to
+// start, track, and clean up in-process API servers, backed by in-memory
+// Storers, that tests can be run against.
+type Factory struct {
+	servers []*httptest.Server
+	lock    sync.Mutex
+}
+
+// NewFactory returns a Factory that is ready to be used.
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+// NewStorer starts a new API server backed by a new, isolated, in-memory
+// Storer, and returns a Storer that makes requests against that server. The
+// server is tracked so it can be shut down automatically later.
+func (f *Factory) NewStorer(ctx context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling wants an interface returned
+	backend, err := memory.NewStorer()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := uuid.GenerateRandomBytes(32) //nolint:gomnd // not magic, just arbitrary
+	if err != nil {
+		return nil, err
+	}
+	signer := hmac.Signer{
+		Key:     "test",
+		Secret:  secret,
+		MaxSkew: time.Minute,
+	}
+	v1 := apiv1.APIv1{
+		Dependencies: scopes.Dependencies{Storer: backend},
+		Log:          yall.New(colour.New(ioutil.Discard, yall.Error)),
+		Signer:       signer,
+	}
+	server := httptest.NewServer(v1.Server("/"))
+
+	f.lock.Lock()
+	f.servers = append(f.servers, server)
+	f.lock.Unlock()
+
+	return NewStorer(ctx, server.Client(), server.URL, signer), nil
+}
+
+// TeardownStorers shuts down all the tracked servers started by NewStorer.
+func (f *Factory) TeardownStorers() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, server := range f.servers {
+		server.Close()
+	}
+	f.servers = nil
+	return nil
+}
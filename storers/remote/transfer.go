@@ -0,0 +1,88 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"darlinggo.co/api"
+	"yall.in"
+
+	"lockbox.dev/scopes"
+)
+
+// Export writes every Scope on the server to `w`, in the format written by
+// scopes.Export, streaming it from the server as it's received.
+func (s *Storer) Export(ctx context.Context, w io.Writer) error {
+	resp, err := s.send(ctx, http.MethodGet, "/export", "EXPORT,", "", "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			yall.FromContext(ctx).WithError(err).Error("failed to close response body")
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		decoded, err := decodeResponse(resp)
+		if err != nil {
+			return err
+		}
+		return UnexpectedResponseError{Status: decoded.Status, Errors: decoded.Errors}
+	}
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading export: %w", err)
+	}
+	return nil
+}
+
+// Import sends the export read from `r`, in the format written by
+// scopes.Export, to the server to be imported using `strategy`, following
+// the rules of scopes.Import. If the server rejects a line of the export,
+// a scopes.ImportError is returned, with the Line and Field the server
+// reported.
+func (s *Storer) Import(ctx context.Context, r io.Reader, strategy scopes.ConflictStrategy) (scopes.ImportResult, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return scopes.ImportResult{}, fmt.Errorf("error reading export: %w", err)
+	}
+	query := url.Values{"conflict": []string{string(strategy)}}.Encode()
+	resp, err := s.send(ctx, http.MethodPost, "/import?"+query, "IMPORT,"+query+","+string(body), string(body), "application/x-ndjson")
+	if err != nil {
+		return scopes.ImportResult{}, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			yall.FromContext(ctx).WithError(err).Error("failed to close response body")
+		}
+	}()
+	decoded, err := decodeResponse(resp)
+	if err != nil {
+		return scopes.ImportResult{}, err
+	}
+	if decoded.Status == http.StatusOK && decoded.Imported != nil {
+		return scopes.ImportResult{
+			Created: decoded.Imported.Created,
+			Updated: decoded.Imported.Updated,
+			Skipped: decoded.Imported.Skipped,
+		}, nil
+	}
+	if decoded.Status != http.StatusBadRequest || len(decoded.Errors) != 1 {
+		return scopes.ImportResult{}, UnexpectedResponseError{Status: decoded.Status, Errors: decoded.Errors}
+	}
+	importErr := scopes.ImportError{Err: UnexpectedResponseError{Status: decoded.Status, Errors: decoded.Errors}}
+	var field string
+	_, err = fmt.Sscanf(decoded.Errors[0].Field, "/%d/%s", &importErr.Line, &field)
+	if err != nil && importErr.Line == 0 {
+		return scopes.ImportResult{}, UnexpectedResponseError{Status: decoded.Status, Errors: decoded.Errors}
+	}
+	importErr.Field = field
+	if decoded.Errors[0].Slug == api.RequestErrConflict {
+		importErr.Err = scopes.ErrScopeAlreadyExists
+	}
+	return scopes.ImportResult{}, importErr
+}
@@ -0,0 +1,203 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"darlinggo.co/pan"
+	uuid "github.com/hashicorp/go-uuid"
+
+	"lockbox.dev/scopes"
+)
+
+// AuditEntry is a representation of the scopes.AuditEntry type that is
+// suitable to be stored in a SQLite database.
+type AuditEntry struct {
+	ID          string     `sql_column:"id"`
+	Action      string     `sql_column:"action"`
+	PrincipalID string     `sql_column:"principal_id"`
+	ScopeIDs    stringList `sql_column:"scope_ids"`
+	CreatedAt   time.Time  `sql_column:"created_at"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (AuditEntry) GetSQLTableName() string {
+	return "scope_audit"
+}
+
+func auditEntryFromSQLite(entry AuditEntry) scopes.AuditEntry {
+	return scopes.AuditEntry{
+		ID:          entry.ID,
+		Action:      entry.Action,
+		PrincipalID: entry.PrincipalID,
+		ScopeIDs:    []string(entry.ScopeIDs),
+		CreatedAt:   entry.CreatedAt,
+	}
+}
+
+func listIDsByExceptionSQL(_ context.Context, kind, principalID string) *pan.Query {
+	var exception Exception
+	q := pan.New("SELECT DISTINCT " + pan.Column(exception, "ScopeID") + " FROM " + pan.Table(exception))
+	q.Where()
+	q.Comparison(exception, "Kind", "=", kind)
+	q.Comparison(exception, "PrincipalID", "=", principalID)
+	q.Flush(" AND ")
+	q.OrderBy(pan.Column(exception, "ScopeID"))
+	return q.Flush(" ")
+}
+
+func deleteExceptionSQL(_ context.Context, kind, principalID string) *pan.Query {
+	var exception Exception
+	q := pan.New("DELETE FROM " + pan.Table(exception))
+	q.Where()
+	q.Comparison(exception, "Kind", "=", kind)
+	q.Comparison(exception, "PrincipalID", "=", principalID)
+	return q.Flush(" AND ")
+}
+
+// listIDsByException returns the IDs of every Scope that lists `principalID`
+// as a `kind` exception, in order.
+func (s *Storer) listIDsByException(ctx context.Context, kind, principalID string) ([]string, error) {
+	query := listIDsByExceptionSQL(ctx, kind, principalID)
+	queryStr, err := query.MySQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, fmt.Errorf("error querying scope IDs: %w", err)
+	}
+	defer closeRows(ctx, rows)
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning scope ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying scope IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// removeException removes `principalID` from the `kind` exceptions of every
+// Scope that lists it, recording an AuditEntry with `action`.
+func (s *Storer) removeException(ctx context.Context, kind, action, principalID string) ([]scopes.Scope, error) {
+	var results []scopes.Scope
+	err := s.withTx(ctx, func(tx *Storer) error {
+		ids, err := tx.listIDsByException(ctx, kind, principalID)
+		if err != nil {
+			return err
+		}
+		previous, err := tx.GetMulti(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		query := deleteExceptionSQL(ctx, kind, principalID)
+		queryStr, err := query.MySQLString()
+		if err != nil {
+			return fmt.Errorf("error generating delete SQL: %w", err)
+		}
+		_, err = tx.conn().ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error deleting %s exceptions: %w", kind, err)
+		}
+
+		results = make([]scopes.Scope, 0, len(ids))
+		for _, id := range ids {
+			updated, ok := previous[id]
+			if !ok {
+				continue
+			}
+			if kind == exceptionKindUser {
+				updated.UserExceptions, _ = scopes.WithoutException(updated.UserExceptions, principalID)
+			} else {
+				updated.ClientExceptions, _ = scopes.WithoutException(updated.ClientExceptions, principalID)
+			}
+			results = append(results, updated)
+		}
+
+		entryID, err := uuid.GenerateUUID()
+		if err != nil {
+			return fmt.Errorf("error generating audit entry ID: %w", err)
+		}
+		entry := AuditEntry{
+			ID:          entryID,
+			Action:      action,
+			PrincipalID: principalID,
+			ScopeIDs:    stringList(ids),
+			CreatedAt:   time.Now(),
+		}
+		query = pan.Insert(entry)
+		queryStr, err = query.MySQLString()
+		if err != nil {
+			return fmt.Errorf("error generating audit SQL: %w", err)
+		}
+		_, err = tx.conn().ExecContext(ctx, queryStr, query.Args()...)
+		if err != nil {
+			return fmt.Errorf("error inserting audit entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RemoveUserException removes `userID` from the UserExceptions of every
+// Scope in the database, recording a single AuditEntry, and returns the
+// Scopes that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveUserException(ctx context.Context, userID string) ([]scopes.Scope, error) {
+	return s.removeException(ctx, exceptionKindUser, scopes.AuditRemoveUserException, userID)
+}
+
+// RemoveClientException removes `clientID` from the ClientExceptions of
+// every Scope in the database, recording a single AuditEntry, and returns the
+// Scopes that were changed, sorted lexicographically by their ID.
+func (s *Storer) RemoveClientException(ctx context.Context, clientID string) ([]scopes.Scope, error) {
+	return s.removeException(ctx, exceptionKindClient, scopes.AuditRemoveClientException, clientID)
+}
+
+func listAuditEntriesSQL(_ context.Context) *pan.Query {
+	var entry AuditEntry
+	q := pan.New("SELECT " + pan.Columns(entry).String() + " FROM " + pan.Table(entry))
+	// rowid increases with every insert, so it breaks ties between entries
+	// created at the same time in the order they were recorded
+	q.OrderBy(pan.Column(entry, "CreatedAt") + ", rowid")
+	return q.Flush(" ")
+}
+
+// ListAuditEntries returns every AuditEntry recorded in the database, oldest
+// first.
+func (s *Storer) ListAuditEntries(ctx context.Context) ([]scopes.AuditEntry, error) {
+	query := listAuditEntriesSQL(ctx)
+	queryStr, err := query.MySQLString()
+	if err != nil {
+		return nil, fmt.Errorf("error generating SQL: %w", err)
+	}
+	rows, err := s.conn().QueryContext(ctx, queryStr, query.Args()...) //nolint:sqlclosecheck // the closeRows helper isn't picked up
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit entries: %w", err)
+	}
+	defer closeRows(ctx, rows)
+	var results []scopes.AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		err = pan.Unmarshal(rows, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling audit entry: %w", err)
+		}
+		results = append(results, auditEntryFromSQLite(entry))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying audit entries: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+
+	"lockbox.dev/scopes/storers/internal/migrations"
+)
+
+// MigrationDirection describes whether migrations should be applied or rolled
+// back.
+type MigrationDirection = migrations.Direction
+
+const (
+	// MigrateUp applies migrations.
+	MigrateUp = migrations.Up
+	// MigrateDown rolls migrations back.
+	MigrateDown = migrations.Down
+)
+
+//go:embed sql/*.sql
+var migrationFiles embed.FS
+
+// migrationSet is the package's migrations, applied with the shared
+// migrations package.
+var migrationSet = migrations.Set{Files: migrationFiles, Dir: "sql", Dialect: "sqlite3"}
+
+// MigrationStatus describes a single migration and whether it has been
+// applied to a database.
+type MigrationStatus = migrations.Status
+
+// Migrate applies all the migrations that haven't been applied to `db` yet if
+// `direction` is MigrateUp, or rolls back all the migrations that have been
+// applied to `db` if `direction` is MigrateDown. It returns the number of
+// migrations applied or rolled back. Each migration runs in its own
+// transaction; `ctx` is only checked before migrations start, as a migration
+// is not safe to interrupt.
+func Migrate(ctx context.Context, db *sql.DB, direction MigrationDirection) (int, error) {
+	return migrationSet.Migrate(ctx, db, direction)
+}
+
+// Migrations returns the status of every migration known to the package,
+// sorted in the order they are applied in, noting which have been applied to
+// `db`.
+func Migrations(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	return migrationSet.Migrations(ctx, db)
+}
+
+// PendingMigrations returns the IDs of the migrations that have not been
+// applied to `db` yet, in the order they will be applied in. A database with
+// no pending migrations is ready to be used by a Storer.
+func PendingMigrations(ctx context.Context, db *sql.DB) ([]string, error) {
+	return migrationSet.Pending(ctx, db)
+}
+
+// CheckHealth returns an error if the database can't be reached or has
+// migrations that haven't been applied yet, in which case the error wraps
+// scopes.ErrPendingMigrations.
+func (s *Storer) CheckHealth(ctx context.Context) error {
+	return migrationSet.CheckHealth(ctx, s.db)
+}
@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// exceptionKindUser is the Kind of Exceptions that are part of a
+	// Scope's UserExceptions.
+	exceptionKindUser = "user"
+	// exceptionKindClient is the Kind of Exceptions that are part of a
+	// Scope's ClientExceptions.
+	exceptionKindClient = "client"
+)
+
+// Scope is a representation of the scopes.Scope type that is suitable to be
+// stored in a SQLite database. A Scope's exceptions are stored separately, as
+// Exceptions.
+type Scope struct {
+	ID           string `sql_column:"id"`
+	UserPolicy   string `sql_column:"user_policy"`
+	ClientPolicy string `sql_column:"client_policy"`
+	IsDefault    bool   `sql_column:"is_default"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Scope) GetSQLTableName() string {
+	return "scopes"
+}
+
+// Exception is a representation of a single entry in a scopes.Scope's
+// UserExceptions or ClientExceptions that is suitable to be stored in a
+// SQLite database. Kind is either "user" or "client", and Position is the
+// entry's 1-based index in the list it belongs to.
+type Exception struct {
+	ScopeID     string `sql_column:"scope_id"`
+	Kind        string `sql_column:"kind"`
+	Position    int64  `sql_column:"position"`
+	PrincipalID string `sql_column:"principal_id"`
+}
+
+// GetSQLTableName returns the name of the SQL table that the data for this
+// type will be stored in.
+func (Exception) GetSQLTableName() string {
+	return "scope_exceptions"
+}
+
+// stringList is a list of strings stored as a JSON array, as SQLite has no
+// array type.
+type stringList []string
+
+// Value encodes the stringList as a JSON array, storing nil lists as empty
+// arrays.
+func (s stringList) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	res, err := json.Marshal([]string(s))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding string list: %w", err)
+	}
+	return string(res), nil
+}
+
+// Scan decodes a JSON array into the stringList, leaving it nil if the array
+// is empty.
+func (s *stringList) Scan(src interface{}) error {
+	var res []string
+	switch val := src.(type) {
+	case string:
+		err := json.Unmarshal([]byte(val), &res)
+		if err != nil {
+			return fmt.Errorf("error decoding string list: %w", err)
+		}
+	case []byte:
+		err := json.Unmarshal(val, &res)
+		if err != nil {
+			return fmt.Errorf("error decoding string list: %w", err)
+		}
+	case nil:
+	default:
+		return fmt.Errorf("can't scan %T into a string list", src) //nolint:goerr113 // not going to be handled, for debug only
+	}
+	if len(res) < 1 {
+		res = nil
+	}
+	*s = res
+	return nil
+}
+
+func fromSQLite(scope Scope, userExceptions, clientExceptions stringList) scopes.Scope {
+	return scopes.Scope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   []string(userExceptions),
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: []string(clientExceptions),
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+func toSQLite(scope scopes.Scope) Scope {
+	return Scope{
+		ID:           scope.ID,
+		UserPolicy:   scope.UserPolicy,
+		ClientPolicy: scope.ClientPolicy,
+		IsDefault:    scope.IsDefault,
+	}
+}
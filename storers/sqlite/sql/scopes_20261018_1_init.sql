@@ -0,0 +1,33 @@
+-- +migrate Up
+CREATE TABLE scopes (
+	id TEXT PRIMARY KEY,
+	user_policy TEXT NOT NULL DEFAULT '',
+	client_policy TEXT NOT NULL DEFAULT '',
+	is_default BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE scope_exceptions (
+	scope_id TEXT NOT NULL REFERENCES scopes (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	kind TEXT NOT NULL CHECK (kind IN ('user', 'client')),
+	position INTEGER NOT NULL,
+	principal_id TEXT NOT NULL,
+	PRIMARY KEY (scope_id, kind, position)
+);
+
+CREATE INDEX scope_exceptions_membership ON scope_exceptions (scope_id, kind, principal_id);
+CREATE INDEX scope_exceptions_lookup ON scope_exceptions (kind, principal_id);
+
+CREATE TABLE scope_audit (
+	id TEXT PRIMARY KEY,
+	action TEXT NOT NULL,
+	principal_id TEXT NOT NULL,
+	scope_ids TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX scope_audit_created_at ON scope_audit (created_at);
+
+-- +migrate Down
+DROP TABLE scope_audit;
+DROP TABLE scope_exceptions;
+DROP TABLE scopes;
@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lockbox.dev/scopes"
+)
+
+func TestLargeExceptionLists(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	factory := NewFactory()
+	defer func() {
+		if err := factory.TeardownStorers(); err != nil {
+			t.Errorf("Error cleaning up databases: %s", err)
+		}
+	}()
+	storer, err := factory.NewStorer(ctx)
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+
+	// more exceptions than SQLite allows bound parameters in one statement
+	exceptions := make([]string, 0, 40000)
+	for i := 0; i < cap(exceptions); i++ {
+		exceptions = append(exceptions, "principal-"+strconv.Itoa(i))
+	}
+	scope := scopes.Scope{
+		ID:               "https://scopes.impractical.co/large",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   exceptions,
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: exceptions,
+	}
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+	_, err = storer.Put(ctx, scope)
+	if err != nil {
+		t.Fatalf("Error putting scope: %s", err)
+	}
+	reversed := make([]string, 0, len(exceptions))
+	for i := len(exceptions) - 1; i >= 0; i-- {
+		reversed = append(reversed, exceptions[i])
+	}
+	updated, err := storer.Update(ctx, scope.ID, scopes.Change{UserExceptions: &reversed})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+	if diff := cmp.Diff(reversed, updated.UserExceptions); diff != "" {
+		t.Errorf("Unexpected diff in user exceptions (-wanted, +got): %s", diff)
+	}
+
+	results, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Error retrieving scope: %s", err)
+	}
+	scope.UserExceptions = reversed
+	if diff := cmp.Diff(map[string]scopes.Scope{scope.ID: scope}, results); diff != "" {
+		t.Errorf("Unexpected diff retrieving scope (-wanted, +got): %s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"lockbox.dev/scopes"
+)
+
+// Factory is a generator of Storers for testing purposes. It knows how to
+// create, track, and clean up SQLite databases that tests can be run against.
+type Factory struct {
+	dir       string
+	databases []*sql.DB
+	lock      sync.Mutex
+}
+
+// NewFactory returns a Factory that is ready to be used. Each test will have
+// its own database, stored in a temporary directory that is removed by
+// TeardownStorers.
+func NewFactory() *Factory {
+	return &Factory{}
+}
+
+// newDatabase creates a new, empty database file with a random name in the
+// Factory's temporary directory, creating the directory if necessary. It
+// keeps track of the connections it opens so they can be closed later.
+func (f *Factory) newDatabase(_ context.Context) (*sql.DB, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.dir == "" {
+		dir, err := ioutil.TempDir("", "scopes_test_")
+		if err != nil {
+			log.Printf("Error creating temporary directory: %+v\n", err)
+			return nil, err
+		}
+		f.dir = dir
+	}
+
+	suffix, err := uuid.GenerateRandomBytes(6) //nolint:gomnd // not magic, just arbitrary
+	if err != nil {
+		log.Printf("Error generating database suffix: %+v\n", err)
+		return nil, err
+	}
+	path := filepath.Join(f.dir, "scopes_test_"+hex.EncodeToString(suffix)+".db")
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		log.Printf("Error opening database %s: %+v\n", path, err)
+		return nil, err
+	}
+	f.databases = append(f.databases, conn)
+
+	return conn, nil
+}
+
+// NewStorer creates a new test database using newDatabase, runs migrations
+// against it, and returns a Storer backed by it.
+func (f *Factory) NewStorer(ctx context.Context) (scopes.Storer, error) { //nolint:ireturn // the interface we're filling wants an interface returned
+	conn, err := f.newDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = Migrate(ctx, conn, MigrateUp)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStorer(ctx, conn), nil
+}
+
+// TeardownStorers closes all the tracked databases created by NewStorer and
+// removes the directory they were stored in.
+func (f *Factory) TeardownStorers() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, conn := range f.databases {
+		if err := conn.Close(); err != nil {
+			return err
+		}
+	}
+	f.databases = nil
+	if f.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(f.dir)
+	if err != nil {
+		return err
+	}
+	f.dir = ""
+	return nil
+}
@@ -0,0 +1,163 @@
+package storertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+// skipUnlessBatches skips the test if `storer` can't apply batches.
+func skipUnlessBatches(t *testing.T, storer scopes.Storer) {
+	t.Helper()
+
+	if _, ok := storer.(scopes.Batcher); ok {
+		return
+	}
+	if _, ok := storer.(scopes.Transactor); ok {
+		return
+	}
+	t.Skipf("%T implements neither scopes.Batcher nor scopes.Transactor", storer)
+}
+
+func testApplyBatch(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	skipUnlessBatches(t, storer)
+
+	existing := scopes.Scope{
+		ID:           "https://scopes.impractical.co/batch/existing",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	err := storer.Create(ctx, existing)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	first := scopes.Scope{
+		ID:             "https://scopes.impractical.co/batch/first",
+		UserPolicy:     scopes.PolicyDefaultDeny,
+		UserExceptions: []string{uuidOrFail(t)},
+		ClientPolicy:   scopes.PolicyAllowAll,
+		IsDefault:      true,
+	}
+	second := scopes.Scope{
+		ID:           "https://scopes.impractical.co/batch/second",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	allow := scopes.PolicyAllowAll
+	change := scopes.Change{ClientPolicy: &allow}
+	ops := []scopes.Operation{
+		{Type: scopes.OperationCreate, Scope: first},
+		{Type: scopes.OperationCreate, Scope: second},
+		// operations see the results of the ones before them
+		{Type: scopes.OperationUpdate, ID: second.ID, Change: scopes.Change{ClientPolicy: &allow}},
+		{Type: scopes.OperationUpdate, ID: existing.ID, Change: change, Expected: &existing},
+		{Type: scopes.OperationDelete, ID: first.ID, Expected: &first},
+	}
+	results, err := scopes.ApplyBatch(ctx, storer, ops)
+	if err != nil {
+		t.Fatalf("Unexpected error applying batch: %s", err.Error())
+	}
+	expected := []scopes.Scope{first, second, second, scopes.Apply(change, existing), first}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff in batch results (-wanted, +got): %s", diff)
+	}
+
+	res, err := storer.GetMulti(ctx, []string{first.ID, second.ID, existing.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{
+		second.ID:   second,
+		existing.ID: scopes.Apply(change, existing),
+	}, res, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff after batch (-wanted, +got): %s", diff)
+	}
+}
+
+func testApplyBatchRollsBack(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	skipUnlessBatches(t, storer)
+
+	existing := scopes.Scope{
+		ID:           "https://scopes.impractical.co/batch/existing",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	err := storer.Create(ctx, existing)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	created := scopes.Scope{
+		ID:           "https://scopes.impractical.co/batch/created",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	allow := scopes.PolicyAllowAll
+	stale := existing
+	stale.ClientPolicy = scopes.PolicyAllowAll
+
+	batches := map[string]struct {
+		ops   []scopes.Operation
+		index int
+		err   error
+	}{
+		"missing": {
+			ops: []scopes.Operation{
+				{Type: scopes.OperationCreate, Scope: created},
+				{Type: scopes.OperationUpdate, ID: existing.ID, Change: scopes.Change{UserPolicy: &allow}},
+				{Type: scopes.OperationDelete, ID: "https://scopes.impractical.co/batch/404"},
+			},
+			index: 2,
+			err:   scopes.ErrScopeNotFound,
+		},
+		"duplicate": {
+			ops: []scopes.Operation{
+				{Type: scopes.OperationCreate, Scope: created},
+				{Type: scopes.OperationDelete, ID: existing.ID},
+				{Type: scopes.OperationCreate, Scope: created},
+			},
+			index: 2,
+			err:   scopes.ErrScopeAlreadyExists,
+		},
+		"changed": {
+			ops: []scopes.Operation{
+				{Type: scopes.OperationCreate, Scope: created},
+				{Type: scopes.OperationUpdate, ID: existing.ID, Change: scopes.Change{UserPolicy: &allow}, Expected: &stale},
+			},
+			index: 1,
+			err:   scopes.ErrScopeChanged,
+		},
+		"changed-delete": {
+			ops: []scopes.Operation{
+				{Type: scopes.OperationCreate, Scope: created},
+				{Type: scopes.OperationDelete, ID: existing.ID, Expected: &stale},
+			},
+			index: 1,
+			err:   scopes.ErrScopeChanged,
+		},
+	}
+	for name, batch := range batches {
+		_, err = scopes.ApplyBatch(ctx, storer, batch.ops)
+		var batchErr scopes.BatchError
+		if !errors.As(err, &batchErr) {
+			t.Fatalf("%s: expected BatchError, got %v", name, err)
+		}
+		if batchErr.Index != batch.index || !errors.Is(err, batch.err) {
+			t.Errorf("%s: expected operation %d to fail with %v, got %v", name, batch.index, batch.err, err)
+		}
+
+		// none of the operations should have taken effect
+		res, err := storer.GetMulti(ctx, []string{existing.ID, created.ID})
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+		}
+		if diff := cmp.Diff(map[string]scopes.Scope{existing.ID: existing}, res, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: unexpected diff after failed batch (-wanted, +got): %s", name, diff)
+		}
+	}
+}
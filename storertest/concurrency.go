@@ -0,0 +1,223 @@
+package storertest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+const concurrency = 8
+
+// runConcurrently calls `fn` from `concurrency` goroutines at once, passing
+// each its position, and waits for them all to return.
+func runConcurrently(fn func(pos int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for pos := 0; pos < concurrency; pos++ {
+		wg.Add(1)
+		go func(pos int) {
+			defer wg.Done()
+			<-start
+			fn(pos)
+		}(pos)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func testConcurrentCreateSameID(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// exactly one of many racing creates for the same ID should win, and
+	// the rest should be told it already exists
+	policies := []string{scopes.PolicyDefaultAllow, scopes.PolicyDefaultDeny, scopes.PolicyAllowAll, scopes.PolicyDenyAll}
+	candidates := make([]scopes.Scope, concurrency)
+	errs := make([]error, concurrency)
+	for pos := range candidates {
+		candidates[pos] = scopes.Scope{
+			ID:             "https://scopes.impractical.co/race",
+			UserPolicy:     policies[pos%len(policies)],
+			UserExceptions: []string{"user-" + strconv.Itoa(pos)},
+			ClientPolicy:   policies[(pos+1)%len(policies)],
+		}
+	}
+	runConcurrently(func(pos int) {
+		errs[pos] = storer.Create(ctx, candidates[pos])
+	})
+
+	winner := -1
+	for pos, err := range errs {
+		switch {
+		case err == nil && winner < 0:
+			winner = pos
+		case err == nil:
+			t.Errorf("Expected only one create to succeed, but %d and %d both did", winner, pos)
+		case !errors.Is(err, scopes.ErrScopeAlreadyExists):
+			t.Errorf("Expected ErrScopeAlreadyExists from create %d, got %s", pos, err.Error())
+		}
+	}
+	if winner < 0 {
+		t.Fatal("Expected one create to succeed, none did")
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{candidates[winner].ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(candidates[winner], resps[candidates[winner].ID], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Expected the winning create to be stored (-wanted, +got): %s", diff)
+	}
+}
+
+func testConcurrentCreates(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	expected := make([]scopes.Scope, concurrency)
+	errs := make([]error, concurrency)
+	for pos := range expected {
+		expected[pos] = scopes.Scope{
+			ID:               "https://scopes.impractical.co/concurrent/" + strconv.Itoa(pos),
+			UserPolicy:       scopes.PolicyDefaultDeny,
+			UserExceptions:   []string{"shared-user"},
+			ClientPolicy:     scopes.PolicyDefaultAllow,
+			ClientExceptions: []string{"client-" + strconv.Itoa(pos)},
+			IsDefault:        pos%2 == 0,
+		}
+	}
+	runConcurrently(func(pos int) {
+		errs[pos] = storer.Create(ctx, expected[pos])
+	})
+	for pos, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope %d: %s", pos, err.Error())
+		}
+	}
+
+	listed, err := storer.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(expected, listed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff listing scopes (-wanted, +got): %s", diff)
+	}
+	byUser, err := storer.ListByUserException(ctx, "shared-user")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by user exception: %s", err.Error())
+	}
+	if diff := cmp.Diff(expected, byUser, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff listing scopes by user exception (-wanted, +got): %s", diff)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// racing updates to different fields of the same scope shouldn't
+	// clobber each other
+	scope := scopes.Scope{
+		ID:               "https://scopes.impractical.co/contended",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"user-1"},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{"client-1"},
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	userPolicy, clientPolicy, isDefault := scopes.PolicyAllowAll, scopes.PolicyDenyAll, true
+	userExceptions, clientExceptions := []string{"user-2", "user-3"}, []string{"client-2"}
+	changes := []scopes.Change{
+		{UserPolicy: &userPolicy},
+		{UserExceptions: &userExceptions},
+		{ClientPolicy: &clientPolicy},
+		{ClientExceptions: &clientExceptions},
+		{IsDefault: &isDefault},
+	}
+	errs := make([]error, concurrency)
+	runConcurrently(func(pos int) {
+		_, errs[pos] = storer.Update(ctx, scope.ID, changes[pos%len(changes)])
+	})
+	for pos, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error from update %d: %s", pos, err.Error())
+		}
+	}
+
+	expected := scope
+	for _, change := range changes {
+		expected = scopes.Apply(change, expected)
+	}
+	resps, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(expected, resps[scope.ID], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Expected every update to be applied (-wanted, +got): %s", diff)
+	}
+}
+
+func testConcurrentRemoveException(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// removing an exception while other removals and updates are happening
+	// shouldn't lose any of them
+	var ids []string
+	for pos := 0; pos < concurrency; pos++ {
+		scope := scopes.Scope{
+			ID:             "https://scopes.impractical.co/removal/" + strconv.Itoa(pos),
+			UserPolicy:     scopes.PolicyDefaultDeny,
+			UserExceptions: []string{"removed-user", "kept-user"},
+			ClientPolicy:   scopes.PolicyDefaultDeny,
+		}
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err.Error())
+		}
+		ids = append(ids, scope.ID)
+	}
+
+	clientPolicy := scopes.PolicyAllowAll
+	errs := make([]error, concurrency)
+	removed := make([][]scopes.Scope, concurrency)
+	runConcurrently(func(pos int) {
+		if pos%2 == 0 {
+			removed[pos], errs[pos] = storer.RemoveUserException(ctx, "removed-user")
+			return
+		}
+		_, errs[pos] = storer.Update(ctx, ids[pos], scopes.Change{ClientPolicy: &clientPolicy})
+	})
+	for pos, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error from operation %d: %s", pos, err.Error())
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, changed := range removed {
+		for _, scope := range changed {
+			seen[scope.ID] = true
+		}
+	}
+	resps, err := storer.GetMulti(ctx, ids)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	for pos, id := range ids {
+		if !seen[id] {
+			t.Errorf("Expected %s to be reported as changed", id)
+		}
+		expected := scopes.Scope{
+			ID:             id,
+			UserPolicy:     scopes.PolicyDefaultDeny,
+			UserExceptions: []string{"kept-user"},
+			ClientPolicy:   scopes.PolicyDefaultDeny,
+		}
+		if pos%2 != 0 {
+			expected.ClientPolicy = clientPolicy
+		}
+		if diff := cmp.Diff(expected, resps[id], cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Unexpected diff for %s (-wanted, +got): %s", id, diff)
+		}
+	}
+}
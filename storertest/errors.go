@@ -0,0 +1,300 @@
+package storertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+func testGetMultiNoIDs(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// asking for nothing isn't an error, it just returns nothing
+	err := storer.Create(ctx, scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	for _, ids := range [][]string{nil, {}} {
+		res, err := storer.GetMulti(ctx, ids)
+		if err != nil {
+			t.Fatalf("Unexpected error retrieving %#v: %s", ids, err.Error())
+		}
+		if res == nil {
+			t.Errorf("Expected an empty map retrieving %#v, got nil", ids)
+		}
+		if len(res) != 0 {
+			t.Errorf("Expected no results retrieving %#v, got %+v", ids, res)
+		}
+	}
+}
+
+func testGetMultiSomeMissing(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:             "https://scopes.impractical.co/found",
+		UserPolicy:     scopes.PolicyDefaultDeny,
+		UserExceptions: []string{uuidOrFail(t)},
+		ClientPolicy:   scopes.PolicyAllowAll,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	// missing scopes are left out, not errors, and asking for the same
+	// scope twice only returns it once
+	res, err := storer.GetMulti(ctx, []string{
+		"https://scopes.impractical.co/missing",
+		scope.ID,
+		"https://scopes.impractical.co/also-missing",
+		scope.ID,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{scope.ID: scope}, res, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff retrieving scopes (-wanted, +got): %s", diff)
+	}
+}
+
+func testCreateAfterDelete(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// deleting a scope frees up its ID, and nothing about the deleted
+	// scope should linger
+	original := scopes.Scope{
+		ID:               "https://scopes.impractical.co/recreated",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"old-user"},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{"old-client"},
+		IsDefault:        true,
+	}
+	err := storer.Create(ctx, original)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	_, err = storer.Delete(ctx, original.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err.Error())
+	}
+
+	recreated := scopes.Scope{
+		ID:               original.ID,
+		UserPolicy:       scopes.PolicyDefaultAllow,
+		UserExceptions:   []string{"new-user"},
+		ClientPolicy:     scopes.PolicyDefaultAllow,
+		ClientExceptions: []string{"new-client"},
+	}
+	err = storer.Create(ctx, recreated)
+	if err != nil {
+		t.Fatalf("Unexpected error recreating scope: %s", err.Error())
+	}
+
+	res, err := storer.GetMulti(ctx, []string{original.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(recreated, res[original.ID], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff retrieving recreated scope (-wanted, +got): %s", diff)
+	}
+	byUser, err := storer.ListByUserException(ctx, "old-user")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by user exception: %s", err.Error())
+	}
+	if len(byUser) != 0 {
+		t.Errorf("Expected no scopes to list the deleted scope's user exception, got %+v", byUser)
+	}
+	byClient, err := storer.ListByClientException(ctx, "old-client")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by client exception: %s", err.Error())
+	}
+	if len(byClient) != 0 {
+		t.Errorf("Expected no scopes to list the deleted scope's client exception, got %+v", byClient)
+	}
+	defaults, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing default scopes: %s", err.Error())
+	}
+	if len(defaults) != 0 {
+		t.Errorf("Expected no default scopes, got %+v", defaults)
+	}
+}
+
+func testDeleteTwice(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:           "https://scopes.impractical.co/deleted",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	_, err = storer.Delete(ctx, scope.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err.Error())
+	}
+
+	// once a scope is deleted, it can't be deleted again
+	_, err = storer.Delete(ctx, scope.ID)
+	if !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Fatalf("Expected ErrScopeNotFound deleting scope again, got %v", err)
+	}
+	// or updated
+	deny := scopes.PolicyDenyAll
+	_, err = storer.Update(ctx, scope.ID, scopes.Change{UserPolicy: &deny})
+	if !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Fatalf("Expected ErrScopeNotFound updating deleted scope, got %v", err)
+	}
+	// and trying to update it shouldn't bring it back
+	res, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if len(res) != 0 {
+		t.Errorf("Expected deleted scope to stay deleted, got %+v", res)
+	}
+}
+
+func testListPastEnd(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	// listing an empty Storer isn't an error
+	listed, err := storer.List(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error listing empty storer: %s", err.Error())
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected no scopes listing empty storer, got %+v", listed)
+	}
+
+	err = storer.Create(ctx, scopes.Scope{
+		ID:           "https://scopes.impractical.co/only",
+		UserPolicy:   scopes.PolicyAllowAll,
+		ClientPolicy: scopes.PolicyAllowAll,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	// and neither is asking for the page after the last one
+	for _, after := range []string{"https://scopes.impractical.co/only", "https://scopes.impractical.co/zzz"} {
+		listed, err = storer.List(ctx, after, 10)
+		if err != nil {
+			t.Fatalf("Unexpected error listing after %q: %s", after, err.Error())
+		}
+		if len(listed) != 0 {
+			t.Errorf("Expected no scopes listing after %q, got %+v", after, listed)
+		}
+	}
+}
+
+func testUnknownException(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	err := storer.Create(ctx, scopes.Scope{
+		ID:               "https://scopes.impractical.co/test",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"known-user"},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{"known-client"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	// principals nothing lists as an exception aren't errors, they just
+	// don't match or change anything
+	listers := map[string]func() ([]scopes.Scope, error){
+		"ListByUserException": func() ([]scopes.Scope, error) {
+			return storer.ListByUserException(ctx, "unknown")
+		},
+		"ListByClientException": func() ([]scopes.Scope, error) {
+			return storer.ListByClientException(ctx, "unknown")
+		},
+		"RemoveUserException": func() ([]scopes.Scope, error) {
+			return storer.RemoveUserException(ctx, "unknown")
+		},
+		"RemoveClientException": func() ([]scopes.Scope, error) {
+			return storer.RemoveClientException(ctx, "unknown")
+		},
+	}
+	for name, lister := range listers {
+		listed, err := lister()
+		if err != nil {
+			t.Fatalf("Unexpected error from %s: %s", name, err.Error())
+		}
+		if len(listed) != 0 {
+			t.Errorf("Expected no scopes from %s, got %+v", name, listed)
+		}
+	}
+}
+
+func testEmptyIDs(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	err := storer.Create(ctx, scopes.Scope{
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	})
+	if !errors.Is(err, scopes.ErrEmptyID) {
+		t.Errorf("Expected %v creating a scope with no ID, got %v", scopes.ErrEmptyID, err)
+	}
+	_, err = storer.Put(ctx, scopes.Scope{
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	})
+	if !errors.Is(err, scopes.ErrEmptyID) {
+		t.Errorf("Expected %v putting a scope with no ID, got %v", scopes.ErrEmptyID, err)
+	}
+
+	emptyUser := scopes.Scope{
+		ID:             "https://scopes.impractical.co/empty-user",
+		UserPolicy:     scopes.PolicyDefaultDeny,
+		UserExceptions: []string{"user", ""},
+		ClientPolicy:   scopes.PolicyDefaultDeny,
+	}
+	emptyClient := scopes.Scope{
+		ID:               "https://scopes.impractical.co/empty-client",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{""},
+	}
+	for _, scope := range []scopes.Scope{emptyUser, emptyClient} {
+		err = storer.Create(ctx, scope)
+		if !errors.Is(err, scopes.ErrEmptyPrincipalID) {
+			t.Errorf("Expected %v creating %q, got %v", scopes.ErrEmptyPrincipalID, scope.ID, err)
+		}
+		_, err = storer.Put(ctx, scope)
+		if !errors.Is(err, scopes.ErrEmptyPrincipalID) {
+			t.Errorf("Expected %v putting %q, got %v", scopes.ErrEmptyPrincipalID, scope.ID, err)
+		}
+	}
+
+	// none of the rejected writes should have stored anything
+	listed, err := storer.List(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected no scopes to be stored, got %+v", listed)
+	}
+
+	err = storer.Create(ctx, scopes.Scope{
+		ID:           "https://scopes.impractical.co/test",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	changes := []scopes.Change{
+		{UserExceptions: &[]string{""}},
+		{ClientExceptions: &[]string{"client", ""}},
+	}
+	for _, change := range changes {
+		_, err = storer.Update(ctx, "https://scopes.impractical.co/test", change)
+		if !errors.Is(err, scopes.ErrEmptyPrincipalID) {
+			t.Errorf("Expected %v updating with %+v, got %v", scopes.ErrEmptyPrincipalID, change, err)
+		}
+	}
+}
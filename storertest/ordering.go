@@ -0,0 +1,138 @@
+package storertest
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+// orderedScopes creates Scopes whose IDs are out of order when created and
+// share prefixes, so Storers relying on insertion order or comparing IDs by
+// length get caught out. The IDs mix cases, punctuation, and non-ASCII
+// characters, which the collations databases commonly use sort differently
+// from a byte-wise comparison, so Storers following those collations get
+// caught out, too. The created Scopes are returned sorted by ID, byte by
+// byte.
+func orderedScopes(t *testing.T, storer scopes.Storer, ctx context.Context) []scopes.Scope {
+	t.Helper()
+
+	var expected []scopes.Scope
+	for pos, suffix := range []string{
+		"b", "c10", "admin.write", "a", "Admin", "ab", "é", "c2",
+		"admin-read", "aa", "Z", "c1", "admin_list", "b0", "admins", "Ωmega",
+	} {
+		scope := scopes.Scope{
+			ID:               "https://scopes.impractical.co/order/" + suffix,
+			UserPolicy:       scopes.PolicyDefaultDeny,
+			UserExceptions:   []string{"ordered-user"},
+			ClientPolicy:     scopes.PolicyDefaultDeny,
+			ClientExceptions: []string{"ordered-client"},
+			IsDefault:        pos%2 == 0,
+		}
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+		}
+		expected = append(expected, scope)
+	}
+	// Go compares strings byte by byte
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].ID < expected[j].ID
+	})
+	return expected
+}
+
+func testListOrdering(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	expected := orderedScopes(t, storer, ctx)
+	var defaults []scopes.Scope
+	for _, scope := range expected {
+		if scope.IsDefault {
+			defaults = append(defaults, scope)
+		}
+	}
+
+	listers := map[string]func() ([]scopes.Scope, error){
+		"List": func() ([]scopes.Scope, error) {
+			return storer.List(ctx, "", 0)
+		},
+		"ListByUserException": func() ([]scopes.Scope, error) {
+			return storer.ListByUserException(ctx, "ordered-user")
+		},
+		"ListByClientException": func() ([]scopes.Scope, error) {
+			return storer.ListByClientException(ctx, "ordered-client")
+		},
+	}
+	for name, lister := range listers {
+		listed, err := lister()
+		if err != nil {
+			t.Fatalf("Unexpected error from %s: %s", name, err.Error())
+		}
+		if diff := cmp.Diff(expected, listed, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Unexpected order from %s (-wanted, +got): %s", name, diff)
+		}
+	}
+
+	listed, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error from ListDefault: %s", err.Error())
+	}
+	if diff := cmp.Diff(defaults, listed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected order from ListDefault (-wanted, +got): %s", diff)
+	}
+
+	// the scopes changed by removing an exception are sorted, too
+	changed, err := storer.RemoveClientException(ctx, "ordered-client")
+	if err != nil {
+		t.Fatalf("Unexpected error removing client exception: %s", err.Error())
+	}
+	var changedIDs []string
+	for _, scope := range changed {
+		changedIDs = append(changedIDs, scope.ID)
+	}
+	var expectedIDs []string
+	for _, scope := range expected {
+		expectedIDs = append(expectedIDs, scope.ID)
+	}
+	if diff := cmp.Diff(expectedIDs, changedIDs); diff != "" {
+		t.Errorf("Unexpected order from RemoveClientException (-wanted, +got): %s", diff)
+	}
+}
+
+func testPaginationOrdering(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	expected := orderedScopes(t, storer, ctx)
+
+	// walking the pages should visit every scope once, in order
+	var listed []scopes.Scope
+	var after string
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatalf("Too many pages, listed %+v", listed)
+		}
+		page, err := storer.List(ctx, after, 2)
+		if err != nil {
+			t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+		}
+		if len(page) == 0 {
+			break
+		}
+		listed = append(listed, page...)
+		after = page[len(page)-1].ID
+	}
+	if diff := cmp.Diff(expected, listed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff paginating scopes (-wanted, +got): %s", diff)
+	}
+
+	// `after` doesn't need to be the ID of a scope that exists
+	page, err := storer.List(ctx, "https://scopes.impractical.co/order/a0", 3)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(expected[3:6], page, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff listing after a missing ID (-wanted, +got): %s", diff)
+	}
+}
@@ -0,0 +1,1166 @@
+package storertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+func testCreateAndGetScope(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:         "https://scopes.impractical.co/test",
+		UserPolicy: "DEFAULT_ALLOW",
+		UserExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		ClientPolicy: "DEFAULT_ALLOW",
+		ClientExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		IsDefault: true,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	resp, ok := resps[scope.ID]
+	if !ok {
+		t.Fatalf("Scope not found.")
+	}
+	if diff := cmp.Diff(scope, resp); diff != "" {
+		t.Errorf("Retrieved scope doesn't match expectation:\n%s", diff)
+	}
+}
+
+func testGetNonexistentScope(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	res, err := storer.GetMulti(ctx, []string{"nope"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(res) != 0 {
+		t.Fatalf("Expected 0 results, got %+v", res)
+	}
+}
+
+func testCreateDuplicateID(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:         "https://scopes.impractical.co/test",
+		UserPolicy: "DEFAULT_ALLOW",
+		UserExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		ClientPolicy: "DEFAULT_ALLOW",
+		ClientExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		IsDefault: true,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	scope2 := scopes.Scope{
+		ID:         "https://scopes.impractical.co/test",
+		UserPolicy: "DEFAULT_DENY",
+		UserExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		ClientPolicy: "DEFAULT_DENY",
+		ClientExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		IsDefault: false,
+	}
+
+	err = storer.Create(ctx, scope2)
+	if !errors.Is(err, scopes.ErrScopeAlreadyExists) {
+		t.Fatalf("Expected ErrScopeAlreadyExists, got %s", err.Error())
+	}
+
+	// we shouldn't have changed anything about what was stored
+	resps, err := storer.GetMulti(ctx, []string{scope.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	resp, ok := resps[scope.ID]
+	if !ok {
+		t.Fatalf("Scope not found.")
+	}
+	if diff := cmp.Diff(scope, resp); diff != "" {
+		t.Errorf("Retrieved scope doesn't match expectation:\n%s", diff)
+	}
+}
+
+func testCreateMultipleScopes(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:         "https://scopes.impractical.co/test",
+		UserPolicy: "DEFAULT_DENY",
+		UserExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		ClientPolicy: "DEFAULT_DENY",
+		ClientExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		IsDefault: false,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	scope2 := scope
+	scope2.ID = "https://scopes.impractical.co/test2"
+	err = storer.Create(ctx, scope2)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{scope.ID, scope2.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	resp1, ok := resps[scope.ID]
+	if !ok {
+		t.Fatalf("Scope %q not found.", scope.ID)
+	}
+	resp2, ok := resps[scope2.ID]
+	if !ok {
+		t.Fatalf("Scope %q not found.", scope2.ID)
+	}
+	if diff := cmp.Diff(scope, resp1); diff != "" {
+		t.Errorf("Retrieved scope %q doesn't match expectation:\n%s", scope.ID, diff)
+	}
+	if diff := cmp.Diff(scope2, resp2); diff != "" {
+		t.Errorf("Retrieved scope %q doesn't match expectation:\n%s", scope2.ID, diff)
+	}
+}
+
+func testListDefault(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	testScopes := []scopes.Scope{
+		{
+			ID:         "https://scopes.impractical.co/test",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test2",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test3",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test4",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+	}
+	for _, scope := range testScopes {
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+		}
+	}
+
+	results, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+	}
+
+	expectations := []scopes.Scope{testScopes[1], testScopes[3]}
+	scopes.ByID(expectations)
+
+	if diff := cmp.Diff(expectations, results); diff != "" {
+		t.Errorf("Unexpected results for listing scopes:\n%s", diff)
+	}
+}
+
+func testUpdateOneOfMany(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	throwaways := []scopes.Scope{
+		{
+			ID:         "https://scopes.impractical.co/test",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test2",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test3",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test4",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+	}
+	var ids []string
+	for _, scope := range throwaways {
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+		}
+		ids = append(ids, scope.ID)
+	}
+
+	for variation := 1; variation < changeVariations; variation++ {
+		variation := variation
+		t.Run(fmt.Sprintf("variation=%d", variation), func(t *testing.T) {
+			t.Parallel()
+
+			scope := scopes.Scope{
+				ID:         "https://scopes.impractical.co/updated/" + strconv.Itoa(variation),
+				UserPolicy: "DEFAULT_DENY",
+				UserExceptions: []string{
+					uuidOrFail(t),
+					uuidOrFail(t),
+					uuidOrFail(t),
+				},
+				ClientPolicy: "DEFAULT_DENY",
+				ClientExceptions: []string{
+					uuidOrFail(t),
+					uuidOrFail(t),
+				},
+				IsDefault: true,
+			}
+			err := storer.Create(ctx, scope)
+			if err != nil {
+				t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+			}
+			scopeIDs := append([]string{}, ids...)
+			scopeIDs = append(scopeIDs, scope.ID)
+
+			var change scopes.Change
+			if variation&changeUserPolicy != 0 {
+				userPolicy := scopes.PolicyAllowAll
+				change.UserPolicy = &userPolicy
+			}
+			if variation&changeUserExceptions != 0 {
+				userExceptions := []string{"user1", "user2"}
+				change.UserExceptions = &userExceptions
+			}
+			if variation&changeClientPolicy != 0 {
+				clientPolicy := scopes.PolicyDenyAll
+				change.ClientPolicy = &clientPolicy
+			}
+			if variation&changeClientExceptions != 0 {
+				clientExceptions := []string{"client1", "client2", "client3"}
+				change.ClientExceptions = &clientExceptions
+			}
+			if variation&changeIsDefault != 0 {
+				isDefault := false
+				change.IsDefault = &isDefault
+			}
+			expectation := scopes.Apply(change, scope)
+
+			updated, err := storer.Update(ctx, scope.ID, change)
+			if err != nil {
+				t.Fatalf("Unexpected error updating scope: %s", err.Error())
+			}
+			if diff := cmp.Diff(expectation, updated); diff != "" {
+				t.Errorf("Unexpected scope returned from update:\n%s", diff)
+			}
+
+			res, err := storer.GetMulti(ctx, scopeIDs)
+			if err != nil {
+				t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+			}
+			result, ok := res[expectation.ID]
+			if !ok {
+				t.Fatalf("Expected scope %q to be in Storer, wasn't", expectation.ID)
+			}
+
+			if diff := cmp.Diff(expectation, result); diff != "" {
+				t.Errorf("Unexpected result for updated scope:\n%s", diff)
+			}
+
+			for _, expected := range throwaways {
+				result, ok := res[expected.ID]
+				if !ok {
+					t.Fatalf("Expected throwaway scope %q to be in Storer, wasn't", expected.ID)
+				}
+				if diff := cmp.Diff(expected, result); diff != "" {
+					t.Errorf("Unexpected result for throwaway scope %q\n%s", expected.ID, diff)
+				}
+			}
+		})
+	}
+}
+
+func testUpdateNonExistent(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	deny := scopes.PolicyDefaultDeny
+	change := scopes.Change{
+		UserPolicy: &deny,
+	}
+	_, err := storer.Update(ctx, "https://scopes.impractical.co/test", change)
+	if !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Fatalf("Expected ErrScopeNotFound, got %v", err)
+	}
+}
+
+func testUpdateNoChange(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	scope := scopes.Scope{
+		ID:             "https://scopes.impractical.co/test",
+		UserPolicy:     scopes.PolicyDefaultDeny,
+		UserExceptions: []string{uuidOrFail(t)},
+		ClientPolicy:   scopes.PolicyAllowAll,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	// updating a scope with an empty change should not error, and should
+	// return the scope as it is
+	var change scopes.Change
+	updated, err := storer.Update(ctx, scope.ID, change)
+	if err != nil {
+		t.Fatalf("Unexpected error updating scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(scope, updated, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected scope returned from update (-wanted, +got): %s", diff)
+	}
+
+	// but it should still tell us if the scope doesn't exist
+	_, err = storer.Update(ctx, "https://scopes.impractical.co/404", change)
+	if !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Fatalf("Expected ErrScopeNotFound, got %v", err)
+	}
+}
+
+func testDeleteOneOfMany(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	throwaways := []scopes.Scope{
+		{
+			ID:         "https://scopes.impractical.co/test",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test2",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test3",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: false,
+		},
+		{
+			ID:         "https://scopes.impractical.co/test4",
+			UserPolicy: "DEFAULT_DENY",
+			UserExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			ClientPolicy: "DEFAULT_DENY",
+			ClientExceptions: []string{
+				uuidOrFail(t),
+				uuidOrFail(t),
+			},
+			IsDefault: true,
+		},
+	}
+	var ids []string
+	for _, scope := range throwaways {
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+		}
+		ids = append(ids, scope.ID)
+	}
+	scope := scopes.Scope{
+		ID:         "https://scopes.impractical.co/delete-me",
+		UserPolicy: "DEFAULT_DENY",
+		UserExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		ClientPolicy: "DEFAULT_DENY",
+		ClientExceptions: []string{
+			uuidOrFail(t),
+			uuidOrFail(t),
+		},
+		IsDefault: true,
+	}
+	err := storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope %q: %s", scope.ID, err.Error())
+	}
+	ids = append(ids, scope.ID)
+
+	deleted, err := storer.Delete(ctx, scope.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(scope, deleted); diff != "" {
+		t.Errorf("Unexpected scope returned from delete:\n%s", diff)
+	}
+
+	res, err := storer.GetMulti(ctx, ids)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if _, ok := res[scope.ID]; ok {
+		t.Errorf("Expected scope %q to not be in results, but was", scope.ID)
+	}
+
+	for _, expected := range throwaways {
+		result, ok := res[expected.ID]
+		if !ok {
+			t.Fatalf("Expected throwaway scope %q to be in results, wasn't", expected.ID)
+		}
+		if diff := cmp.Diff(expected, result); diff != "" {
+			t.Errorf("Unexpected result for throwaway scope %q\n%s", expected.ID, diff)
+		}
+	}
+}
+
+func testDeleteNonExistent(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	_, err := storer.Delete(ctx, "https://scopes.impractical.co/404")
+	if !errors.Is(err, scopes.ErrScopeNotFound) {
+		t.Fatalf("Expected ErrScopeNotFound, got %v", err)
+	}
+}
+
+func testPutCreatesAndReplaces(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	original := scopes.Scope{
+		ID:               "https://scopes.impractical.co/put",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{"old-user", uuidOrFail(t)},
+		ClientPolicy:     scopes.PolicyDefaultAllow,
+		ClientExceptions: []string{"old-client"},
+		IsDefault:        true,
+	}
+	created, err := storer.Put(ctx, original)
+	if err != nil {
+		t.Fatalf("Unexpected error putting scope: %s", err.Error())
+	}
+	if !created {
+		t.Error("Expected putting a new scope to create it")
+	}
+	res, err := storer.GetMulti(ctx, []string{original.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(original, res[original.ID], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff retrieving created scope (-wanted, +got): %s", diff)
+	}
+
+	// putting it again replaces everything about it, including the
+	// exceptions
+	replacement := scopes.Scope{
+		ID:             original.ID,
+		UserPolicy:     scopes.PolicyAllowAll,
+		UserExceptions: []string{"new-user"},
+		ClientPolicy:   scopes.PolicyDenyAll,
+	}
+	created, err = storer.Put(ctx, replacement)
+	if err != nil {
+		t.Fatalf("Unexpected error putting scope again: %s", err.Error())
+	}
+	if created {
+		t.Error("Expected putting an existing scope to replace it, not create it")
+	}
+	res, err = storer.GetMulti(ctx, []string{original.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scope: %s", err.Error())
+	}
+	if diff := cmp.Diff(replacement, res[original.ID], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff retrieving replaced scope (-wanted, +got): %s", diff)
+	}
+	byUser, err := storer.ListByUserException(ctx, "old-user")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by user exception: %s", err.Error())
+	}
+	if len(byUser) != 0 {
+		t.Errorf("Expected replaced exceptions to be gone, got %+v", byUser)
+	}
+	byClient, err := storer.ListByClientException(ctx, "old-client")
+	if err != nil {
+		t.Fatalf("Unexpected error listing scopes by client exception: %s", err.Error())
+	}
+	if len(byClient) != 0 {
+		t.Errorf("Expected replaced exceptions to be gone, got %+v", byClient)
+	}
+	defaults, err := storer.ListDefault(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error listing default scopes: %s", err.Error())
+	}
+	if len(defaults) != 0 {
+		t.Errorf("Expected replaced scope to no longer be a default, got %+v", defaults)
+	}
+}
+
+func testWatch(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	watcher, ok := storer.(scopes.Watcher)
+	if !ok {
+		t.Skipf("%T does not implement scopes.Watcher", storer)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start, err := watcher.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving current revision: %s", err.Error())
+	}
+	events, err := watcher.Watch(ctx, start)
+	if err != nil {
+		t.Fatalf("Unexpected error watching: %s", err.Error())
+	}
+
+	scope := scopes.Scope{
+		ID:               "https://scopes.impractical.co/watched",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{uuidOrFail(t)},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{uuidOrFail(t)},
+	}
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	allow := scopes.PolicyAllowAll
+	change := scopes.Change{UserPolicy: &allow}
+	updated, err := storer.Update(ctx, scope.ID, change)
+	if err != nil {
+		t.Fatalf("Unexpected error updating scope: %s", err.Error())
+	}
+	_, err = storer.Delete(ctx, scope.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting scope: %s", err.Error())
+	}
+
+	expectations := []scopes.Event{
+		{Type: scopes.EventCreated, Scope: scope},
+		{Type: scopes.EventUpdated, Scope: updated, Previous: &scope},
+		{Type: scopes.EventDeleted, Scope: updated},
+	}
+	last := start
+	for pos, expected := range expectations {
+		var event scopes.Event
+		select {
+		case event = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %d", pos)
+		}
+		if event.Revision <= last {
+			t.Errorf("Expected event %d to have a revision greater than %d, got %d", pos, last, event.Revision)
+		}
+		last = event.Revision
+		expected.Revision = event.Revision
+		if diff := cmp.Diff(expected, event); diff != "" {
+			t.Errorf("Unexpected event %d:\n%s", pos, diff)
+		}
+	}
+
+	// watching from the start again should replay the same events
+	replay, err := watcher.Watch(ctx, start)
+	if err != nil {
+		t.Fatalf("Unexpected error watching: %s", err.Error())
+	}
+	for pos, expected := range expectations {
+		select {
+		case event := <-replay:
+			if event.Type != expected.Type || event.Scope.ID != expected.Scope.ID {
+				t.Errorf("Unexpected replayed event %d: %+v", pos, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for replayed event %d", pos)
+		}
+	}
+}
+
+func testWithTxCommits(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	transactor, ok := storer.(scopes.Transactor)
+	if !ok {
+		t.Skipf("%T does not implement scopes.Transactor", storer)
+	}
+	first := scopes.Scope{
+		ID:           "https://scopes.impractical.co/tx/first",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	second := scopes.Scope{
+		ID:           "https://scopes.impractical.co/tx/second",
+		UserPolicy:   scopes.PolicyDefaultAllow,
+		ClientPolicy: scopes.PolicyDefaultAllow,
+		IsDefault:    true,
+	}
+	allow := scopes.PolicyAllowAll
+	change := scopes.Change{UserPolicy: &allow}
+	err := transactor.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		if err := tx.Create(ctx, first); err != nil {
+			return err
+		}
+		if err := tx.Create(ctx, second); err != nil {
+			return err
+		}
+		if _, err := tx.Update(ctx, first.ID, change); err != nil {
+			return err
+		}
+
+		// changes are visible inside the transaction
+		resps, err := tx.GetMulti(ctx, []string{first.ID, second.ID})
+		if err != nil {
+			return err
+		}
+		if diff := cmp.Diff(map[string]scopes.Scope{first.ID: scopes.Apply(change, first), second.ID: second}, resps); diff != "" {
+			t.Errorf("Unexpected diff inside transaction (-wanted, +got): %s", diff)
+		}
+
+		// joining the transaction works the same way
+		nested, ok := tx.(scopes.Transactor)
+		if !ok {
+			t.Errorf("%T does not implement scopes.Transactor", tx)
+			return nil
+		}
+		return nested.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+			_, err := tx.Delete(ctx, second.ID)
+			return err
+		})
+	})
+	if errors.Is(err, scopes.ErrTxUnsupported) {
+		t.Skipf("%T can't run transactions", storer)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error in transaction: %s", err.Error())
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{first.ID, second.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{first.ID: scopes.Apply(change, first)}, resps); diff != "" {
+		t.Errorf("Unexpected diff after commit (-wanted, +got): %s", diff)
+	}
+}
+
+func testWithTxRollsBack(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	transactor, ok := storer.(scopes.Transactor)
+	if !ok {
+		t.Skipf("%T does not implement scopes.Transactor", storer)
+	}
+	existing := scopes.Scope{
+		ID:           "https://scopes.impractical.co/tx/existing",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	err := storer.Create(ctx, existing)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+	created := scopes.Scope{
+		ID:           "https://scopes.impractical.co/tx/rolled-back",
+		UserPolicy:   scopes.PolicyDefaultAllow,
+		ClientPolicy: scopes.PolicyDefaultAllow,
+	}
+	allow := scopes.PolicyAllowAll
+	errRollback := errors.New("roll it back")
+	err = transactor.WithTx(ctx, func(ctx context.Context, tx scopes.Storer) error {
+		if err := tx.Create(ctx, created); err != nil {
+			return err
+		}
+		if _, err := tx.Update(ctx, existing.ID, scopes.Change{ClientPolicy: &allow}); err != nil {
+			return err
+		}
+		// a failed operation can be rolled back too
+		if err := tx.Create(ctx, existing); !errors.Is(err, scopes.ErrScopeAlreadyExists) {
+			t.Errorf("Expected %v creating duplicate scope, got %v", scopes.ErrScopeAlreadyExists, err)
+		}
+		return errRollback
+	})
+	if errors.Is(err, scopes.ErrTxUnsupported) {
+		t.Skipf("%T can't run transactions", storer)
+	}
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Expected %v from transaction, got %v", errRollback, err)
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{existing.ID, created.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{existing.ID: existing}, resps); diff != "" {
+		t.Errorf("Unexpected diff after rollback (-wanted, +got): %s", diff)
+	}
+}
+
+func testAccessChecker(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	checker, ok := storer.(scopes.AccessChecker)
+	if !ok {
+		t.Skipf("%T does not implement scopes.AccessChecker", storer)
+	}
+	excepted := uuidOrFail(t)
+	other := uuidOrFail(t)
+	policies := []string{scopes.PolicyDenyAll, scopes.PolicyDefaultDeny, scopes.PolicyDefaultAllow, scopes.PolicyAllowAll}
+	var created []scopes.Scope
+	for pos, policy := range policies {
+		scope := scopes.Scope{
+			ID:               "https://scopes.impractical.co/access/" + strconv.Itoa(pos),
+			UserPolicy:       policy,
+			UserExceptions:   []string{other, excepted},
+			ClientPolicy:     policies[len(policies)-1-pos],
+			ClientExceptions: []string{excepted},
+		}
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err.Error())
+		}
+		created = append(created, scope)
+	}
+
+	for _, scope := range created {
+		for _, principal := range []string{excepted, other, uuidOrFail(t)} {
+			userCanUse, err := checker.UserCanUse(ctx, scope.ID, principal)
+			if errors.Is(err, scopes.ErrAccessCheckUnsupported) {
+				t.Skipf("%T can't check access", storer)
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error checking user access: %s", err.Error())
+			}
+			if expected := scopes.UserCanUseScope(ctx, scope, principal); userCanUse != expected {
+				t.Errorf("Expected user %s access to %s (%s) to be %v, got %v", principal, scope.ID, scope.UserPolicy, expected, userCanUse)
+			}
+			clientCanUse, err := checker.ClientCanUse(ctx, scope.ID, principal)
+			if err != nil {
+				t.Fatalf("Unexpected error checking client access: %s", err.Error())
+			}
+			if expected := scopes.ClientCanUseScope(ctx, scope, principal); clientCanUse != expected {
+				t.Errorf("Expected client %s access to %s (%s) to be %v, got %v", principal, scope.ID, scope.ClientPolicy, expected, clientCanUse)
+			}
+		}
+	}
+
+	// scopes that don't exist can't be used
+	canUse, err := checker.UserCanUse(ctx, "https://scopes.impractical.co/access/missing", excepted)
+	if err != nil {
+		t.Fatalf("Unexpected error checking user access: %s", err.Error())
+	}
+	if canUse {
+		t.Error("Expected user not to be able to use a nonexistent scope")
+	}
+	canUse, err = checker.ClientCanUse(ctx, "https://scopes.impractical.co/access/missing", excepted)
+	if err != nil {
+		t.Fatalf("Unexpected error checking client access: %s", err.Error())
+	}
+	if canUse {
+		t.Error("Expected client not to be able to use a nonexistent scope")
+	}
+}
+
+func testListByException(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	user, client := uuidOrFail(t), uuidOrFail(t)
+	both := scopes.Scope{
+		ID:               "https://scopes.impractical.co/excepted/both",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{uuidOrFail(t), user},
+		ClientPolicy:     scopes.PolicyDefaultAllow,
+		ClientExceptions: []string{client},
+	}
+	userOnly := scopes.Scope{
+		ID:               "https://scopes.impractical.co/excepted/a-user",
+		UserPolicy:       scopes.PolicyDefaultAllow,
+		UserExceptions:   []string{user},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{user},
+	}
+	neither := scopes.Scope{
+		ID:               "https://scopes.impractical.co/excepted/neither",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{client},
+		ClientPolicy:     scopes.PolicyAllowAll,
+		ClientExceptions: []string{uuidOrFail(t)},
+	}
+	for _, scope := range []scopes.Scope{both, userOnly, neither} {
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err.Error())
+		}
+	}
+
+	byUser, err := storer.ListByUserException(ctx, user)
+	if err != nil {
+		t.Fatalf("Unexpected error listing by user exception: %s", err.Error())
+	}
+	if diff := cmp.Diff([]scopes.Scope{userOnly, both}, byUser); diff != "" {
+		t.Errorf("Unexpected diff listing by user exception (-wanted, +got): %s", diff)
+	}
+
+	byClient, err := storer.ListByClientException(ctx, client)
+	if err != nil {
+		t.Fatalf("Unexpected error listing by client exception: %s", err.Error())
+	}
+	if diff := cmp.Diff([]scopes.Scope{both}, byClient); diff != "" {
+		t.Errorf("Unexpected diff listing by client exception (-wanted, +got): %s", diff)
+	}
+
+	// changes to exceptions are reflected in the lookup
+	newExceptions := []string{uuidOrFail(t)}
+	_, err = storer.Update(ctx, both.ID, scopes.Change{ClientExceptions: &newExceptions})
+	if err != nil {
+		t.Fatalf("Unexpected error updating scope: %s", err.Error())
+	}
+	byClient, err = storer.ListByClientException(ctx, client)
+	if err != nil {
+		t.Fatalf("Unexpected error listing by client exception: %s", err.Error())
+	}
+	if len(byClient) != 0 {
+		t.Errorf("Expected no scopes after removing exception, got %+v", byClient)
+	}
+
+	none, err := storer.ListByUserException(ctx, uuidOrFail(t))
+	if err != nil {
+		t.Fatalf("Unexpected error listing by user exception: %s", err.Error())
+	}
+	if len(none) != 0 {
+		t.Errorf("Expected no scopes for unknown user, got %+v", none)
+	}
+}
+
+func testRemoveException(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	user, client, other := uuidOrFail(t), uuidOrFail(t), uuidOrFail(t)
+	first := scopes.Scope{
+		ID:               "https://scopes.impractical.co/offboard/first",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{other, user, other},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{user, client},
+	}
+	second := scopes.Scope{
+		ID:               "https://scopes.impractical.co/offboard/second",
+		UserPolicy:       scopes.PolicyDefaultAllow,
+		UserExceptions:   []string{user},
+		ClientPolicy:     scopes.PolicyDefaultAllow,
+		ClientExceptions: []string{other},
+	}
+	untouched := scopes.Scope{
+		ID:               "https://scopes.impractical.co/offboard/untouched",
+		UserPolicy:       scopes.PolicyDefaultDeny,
+		UserExceptions:   []string{other},
+		ClientPolicy:     scopes.PolicyDefaultDeny,
+		ClientExceptions: []string{client},
+	}
+	for _, scope := range []scopes.Scope{first, second, untouched} {
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err.Error())
+		}
+	}
+
+	changed, err := storer.RemoveUserException(ctx, user)
+	if err != nil {
+		t.Fatalf("Unexpected error removing user exception: %s", err.Error())
+	}
+	first.UserExceptions = []string{other, other}
+	second.UserExceptions = []string{}
+	if diff := cmp.Diff([]scopes.Scope{first, second}, changed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff in changed scopes (-wanted, +got): %s", diff)
+	}
+
+	resps, err := storer.GetMulti(ctx, []string{first.ID, second.ID, untouched.ID})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	expected := map[string]scopes.Scope{first.ID: first, second.ID: second, untouched.ID: untouched}
+	if diff := cmp.Diff(expected, resps, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff in stored scopes (-wanted, +got): %s", diff)
+	}
+
+	// client exceptions are separate from user exceptions
+	changed, err = storer.RemoveClientException(ctx, user)
+	if err != nil {
+		t.Fatalf("Unexpected error removing client exception: %s", err.Error())
+	}
+	first.ClientExceptions = []string{client}
+	if diff := cmp.Diff([]scopes.Scope{first}, changed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff in changed scopes (-wanted, +got): %s", diff)
+	}
+
+	// removing a principal that isn't listed anywhere changes nothing
+	changed, err = storer.RemoveUserException(ctx, uuidOrFail(t))
+	if err != nil {
+		t.Fatalf("Unexpected error removing user exception: %s", err.Error())
+	}
+	if len(changed) != 0 {
+		t.Errorf("Expected no changed scopes, got %+v", changed)
+	}
+
+	auditor, ok := storer.(scopes.AuditLister)
+	if !ok {
+		return
+	}
+	entries, err := auditor.ListAuditEntries(ctx)
+	if errors.Is(err, scopes.ErrAuditUnsupported) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error listing audit entries: %s", err.Error())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %d: %+v", len(entries), entries)
+	}
+	if entries[0].Action != scopes.AuditRemoveUserException || entries[0].PrincipalID != user {
+		t.Errorf("Unexpected first audit entry: %+v", entries[0])
+	}
+	if diff := cmp.Diff([]string{first.ID, second.ID}, entries[0].ScopeIDs); diff != "" {
+		t.Errorf("Unexpected diff in audited scope IDs (-wanted, +got): %s", diff)
+	}
+	if entries[1].Action != scopes.AuditRemoveClientException || entries[1].PrincipalID != user {
+		t.Errorf("Unexpected second audit entry: %+v", entries[1])
+	}
+}
+
+func testListPaginates(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	var expected []scopes.Scope
+	for i := 0; i < 7; i++ {
+		scope := scopes.Scope{
+			ID:           "https://scopes.impractical.co/list/" + strconv.Itoa(i),
+			UserPolicy:   scopes.PolicyDefaultAllow,
+			ClientPolicy: scopes.PolicyDefaultAllow,
+		}
+		err := storer.Create(ctx, scope)
+		if err != nil {
+			t.Fatalf("Unexpected error creating scope: %s", err.Error())
+		}
+		expected = append(expected, scope)
+	}
+
+	var listed []scopes.Scope
+	var after string
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatalf("Too many pages, listed %+v", listed)
+		}
+		page, err := storer.List(ctx, after, 3)
+		if err != nil {
+			t.Fatalf("Unexpected error listing scopes: %s", err.Error())
+		}
+		if len(page) > 3 {
+			t.Fatalf("Expected at most 3 scopes, got %d", len(page))
+		}
+		listed = append(listed, page...)
+		if len(page) < 3 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+	if diff := cmp.Diff(expected, listed, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff listing scopes (-wanted, +got): %s", diff)
+	}
+}
+
+func testUsableScopes(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	user, client := uuidOrFail(t), uuidOrFail(t)
+	var all []scopes.Scope
+	policies := []string{scopes.PolicyDenyAll, scopes.PolicyDefaultDeny, scopes.PolicyDefaultAllow, scopes.PolicyAllowAll}
+	for userPos, userPolicy := range policies {
+		for clientPos, clientPolicy := range policies {
+			scope := scopes.Scope{
+				ID:           fmt.Sprintf("https://scopes.impractical.co/access-report/%d-%d", userPos, clientPos),
+				UserPolicy:   userPolicy,
+				ClientPolicy: clientPolicy,
+			}
+			// list the principals in every other scope, to
+			// exercise both sides of the DEFAULT policies
+			if (userPos+clientPos)%2 == 0 {
+				scope.UserExceptions = []string{user}
+				scope.ClientExceptions = []string{client}
+			}
+			err := storer.Create(ctx, scope)
+			if err != nil {
+				t.Fatalf("Unexpected error creating scope: %s", err.Error())
+			}
+			all = append(all, scope)
+		}
+	}
+
+	queries := []scopes.AccessQuery{
+		{UserID: user},
+		{ClientID: client},
+		{UserID: user, ClientID: client},
+	}
+	for _, query := range queries {
+		var expected []string
+		for _, scope := range all {
+			if query.UserID != "" && !scopes.UserCanUseScope(ctx, scope, query.UserID) {
+				continue
+			}
+			if query.ClientID != "" && !scopes.ClientCanUseScope(ctx, scope, query.ClientID) {
+				continue
+			}
+			expected = append(expected, scope.ID)
+		}
+
+		var got []string
+		var cursor string
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("Too many pages for %+v, got %v", query, got)
+			}
+			report, err := scopes.UsableScopes(ctx, storer, query, cursor, 2)
+			if err != nil {
+				t.Fatalf("Unexpected error generating report for %+v: %s", query, err.Error())
+			}
+			for _, scope := range report.Scopes {
+				got = append(got, scope.ID)
+			}
+			if report.Next == "" {
+				break
+			}
+			cursor = report.Next
+		}
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Errorf("Unexpected diff in usable scopes for %+v (-wanted, +got): %s", query, diff)
+		}
+	}
+
+	_, err := scopes.UsableScopes(ctx, storer, scopes.AccessQuery{}, "", 0)
+	if !errors.Is(err, scopes.ErrNoPrincipal) {
+		t.Errorf("Expected %v with no principal, got %v", scopes.ErrNoPrincipal, err)
+	}
+}
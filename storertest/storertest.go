@@ -0,0 +1,120 @@
+// Package storertest provides a conformance suite for implementations of the
+// scopes.Storer interface, so every Storer can be held to the same behavior,
+// whether it lives in this module or not.
+package storertest
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/hashicorp/go-uuid"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	changeUserPolicy = 1 << iota
+	changeUserExceptions
+	changeClientPolicy
+	changeClientExceptions
+	changeIsDefault
+	changeVariations
+)
+
+// Factory is a generator of Storers for testing purposes. Every Storer it
+// generates should be isolated from every other Storer it generates, so tests
+// can run in parallel without interfering with each other.
+type Factory interface {
+	// NewStorer returns a new, empty Storer.
+	NewStorer(ctx context.Context) (scopes.Storer, error)
+
+	// TeardownStorers cleans up after every Storer returned by
+	// NewStorer. It's called once all the tests are finished.
+	TeardownStorers() error
+}
+
+type conformanceTest struct {
+	name string
+	test func(t *testing.T, storer scopes.Storer, ctx context.Context)
+}
+
+var conformanceTests = []conformanceTest{
+	{name: "CreateAndGetScope", test: testCreateAndGetScope},
+	{name: "GetNonexistentScope", test: testGetNonexistentScope},
+	{name: "CreateDuplicateID", test: testCreateDuplicateID},
+	{name: "CreateMultipleScopes", test: testCreateMultipleScopes},
+	{name: "ListDefault", test: testListDefault},
+	{name: "UpdateOneOfMany", test: testUpdateOneOfMany},
+	{name: "UpdateNonExistent", test: testUpdateNonExistent},
+	{name: "UpdateNoChange", test: testUpdateNoChange},
+	{name: "DeleteOneOfMany", test: testDeleteOneOfMany},
+	{name: "DeleteNonExistent", test: testDeleteNonExistent},
+	{name: "PutCreatesAndReplaces", test: testPutCreatesAndReplaces},
+	{name: "Watch", test: testWatch},
+	{name: "WithTxCommits", test: testWithTxCommits},
+	{name: "WithTxRollsBack", test: testWithTxRollsBack},
+	{name: "ApplyBatch", test: testApplyBatch},
+	{name: "ApplyBatchRollsBack", test: testApplyBatchRollsBack},
+	{name: "ImportSkipsExisting", test: testImportSkipsExisting},
+	{name: "AccessChecker", test: testAccessChecker},
+	{name: "ListByException", test: testListByException},
+	{name: "RemoveException", test: testRemoveException},
+	{name: "ListPaginates", test: testListPaginates},
+	{name: "UsableScopes", test: testUsableScopes},
+
+	{name: "ConcurrentCreateSameID", test: testConcurrentCreateSameID},
+	{name: "ConcurrentCreates", test: testConcurrentCreates},
+	{name: "ConcurrentUpdates", test: testConcurrentUpdates},
+	{name: "ConcurrentRemoveException", test: testConcurrentRemoveException},
+
+	{name: "ListOrdering", test: testListOrdering},
+	{name: "PaginationOrdering", test: testPaginationOrdering},
+
+	{name: "GetMultiNoIDs", test: testGetMultiNoIDs},
+	{name: "GetMultiSomeMissing", test: testGetMultiSomeMissing},
+	{name: "CreateAfterDelete", test: testCreateAfterDelete},
+	{name: "DeleteTwice", test: testDeleteTwice},
+	{name: "ListPastEnd", test: testListPastEnd},
+	{name: "UnknownException", test: testUnknownException},
+	{name: "EmptyIDs", test: testEmptyIDs},
+}
+
+// RunConformance runs the conformance suite against Storers generated by
+// `factory`, each test in its own subtest with its own Storer. The subtests
+// run in parallel, and `factory`'s TeardownStorers method is called once
+// they're all finished.
+//
+// Optional interfaces, like scopes.Watcher and scopes.Transactor, are only
+// tested if the Storers implement them.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		err := factory.TeardownStorers()
+		if err != nil {
+			t.Errorf("Error cleaning up after %T: %s", factory, err.Error())
+		}
+	})
+	for _, test := range conformanceTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			storer, err := factory.NewStorer(ctx)
+			if err != nil {
+				t.Fatalf("Error creating Storer from %T: %s", factory, err.Error())
+			}
+			test.test(t, storer, ctx)
+		})
+	}
+}
+
+func uuidOrFail(t *testing.T) string {
+	t.Helper()
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatalf("Unexpected error generating ID: %s", err.Error())
+	}
+	return id
+}
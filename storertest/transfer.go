@@ -0,0 +1,53 @@
+package storertest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lockbox.dev/scopes"
+)
+
+func testImportSkipsExisting(t *testing.T, storer scopes.Storer, ctx context.Context) {
+	existing := scopes.Scope{
+		ID:           "https://scopes.impractical.co/import/existing",
+		UserPolicy:   scopes.PolicyDefaultDeny,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	err := storer.Create(ctx, existing)
+	if err != nil {
+		t.Fatalf("Unexpected error creating scope: %s", err.Error())
+	}
+
+	// a Scope that already exists mustn't keep the ones after it from
+	// being imported, even when the import is a single transaction
+	export := `{"version":1}
+{"id":"https://scopes.impractical.co/import/existing","userPolicy":"ALLOW_ALL","clientPolicy":"ALLOW_ALL"}
+{"id":"https://scopes.impractical.co/import/new","userPolicy":"ALLOW_ALL","clientPolicy":"DENY_ALL"}
+`
+	result, err := scopes.Import(ctx, storer, strings.NewReader(export), scopes.ConflictSkip)
+	if err != nil {
+		t.Fatalf("Unexpected error importing: %s", err.Error())
+	}
+	if diff := cmp.Diff(scopes.ImportResult{Created: 1, Skipped: 1}, result); diff != "" {
+		t.Errorf("Unexpected import result (-wanted, +got): %s", diff)
+	}
+
+	res, err := storer.GetMulti(ctx, []string{existing.ID, "https://scopes.impractical.co/import/new"})
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving scopes: %s", err.Error())
+	}
+	if diff := cmp.Diff(map[string]scopes.Scope{
+		existing.ID: existing,
+		"https://scopes.impractical.co/import/new": {
+			ID:           "https://scopes.impractical.co/import/new",
+			UserPolicy:   scopes.PolicyAllowAll,
+			ClientPolicy: scopes.PolicyDenyAll,
+		},
+	}, res, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Unexpected diff after import (-wanted, +got): %s", diff)
+	}
+}
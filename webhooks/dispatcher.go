@@ -0,0 +1,365 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"yall.in"
+
+	"lockbox.dev/hmac"
+	"lockbox.dev/scopes"
+)
+
+const (
+	// DefaultMaxAttempts is the number of times a Delivery will be
+	// attempted before it is considered dead, if no other value is set.
+	DefaultMaxAttempts = 10
+	// DefaultBaseBackoff is how long the Dispatcher waits before retrying
+	// a failed Delivery the first time, if no other value is set. The wait
+	// doubles after every failed attempt.
+	DefaultBaseBackoff = 30 * time.Second
+	// DefaultMaxBackoff is the longest the Dispatcher will wait before
+	// retrying a failed Delivery, if no other value is set.
+	DefaultMaxBackoff = 6 * time.Hour
+	// DefaultPollInterval is how often the Dispatcher checks for due
+	// Deliveries, if no other value is set.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultTimeout is how long the Dispatcher waits for a Webhook to
+	// respond to a Delivery, if no other value is set.
+	DefaultTimeout = 10 * time.Second
+
+	// deliveryBatchSize is the number of due Deliveries the Dispatcher
+	// retrieves at once.
+	deliveryBatchSize = 100
+	// maxErrorLength is the longest error message that will be recorded
+	// for a failed Delivery.
+	maxErrorLength = 1024
+)
+
+// Dispatcher watches for changes to Scopes, records Deliveries for them, and
+// makes those Deliveries to the registered Webhooks, retrying failed
+// Deliveries with exponential backoff. Only one Dispatcher should be run
+// against a Storer at a time.
+//
+// Watchers only retain a limited number of changes. If the Dispatcher falls
+// so far behind, like by not running while they're made, that changes it
+// hasn't recorded Deliveries for are discarded, it records a Delivery of a
+// resync Payload instead, and carries on from the Watcher's current revision.
+//
+// Deliveries are made one at a time, so each attempt is limited to Timeout,
+// and a Webhook that doesn't respond can't hold up the others for longer
+// than that.
+type Dispatcher struct {
+	Storer  Storer
+	Watcher scopes.Watcher
+	Client  *http.Client
+
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+
+	now func() time.Time
+}
+
+func (d *Dispatcher) setDefaults() {
+	if d.Timeout <= 0 {
+		d.Timeout = DefaultTimeout
+	}
+	if d.Client == nil {
+		d.Client = &http.Client{Timeout: d.Timeout}
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = DefaultMaxAttempts
+	}
+	if d.BaseBackoff <= 0 {
+		d.BaseBackoff = DefaultBaseBackoff
+	}
+	if d.MaxBackoff <= 0 {
+		d.MaxBackoff = DefaultMaxBackoff
+	}
+	if d.PollInterval <= 0 {
+		d.PollInterval = DefaultPollInterval
+	}
+	if d.now == nil {
+		d.now = time.Now
+	}
+}
+
+// Run records and makes Deliveries until `ctx` is canceled or Deliveries can
+// no longer be recorded.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	d.setDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	enqueueErr := make(chan error, 1)
+	go func() {
+		enqueueErr <- d.enqueue(ctx)
+		cancel()
+	}()
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		err := d.DeliverDue(ctx)
+		if err != nil {
+			yall.FromContext(ctx).WithError(err).Error("error making deliveries")
+		}
+		select {
+		case <-ctx.Done():
+			return <-enqueueErr
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue watches for changes to Scopes, starting from the Storer's cursor,
+// and records Deliveries for the notable ones.
+func (d *Dispatcher) enqueue(ctx context.Context) error {
+	cursor, err := d.Storer.Cursor(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving cursor: %w", err)
+	}
+	if cursor == 0 {
+		// don't notify Webhooks about every Scope that existed
+		// before we started watching
+		cursor, err = d.Watcher.CurrentRevision(ctx)
+		if err != nil {
+			return fmt.Errorf("error retrieving current revision: %w", err)
+		}
+		err = d.Storer.SetCursor(ctx, cursor)
+		if err != nil {
+			return fmt.Errorf("error setting cursor: %w", err)
+		}
+	}
+	for {
+		events, err := d.Watcher.Watch(ctx, cursor)
+		if errors.Is(err, scopes.ErrRevisionCompacted) {
+			cursor, err = d.resync(ctx, cursor)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error watching scopes: %w", err)
+		}
+		for event := range events {
+			if !IsNotable(event) {
+				err = d.Storer.SetCursor(ctx, event.Revision)
+			} else {
+				var body []byte
+				body, err = json.Marshal(NewPayload(event))
+				if err != nil {
+					return fmt.Errorf("error encoding payload: %w", err)
+				}
+				err = d.Storer.Enqueue(ctx, event.Revision, body, d.now())
+			}
+			if err != nil {
+				return fmt.Errorf("error recording revision %d: %w", event.Revision, err)
+			}
+			cursor = event.Revision
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		// the Watcher stopped sending events without our context
+		// being canceled, so resume watching where we left off
+	}
+}
+
+// resync records a Delivery of a resync Payload, for when changes after
+// `cursor` were discarded before Deliveries were recorded for them, and
+// moves the cursor past them to the Watcher's current revision, returning
+// it. The changes can't be recovered, so Webhooks are told to retrieve the
+// Scopes they care about again instead.
+func (d *Dispatcher) resync(ctx context.Context, cursor uint64) (uint64, error) {
+	current, err := d.Watcher.CurrentRevision(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving current revision: %w", err)
+	}
+	body, err := json.Marshal(NewResyncPayload(current))
+	if err != nil {
+		return 0, fmt.Errorf("error encoding payload: %w", err)
+	}
+	err = d.Storer.Enqueue(ctx, current, body, d.now())
+	if err != nil {
+		return 0, fmt.Errorf("error recording resync at revision %d: %w", current, err)
+	}
+	yall.FromContext(ctx).WithField("cursor", cursor).WithField("revision", current).
+		Warn("changes were discarded before deliveries were recorded for them, asked webhooks to resync")
+	return current, nil
+}
+
+// webhooksByID returns every Webhook in the Storer, keyed by their IDs.
+func (d *Dispatcher) webhooksByID(ctx context.Context) (map[string]Webhook, error) {
+	hooks, err := d.Storer.ListWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+	byID := make(map[string]Webhook, len(hooks))
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+	return byID, nil
+}
+
+// DeliverDue attempts every Delivery that is due, recording the outcome of
+// each. Deliveries to Webhooks that no longer exist are marked dead, so
+// every Delivery that's due is either attempted or taken out of the queue.
+func (d *Dispatcher) DeliverDue(ctx context.Context) error {
+	d.setDefaults()
+	byID, err := d.webhooksByID(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		due, err := d.Storer.DueDeliveries(ctx, d.now(), deliveryBatchSize)
+		if err != nil {
+			return fmt.Errorf("error retrieving due deliveries: %w", err)
+		}
+		relisted := false
+		for _, delivery := range due {
+			hook, ok := byID[delivery.WebhookID]
+			if !ok && !relisted {
+				// the webhook was created after we listed them,
+				// or deleted since; Deliveries are only enqueued
+				// for Webhooks that exist, so listing them again
+				// tells us which
+				byID, err = d.webhooksByID(ctx)
+				if err != nil {
+					return err
+				}
+				relisted = true
+				hook, ok = byID[delivery.WebhookID]
+			}
+			if !ok {
+				err = d.abandon(ctx, delivery)
+			} else {
+				err = d.attempt(ctx, hook, delivery)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(due) < deliveryBatchSize {
+			return nil
+		}
+	}
+}
+
+// abandon marks `delivery`, whose Webhook no longer exists, as dead.
+func (d *Dispatcher) abandon(ctx context.Context, delivery Delivery) error {
+	delivery.State = DeliveryDead
+	delivery.LastError = "webhook " + delivery.WebhookID + " no longer exists"
+	err := d.Storer.UpdateDelivery(ctx, delivery)
+	if err != nil {
+		return fmt.Errorf("error updating delivery %s: %w", delivery.ID, err)
+	}
+	yall.FromContext(ctx).WithField("webhook_id", delivery.WebhookID).WithField("delivery_id", delivery.ID).
+		Debug("abandoned delivery to deleted webhook")
+	return nil
+}
+
+// attempt makes `delivery` to `hook` and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, hook Webhook, delivery Delivery) error {
+	log := yall.FromContext(ctx).WithField("webhook_id", hook.ID).WithField("delivery_id", delivery.ID)
+	sendErr := d.send(ctx, hook, delivery)
+	delivery.Attempts++
+	switch {
+	case sendErr == nil:
+		delivery.State = DeliveryDelivered
+		delivery.LastError = ""
+		log.Debug("delivered webhook")
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.State = DeliveryDead
+		delivery.LastError = truncate(sendErr.Error(), maxErrorLength)
+		log.WithError(sendErr).Warn("webhook delivery failed for the last time")
+	default:
+		delivery.LastError = truncate(sendErr.Error(), maxErrorLength)
+		delivery.NextAttemptAt = d.now().Add(d.backoff(delivery.Attempts))
+		log.WithError(sendErr).Debug("webhook delivery failed, will retry")
+	}
+	err := d.Storer.UpdateDelivery(ctx, delivery)
+	if err != nil {
+		return fmt.Errorf("error updating delivery %s: %w", delivery.ID, err)
+	}
+	return nil
+}
+
+// backoff returns how long to wait before retrying a Delivery that has failed
+// `attempts` times.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return wait
+}
+
+// ContentHash returns the hash of `body` that Deliveries are signed with.
+// It is sent in the Content-SHA256 header of every Delivery.
+func ContentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// send makes a single attempt to deliver `delivery` to `hook`.
+func (d *Dispatcher) send(ctx context.Context, hook Webhook, delivery Delivery) error {
+	// the Client may not have a timeout of its own
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return fmt.Errorf("error building request: %w", err)
+	}
+	hash := ContentHash(delivery.Body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-SHA256", hash)
+	req.Header.Set("Date", d.now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Webhook-Delivery-ID", delivery.ID)
+	signer := hmac.Signer{
+		Key:    hook.ID,
+		Secret: []byte(hook.Secret),
+	}
+	req.Header.Set("Authorization", signer.Sign(req, hash))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer func() {
+		// drain the body so the connection can be reused
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			yall.FromContext(ctx).WithError(err).Debug("error draining response body")
+		}
+		if err := resp.Body.Close(); err != nil {
+			yall.FromContext(ctx).WithError(err).Error("error closing response body")
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode) //nolint:goerr113 // only recorded, never handled
+	}
+	return nil
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length]
+}
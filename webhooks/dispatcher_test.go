@@ -0,0 +1,407 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lockbox.dev/hmac"
+
+	"lockbox.dev/scopes"
+	"lockbox.dev/scopes/storers/memory"
+	"lockbox.dev/scopes/webhooks"
+)
+
+func TestDispatcherDeliversSignedPayloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	hooks, err := memory.NewWebhookStorer()
+	if err != nil {
+		t.Fatalf("Error creating webhook storer: %s", err)
+	}
+
+	hook := webhooks.Webhook{ID: "hook", Secret: "very secret"}
+	payloads := make(chan webhooks.Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Error reading body: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		signer := hmac.Signer{Key: hook.ID, Secret: []byte(hook.Secret)}
+		if err := signer.AuthenticateRequest(r, webhooks.ContentHash(body)); err != nil {
+			t.Errorf("Error authenticating delivery: %s", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload webhooks.Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Error decoding payload: %s", err)
+		}
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	hook.URL = server.URL
+	err = hooks.CreateWebhook(ctx, hook)
+	if err != nil {
+		t.Fatalf("Error creating webhook: %s", err)
+	}
+
+	// Scopes that exist before the dispatcher starts aren't delivered
+	existing := scopes.Scope{
+		ID:           "https://scopes.lockbox.dev/existing",
+		UserPolicy:   scopes.PolicyDefaultAllow,
+		ClientPolicy: scopes.PolicyDefaultAllow,
+	}
+	err = storer.Create(ctx, existing)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+
+	dispatcher := &webhooks.Dispatcher{
+		Storer:       hooks,
+		Watcher:      storer,
+		PollInterval: 10 * time.Millisecond,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.Run(ctx)
+	}()
+
+	// wait for the dispatcher to record its starting cursor, so the
+	// next change isn't mistaken for a pre-existing one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cursor, err := hooks.Cursor(ctx)
+		if err != nil {
+			t.Fatalf("Error retrieving cursor: %s", err)
+		}
+		if cursor != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for dispatcher to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	scope := scopes.Scope{
+		ID:           "https://scopes.lockbox.dev/webhooks",
+		UserPolicy:   scopes.PolicyDefaultAllow,
+		ClientPolicy: scopes.PolicyDefaultDeny,
+	}
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+
+	select {
+	case payload := <-payloads:
+		if payload.Type != string(scopes.EventCreated) {
+			t.Errorf("Expected %q payload, got %q", scopes.EventCreated, payload.Type)
+		}
+		if payload.Scope == nil || payload.Scope.ID != scope.ID {
+			t.Errorf("Expected payload for %q, got %+v", scope.ID, payload.Scope)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delivery")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from dispatcher: %s", err)
+	}
+}
+
+func TestDispatcherResyncsAfterCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storer, err := memory.NewStorer()
+	if err != nil {
+		t.Fatalf("Error creating storer: %s", err)
+	}
+	hooks, err := memory.NewWebhookStorer()
+	if err != nil {
+		t.Fatalf("Error creating webhook storer: %s", err)
+	}
+
+	payloads := make(chan webhooks.Payload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhooks.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Error decoding payload: %s", err)
+		}
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	err = hooks.CreateWebhook(ctx, webhooks.Webhook{ID: "hook", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating webhook: %s", err)
+	}
+
+	scope := scopes.Scope{
+		ID:           "https://scopes.lockbox.dev/compacted",
+		UserPolicy:   scopes.PolicyDefaultAllow,
+		ClientPolicy: scopes.PolicyDefaultAllow,
+	}
+	err = storer.Create(ctx, scope)
+	if err != nil {
+		t.Fatalf("Error creating scope: %s", err)
+	}
+	// the dispatcher saw the Scope created, then stopped while more
+	// changes were made than the Storer retains
+	err = hooks.SetCursor(ctx, 1)
+	if err != nil {
+		t.Fatalf("Error setting cursor: %s", err)
+	}
+	for i := 0; i < 1100; i++ {
+		policy := scopes.PolicyDefaultAllow
+		if i%2 == 0 {
+			policy = scopes.PolicyDefaultDeny
+		}
+		_, err = storer.Update(ctx, scope.ID, scopes.Change{UserPolicy: &policy})
+		if err != nil {
+			t.Fatalf("Error updating scope: %s", err)
+		}
+	}
+	current, err := storer.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatalf("Error retrieving current revision: %s", err)
+	}
+
+	dispatcher := &webhooks.Dispatcher{
+		Storer:       hooks,
+		Watcher:      storer,
+		PollInterval: 10 * time.Millisecond,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.Run(ctx)
+	}()
+
+	select {
+	case payload := <-payloads:
+		if payload.Type != webhooks.PayloadTypeResync || payload.Revision != current || payload.Scope != nil {
+			t.Errorf("Expected %q payload at revision %d without a scope, got %+v", webhooks.PayloadTypeResync, current, payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for resync delivery")
+	}
+
+	// the dispatcher carries on from the current revision
+	policy := scopes.PolicyAllowAll
+	_, err = storer.Update(ctx, scope.ID, scopes.Change{ClientPolicy: &policy})
+	if err != nil {
+		t.Fatalf("Error updating scope: %s", err)
+	}
+	select {
+	case payload := <-payloads:
+		if payload.Type != string(scopes.EventUpdated) || payload.Revision != current+1 {
+			t.Errorf("Expected %q payload at revision %d, got %+v", scopes.EventUpdated, current+1, payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delivery")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from dispatcher: %s", err)
+	}
+}
+
+func TestDispatcherRetriesThenDeadLetters(t *testing.T) {
+	ctx := context.Background()
+
+	hooks, err := memory.NewWebhookStorer()
+	if err != nil {
+		t.Fatalf("Error creating webhook storer: %s", err)
+	}
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err = hooks.CreateWebhook(ctx, webhooks.Webhook{ID: "hook", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating webhook: %s", err)
+	}
+	err = hooks.Enqueue(ctx, 1, []byte(`{}`), time.Now())
+	if err != nil {
+		t.Fatalf("Error enqueuing delivery: %s", err)
+	}
+	// enqueuing the same revision again must not duplicate deliveries
+	err = hooks.Enqueue(ctx, 1, []byte(`{}`), time.Now())
+	if err != nil {
+		t.Fatalf("Error enqueuing delivery: %s", err)
+	}
+
+	dispatcher := &webhooks.Dispatcher{
+		Storer:      hooks,
+		MaxAttempts: 3,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err = dispatcher.DeliverDue(ctx)
+		if err != nil {
+			t.Fatalf("Error delivering: %s", err)
+		}
+		dead, err := hooks.ListDeadDeliveries(ctx)
+		if err != nil {
+			t.Fatalf("Error listing dead deliveries: %s", err)
+		}
+		if len(dead) > 0 {
+			if len(dead) != 1 {
+				t.Fatalf("Expected 1 dead delivery, got %d: %+v", len(dead), dead)
+			}
+			if dead[0].Attempts != 3 {
+				t.Errorf("Expected 3 attempts, got %d", dead[0].Attempts)
+			}
+			if dead[0].LastError == "" {
+				t.Error("Expected last error to be recorded")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for delivery to die")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+
+	due, err := hooks.DueDeliveries(ctx, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("Error retrieving due deliveries: %s", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no due deliveries, got %+v", due)
+	}
+}
+
+// staleStorer is a webhooks.Storer whose ListWebhooks never finds any
+// Webhooks, as if they were all deleted.
+type staleStorer struct {
+	webhooks.Storer
+}
+
+func (staleStorer) ListWebhooks(_ context.Context) ([]webhooks.Webhook, error) {
+	return nil, nil
+}
+
+func TestDispatcherAbandonsDeliveriesToDeletedWebhooks(t *testing.T) {
+	ctx := context.Background()
+
+	hooks, err := memory.NewWebhookStorer()
+	if err != nil {
+		t.Fatalf("Error creating webhook storer: %s", err)
+	}
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+	}))
+	defer server.Close()
+	err = hooks.CreateWebhook(ctx, webhooks.Webhook{ID: "hook", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating webhook: %s", err)
+	}
+	// more than a full page of deliveries
+	const enqueued = 150
+	for revision := uint64(1); revision <= enqueued; revision++ {
+		err = hooks.Enqueue(ctx, revision, []byte(`{}`), time.Now())
+		if err != nil {
+			t.Fatalf("Error enqueuing delivery: %s", err)
+		}
+	}
+
+	dispatcher := &webhooks.Dispatcher{Storer: staleStorer{Storer: hooks}}
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.DeliverDue(ctx)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Error delivering: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for deliveries to deleted webhooks to be abandoned")
+	}
+
+	dead, err := hooks.ListDeadDeliveries(ctx)
+	if err != nil {
+		t.Fatalf("Error listing dead deliveries: %s", err)
+	}
+	if len(dead) != enqueued {
+		t.Errorf("Expected %d dead deliveries, got %d", enqueued, len(dead))
+	}
+	if got := atomic.LoadInt32(&attempts); got != 0 {
+		t.Errorf("Expected no requests, got %d", got)
+	}
+}
+
+func TestDispatcherTimesOut(t *testing.T) {
+	ctx := context.Background()
+
+	hooks, err := memory.NewWebhookStorer()
+	if err != nil {
+		t.Fatalf("Error creating webhook storer: %s", err)
+	}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	err = hooks.CreateWebhook(ctx, webhooks.Webhook{ID: "hook", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating webhook: %s", err)
+	}
+	err = hooks.Enqueue(ctx, 1, []byte(`{}`), time.Now())
+	if err != nil {
+		t.Fatalf("Error enqueuing delivery: %s", err)
+	}
+
+	dispatcher := &webhooks.Dispatcher{Storer: hooks, MaxAttempts: 1, Timeout: 50 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.DeliverDue(ctx)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Error delivering: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the delivery to time out")
+	}
+	dead, err := hooks.ListDeadDeliveries(ctx)
+	if err != nil {
+		t.Fatalf("Error listing dead deliveries: %s", err)
+	}
+	if len(dead) != 1 || dead[0].LastError == "" {
+		t.Errorf("Expected 1 dead delivery with an error, got %+v", dead)
+	}
+}
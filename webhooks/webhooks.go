@@ -0,0 +1,170 @@
+// Package webhooks notifies registered HTTP endpoints whenever a Scope's
+// policies or exceptions change.
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"lockbox.dev/scopes"
+)
+
+const (
+	// DeliveryPending is the state of a Delivery that has not been made
+	// successfully yet, but will be attempted again.
+	DeliveryPending = "pending"
+	// DeliveryDelivered is the state of a Delivery that was successfully
+	// made.
+	DeliveryDelivered = "delivered"
+	// DeliveryDead is the state of a Delivery that failed too many times
+	// and will not be attempted again.
+	DeliveryDead = "dead"
+
+	// PayloadTypeResync is the Type of Payloads delivered when the
+	// Dispatcher fell so far behind that changes to Scopes were discarded
+	// before it recorded Deliveries for them. Resync Payloads have no
+	// Scope; receivers should retrieve every Scope they care about again.
+	PayloadTypeResync = "resync"
+)
+
+var (
+	// ErrWebhookAlreadyExists is returned when attempting to create a
+	// Webhook that already exists.
+	ErrWebhookAlreadyExists = errors.New("webhook already exists")
+	// ErrWebhookNotFound is returned when attempting to operate on a
+	// Webhook that doesn't exist.
+	ErrWebhookNotFound = errors.New("webhook not found")
+)
+
+// Webhook is an HTTP endpoint that will be notified when Scopes change.
+// Deliveries to the endpoint are signed using ID as the key and Secret as the
+// secret.
+type Webhook struct {
+	ID        string
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// Delivery is a single notification of a Scope change to a single Webhook,
+// and the record of attempts to make it.
+type Delivery struct {
+	ID            string
+	WebhookID     string
+	Revision      uint64
+	Body          []byte
+	State         string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// Payload is the JSON body of every Delivery. Type is one of the
+// scopes.EventType values, or PayloadTypeResync.
+type Payload struct {
+	Revision uint64        `json:"revision"`
+	Type     string        `json:"type"`
+	Scope    *PayloadScope `json:"scope,omitempty"`
+	Previous *PayloadScope `json:"previous,omitempty"`
+}
+
+// PayloadScope is the JSON representation of a Scope in a Payload.
+type PayloadScope struct {
+	ID               string   `json:"id"`
+	UserPolicy       string   `json:"userPolicy"`
+	UserExceptions   []string `json:"userExceptions"`
+	ClientPolicy     string   `json:"clientPolicy"`
+	ClientExceptions []string `json:"clientExceptions"`
+	IsDefault        bool     `json:"isDefault"`
+}
+
+func payloadScope(scope scopes.Scope) PayloadScope {
+	return PayloadScope{
+		ID:               scope.ID,
+		UserPolicy:       scope.UserPolicy,
+		UserExceptions:   scope.UserExceptions,
+		ClientPolicy:     scope.ClientPolicy,
+		ClientExceptions: scope.ClientExceptions,
+		IsDefault:        scope.IsDefault,
+	}
+}
+
+// NewPayload returns the Payload describing `event`.
+func NewPayload(event scopes.Event) Payload {
+	scope := payloadScope(event.Scope)
+	res := Payload{
+		Revision: event.Revision,
+		Type:     string(event.Type),
+		Scope:    &scope,
+	}
+	if event.Previous != nil {
+		previous := payloadScope(*event.Previous)
+		res.Previous = &previous
+	}
+	return res
+}
+
+// NewResyncPayload returns the Payload telling Webhooks that the changes to
+// Scopes before `revision` that they weren't notified of were discarded.
+func NewResyncPayload(revision uint64) Payload {
+	return Payload{
+		Revision: revision,
+		Type:     PayloadTypeResync,
+	}
+}
+
+// Storer is an interface for storing and retrieving Webhooks and the
+// Deliveries to them.
+type Storer interface {
+	CreateWebhook(ctx context.Context, webhook Webhook) error
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// Enqueue atomically creates a pending Delivery of `body` to every
+	// Webhook and records `revision` as the cursor. Enqueuing the same
+	// revision more than once must not create duplicate Deliveries.
+	Enqueue(ctx context.Context, revision uint64, body []byte, at time.Time) error
+	// Cursor returns the revision of the last Scope change the Storer
+	// has seen.
+	Cursor(ctx context.Context) (uint64, error)
+	// SetCursor records `revision` as the cursor without creating any
+	// Deliveries.
+	SetCursor(ctx context.Context, revision uint64) error
+
+	// DueDeliveries returns up to `limit` pending Deliveries that should
+	// be attempted at or before `now`, oldest first.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+	// UpdateDelivery records the outcome of an attempt to make a
+	// Delivery.
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+	// ListDeadDeliveries returns all the Deliveries that will not be
+	// attempted again, newest first.
+	ListDeadDeliveries(ctx context.Context) ([]Delivery, error)
+}
+
+// IsNotable returns true if `event` changes a Scope's policies or
+// exceptions, and Webhooks should be notified of it.
+func IsNotable(event scopes.Event) bool {
+	if event.Type != scopes.EventUpdated || event.Previous == nil {
+		return true
+	}
+	prev, cur := *event.Previous, event.Scope
+	return prev.UserPolicy != cur.UserPolicy ||
+		prev.ClientPolicy != cur.ClientPolicy ||
+		!equalStrings(prev.UserExceptions, cur.UserExceptions) ||
+		!equalStrings(prev.ClientExceptions, cur.ClientExceptions)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for pos := range a {
+		if a[pos] != b[pos] {
+			return false
+		}
+	}
+	return true
+}